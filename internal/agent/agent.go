@@ -2,21 +2,24 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	pb "github.com/MoodyShoo/go-http-calculator/internal/proto"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Agent struct {
-	config *Config
-	client pb.OrchestratorServiceClient
+	config  *Config
+	client  pb.OrchestratorServiceClient
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
 }
 
 func New() *Agent {
@@ -31,76 +34,54 @@ func New() *Agent {
 	client := pb.NewOrchestratorServiceClient(conn)
 
 	return &Agent{
-		config: conf,
-		client: client,
+		config:  conf,
+		client:  client,
+		running: make(map[int64]context.CancelFunc),
 	}
 }
 
-func (a *Agent) fetchTask() (*pb.Task, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	task, err := a.client.FetchTask(ctx, &pb.TaskRequest{})
-
-	if err != nil {
-		return nil, fmt.Errorf("could not get task: %v", err)
-	}
-
-	return task.Task, nil
-}
-
-func (a *Agent) executeTask(task *pb.Task) (float64, error) {
+// executeTask выполняет задачу в режиме, указанном в ней, и возвращает результат строкой.
+func (a *Agent) executeTask(ctx context.Context, task *pb.Task) (string, error) {
 	timer := time.NewTimer(time.Duration(task.OperationTime) * time.Millisecond)
 	defer timer.Stop()
 
-	<-timer.C
-
-	arg1, err := parseArg(task.Arg1)
-	if err != nil {
-		return 0, fmt.Errorf("invalid Arg1: %v", err)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return "", errTaskCancelled
 	}
 
-	arg2, err := parseArg(task.Arg2)
-	if err != nil {
-		return 0, fmt.Errorf("invalid Arg2: %v", err)
-	}
-
-	switch task.Operation {
-	case "+":
-		return arg1 + arg2, nil
-	case "-":
-		return arg1 - arg2, nil
-	case "*":
-		return arg1 * arg2, nil
-	case "/":
-		if arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
+	for i, arg := range task.Args {
+		if strings.HasPrefix(arg, "task") {
+			return "", fmt.Errorf("invalid argument %d: agent cannot handle task references: %s", i+1, arg)
 		}
-		return arg1 / arg2, nil
-	default:
-		return 0, fmt.Errorf("unknown operation: %s", task.Operation)
 	}
+
+	return taskDomain(task).Apply(task.Operation, task.Args...)
 }
 
-// parseArg преобразует строку в число, если это возможно
-func parseArg(arg string) (float64, error) {
-	if strings.HasPrefix(arg, "task") {
-		return 0, fmt.Errorf("agent cannot handle task references: %s", arg)
-	}
-	return strconv.ParseFloat(arg, 64)
+// taskDomain возвращает режим вычислений задачи.
+func taskDomain(task *pb.Task) calculation.Domain {
+	return calculation.Domain{Mode: calculation.Mode(task.Mode), Scale: int(task.Scale)}
 }
 
-func (a *Agent) sendResult(taskId int64, result float64, taskError error) error {
+// sendResult отправляет результат оркестратору: точное значение строкой и его приближение.
+func (a *Agent) sendResult(task *pb.Task, value string, taskError error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
 	taskResult := &pb.TaskResult{
-		Id:     taskId,
-		Result: result,
+		Id:      task.Id,
+		Lease:   task.Lease,
+		AgentId: a.config.AgentId,
 	}
 
 	if taskError != nil {
 		taskResult.Error = taskError.Error()
+	} else {
+		taskResult.Value = value
+		taskResult.Result = taskDomain(task).Float(value)
+		taskResult.Imag = taskDomain(task).Imag(value)
 	}
 
 	_, err := a.client.SendResult(ctx, taskResult)
@@ -111,45 +92,155 @@ func (a *Agent) sendResult(taskId int64, result float64, taskError error) error
 	return nil
 }
 
-func (a *Agent) RunGoroutines(num int) {
-	for i := 0; i < num; i++ {
-		go func(workerID int) {
-			for {
-				// Каждый воркер агента обращается каждые две секунды (Сделал для демонстрации и дебага)
-				time.Sleep(2 * time.Second)
-
-				// Получаем задачу
-				task, err := a.fetchTask()
-				if err != nil {
-					log.Printf("Worker %d: Error fetching task: %v", workerID, err)
-					continue
-				}
+// handleTask выполняет задачу и отправляет результат оркестратору.
+func (a *Agent) handleTask(task *pb.Task) {
+	log.Printf("Received task %d (Expression %d): %s(%s)",
+		task.Id, task.ExpressionId, task.Operation, strings.Join(task.Args, ", "))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.mu.Lock()
+	a.running[task.Id] = cancel
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		delete(a.running, task.Id)
+		a.mu.Unlock()
+		cancel()
+	}()
+
+	// Выполняем задачу
+	result, err := a.executeTask(ctx, task)
+	if errors.Is(err, errTaskCancelled) {
+		log.Printf("Task %d cancelled by orchestrator", task.Id)
+		return
+	}
+	if err != nil {
+		log.Printf("Error executing task %d: %v", task.Id, err)
+	}
 
-				log.Printf("Worker %d: Received task %d (Expression %d): %s %s %s",
-					workerID, task.Id, task.ExpressionId, task.Arg1, task.Operation, task.Arg2)
+	// Отправляем результат
+	if err := a.sendResult(task, result, err); err != nil {
+		log.Printf("Error sending result for task %d: %v", task.Id, err)
+	} else {
+		log.Printf("Result for task %d sent successfully.", task.Id)
+	}
+}
 
-				// Выполняем задачу
-				result, err := a.executeTask(task)
-				if err != nil {
-					log.Printf("Worker %d: Error executing task %d: %v", workerID, task.Id, err)
-				}
+// cancelTask прерывает выполнение задачи, если она ещё выполняется.
+func (a *Agent) cancelTask(taskId int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if cancel, ok := a.running[taskId]; ok {
+		cancel()
+	}
+}
+
+// dispatch открывает поток задач, сообщает оркестратору о свободных воркерах
+// и выполняет присланные задачи, пока поток не оборвётся.
+func (a *Agent) dispatch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := a.client.Dispatch(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open dispatch stream: %v", err)
+	}
 
-				// Отправляем результат
-				if err := a.sendResult(task.Id, result, err); err != nil {
-					log.Printf("Worker %d: Error sending result for task %d: %v", workerID, task.Id, err)
-				} else {
-					log.Printf("Worker %d: Result for task %d sent successfully.", workerID, task.Id)
+	var sendMu sync.Mutex
+	releaseSlots := func(n int) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(&pb.DispatchRequest{FreeSlots: int32(n), AgentId: a.config.AgentId})
+	}
+
+	if err := releaseSlots(a.config.ComputingPower); err != nil {
+		return fmt.Errorf("could not advertise workers: %v", err)
+	}
+
+	// Перед переподключением дожидаемся задач, которые уже выполняются,
+	// чтобы не заявить оркестратору больше воркеров, чем есть на самом деле.
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("dispatch stream closed: %v", err)
+		}
+
+		switch payload := resp.Payload.(type) {
+		case *pb.DispatchResponse_Task:
+			inFlight.Add(1)
+			go func(task *pb.Task) {
+				defer inFlight.Done()
+
+				a.handleTask(task)
+				if err := releaseSlots(1); err != nil {
+					log.Printf("Error releasing worker after task %d: %v", task.Id, err)
 				}
+			}(payload.Task)
+		case *pb.DispatchResponse_CancelTaskId:
+			a.cancelTask(payload.CancelTaskId)
+		}
+	}
+}
+
+// register регистрирует агента в оркестраторе.
+func (a *Agent) register() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := a.client.Register(ctx, &pb.AgentInfo{
+		Id:             a.config.AgentId,
+		Hostname:       a.config.Hostname,
+		ComputingPower: int32(a.config.ComputingPower),
+		Version:        Version,
+	})
+	if err != nil {
+		return fmt.Errorf("could not register agent: %v", err)
+	}
+
+	return nil
+}
+
+// runHeartbeats периодически сообщает оркестратору, что агент жив.
+// Если оркестратор не знает агента (например, после перезапуска), агент регистрируется заново.
+func (a *Agent) runHeartbeats() {
+	ticker := time.NewTicker(time.Duration(a.config.HeartbeatIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := a.client.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: a.config.AgentId})
+		cancel()
+
+		if err != nil {
+			log.Printf("Heartbeat error: %v", err)
+			if err := a.register(); err != nil {
+				log.Printf("Register error: %v", err)
 			}
-		}(i + 1)
+		}
 	}
 }
 
 func (a *Agent) Run() {
-	var wt sync.WaitGroup
-	wt.Add(a.config.ComputingPower)
-	log.Printf("Agent listens: %s:%s", a.config.OrchestratorGRPCAddress, a.config.OrchestratorGRPCPort)
-	a.RunGoroutines(a.config.ComputingPower)
+	log.Printf("Agent %s listens: %s:%s", a.config.AgentId, a.config.OrchestratorGRPCAddress, a.config.OrchestratorGRPCPort)
+
+	go a.runHeartbeats()
+
+	for {
+		if err := a.register(); err != nil {
+			log.Printf("Register error: %v", err)
+			time.Sleep(reconnectDelay)
+			continue
+		}
 
-	wt.Wait()
+		if err := a.dispatch(context.Background()); err != nil {
+			log.Printf("Dispatch error: %v", err)
+		}
+
+		time.Sleep(reconnectDelay)
+	}
 }
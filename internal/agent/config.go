@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 )
@@ -9,20 +10,28 @@ type Config struct {
 	OrchestratorGRPCAddress string
 	OrchestratorGRPCPort    string
 	ComputingPower          int
+	AgentId                 string
+	Hostname                string
+	HeartbeatIntervalMs     int
 }
 
 func configFromEnv() *Config {
+	hostname, _ := os.Hostname()
+
 	config := Config{
 		OrchestratorGRPCAddress: "localhost",
 		OrchestratorGRPCPort:    "5000",
 		ComputingPower:          2,
+		AgentId:                 fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Hostname:                hostname,
+		HeartbeatIntervalMs:     5000,
 	}
 
-	if orchAddr := os.Getenv(config.OrchestratorGRPCAddress); orchAddr != "" {
+	if orchAddr := os.Getenv(OrchestratorAddressEnv); orchAddr != "" {
 		config.OrchestratorGRPCAddress = orchAddr
 	}
 
-	if orchPort := os.Getenv(config.OrchestratorGRPCPort); orchPort != "" {
+	if orchPort := os.Getenv(PortEnv); orchPort != "" {
 		config.OrchestratorGRPCPort = orchPort
 	}
 
@@ -32,5 +41,15 @@ func configFromEnv() *Config {
 		}
 	}
 
+	if agentId := os.Getenv(AgentIdEnv); agentId != "" {
+		config.AgentId = agentId
+	}
+
+	if val := os.Getenv(HeartbeatIntervalMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil && timeMs > 0 {
+			config.HeartbeatIntervalMs = timeMs
+		}
+	}
+
 	return &config
 }
@@ -1,7 +1,23 @@
 package agent
 
+import (
+	"errors"
+	"time"
+)
+
 const (
 	PortEnv                = "ORCHESTARTOR_PORT"
 	OrchestratorAddressEnv = "ORCHESTARTOR_ADDRESS"
 	ComputingPowerEnv      = "COMPUTING_POWER"
+	AgentIdEnv             = "AGENT_ID"
+	HeartbeatIntervalMsEnv = "HEARTBEAT_INTERVAL_MS"
 )
+
+// Version передаётся оркестратору при регистрации агента.
+const Version = "1.7.0"
+
+// reconnectDelay задаёт паузу перед повторным подключением к оркестратору.
+const reconnectDelay = 2 * time.Second
+
+// errTaskCancelled возвращается, если оркестратор отменил задачу во время выполнения.
+var errTaskCancelled = errors.New("task cancelled")
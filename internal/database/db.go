@@ -2,9 +2,17 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	batchrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/batch_repo"
 	expressionrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/expression_repo"
+	functionrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/function_repo"
+	resultrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/result_repo"
+	taskrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/task_repo"
 	userrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/user_repo"
+	variablerepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/variable_repo"
+	webhookrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/webhook_repo"
 	_ "modernc.org/sqlite"
 )
 
@@ -12,6 +20,12 @@ type Database struct {
 	db             *sql.DB
 	ExpressionRepo *expressionrepo.ExpressionRepo
 	UserRepo       *userrepo.UserRepo
+	TaskRepo       *taskrepo.TaskRepo
+	VariableRepo   *variablerepo.VariableRepo
+	FunctionRepo   *functionrepo.FunctionRepo
+	ResultRepo     *resultrepo.ResultRepo
+	WebhookRepo    *webhookrepo.WebhookRepo
+	BatchRepo      *batchrepo.BatchRepo
 }
 
 func (d *Database) createTables() error {
@@ -32,9 +46,134 @@ func (d *Database) createTables() error {
 		result REAL,
 		error TEXT,
 		user_id INTEGER NOT NULL,
+		variables TEXT NOT NULL DEFAULT '{}',
+		mode TEXT NOT NULL DEFAULT '',
+		scale INTEGER NOT NULL DEFAULT 0,
+		value TEXT NOT NULL DEFAULT '',
+		functions TEXT NOT NULL DEFAULT '[]',
+		tasks_saved INTEGER NOT NULL DEFAULT 0,
+		critical_path_ms INTEGER NOT NULL DEFAULT 0,
+		callback_url TEXT NOT NULL DEFAULT '',
+		batch_id INTEGER NOT NULL DEFAULT 0,
 	
 		FOREIGN KEY (user_id)  REFERENCES  users (id)
 	);`
+
+		variablesTable = `
+	CREATE TABLE IF NOT EXISTS variables(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+
+		UNIQUE (user_id, name),
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+		functionsTable = `
+	CREATE TABLE IF NOT EXISTS functions(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		params TEXT NOT NULL,
+		body TEXT NOT NULL,
+
+		UNIQUE (user_id, name),
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+		// Рёбра графа зависимостей задач: dependent_id ссылается на результат task_id
+		taskEdgesTable = `
+	CREATE TABLE IF NOT EXISTS task_edges(
+		task_id INTEGER NOT NULL,
+		dependent_id INTEGER NOT NULL,
+
+		PRIMARY KEY (task_id, dependent_id),
+		FOREIGN KEY (task_id) REFERENCES tasks (id),
+		FOREIGN KEY (dependent_id) REFERENCES tasks (id)
+	);`
+
+		// Готовые задачи ищутся по индексу, а не перебором всех ожидающих
+		readyIndex = `CREATE INDEX IF NOT EXISTS tasks_ready ON tasks (status, dependencies, id);`
+
+		// Задачи веток ищутся по условию, которое их открывает
+		conditionIndex = `CREATE INDEX IF NOT EXISTS tasks_condition ON tasks (condition_id) WHERE condition_id != 0;`
+
+		resultsTable = `
+	CREATE TABLE IF NOT EXISTS results(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT UNIQUE NOT NULL,
+		value TEXT NOT NULL,
+		accessed_at INTEGER NOT NULL DEFAULT 0
+	);`
+
+		// Секреты, которыми подписываются webhook пользователя
+		webhookSecretsTable = `
+	CREATE TABLE IF NOT EXISTS webhook_secrets(
+		user_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+		// Очередь доставки webhook: записи остаются после доставки и показываются пользователю
+		webhookDeliveriesTable = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		expression_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		response_code INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		delivered_at INTEGER,
+
+		FOREIGN KEY (user_id) REFERENCES users (id),
+		FOREIGN KEY (expression_id) REFERENCES expressions (id)
+	);`
+
+		dueDeliveriesIndex = `CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);`
+
+		batchesTable = `
+	CREATE TABLE IF NOT EXISTS batches(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+		batchIndex = `CREATE INDEX IF NOT EXISTS expressions_batch ON expressions (batch_id) WHERE batch_id != 0;`
+
+		tasksTable = `
+	CREATE TABLE IF NOT EXISTS tasks(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		expression_id INTEGER NOT NULL,
+		args TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		operation_time INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		result REAL NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		mode TEXT NOT NULL DEFAULT '',
+		scale INTEGER NOT NULL DEFAULT 0,
+		value TEXT NOT NULL DEFAULT '',
+		leased_at INTEGER,
+		lease INTEGER NOT NULL DEFAULT 0,
+		lease_expires_at INTEGER,
+		agent_id TEXT NOT NULL DEFAULT '',
+		condition_id INTEGER NOT NULL DEFAULT 0,
+		branch TEXT NOT NULL DEFAULT '',
+		dependencies INTEGER NOT NULL DEFAULT 0,
+		operands TEXT NOT NULL DEFAULT '[]',
+		finished_at INTEGER,
+
+		FOREIGN KEY (expression_id) REFERENCES expressions (id)
+	);`
 	)
 
 	if _, err := d.db.Exec(usersTable); err != nil {
@@ -45,7 +184,171 @@ func (d *Database) createTables() error {
 		return err
 	}
 
-	return nil
+	if _, err := d.db.Exec(tasksTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(variablesTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(functionsTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(resultsTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(taskEdgesTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(webhookSecretsTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(webhookDeliveriesTable); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(dueDeliveriesIndex); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(batchesTable); err != nil {
+		return err
+	}
+
+	// Колонки, добавленные в таблицы после их создания: старые базы получают их при запуске
+	columns := []struct{ table, column, definition string }{
+		{"expressions", "variables", `TEXT NOT NULL DEFAULT '{}'`},
+		{"expressions", "mode", `TEXT NOT NULL DEFAULT ''`},
+		{"expressions", "scale", `INTEGER NOT NULL DEFAULT 0`},
+		{"expressions", "value", `TEXT NOT NULL DEFAULT ''`},
+		{"expressions", "functions", `TEXT NOT NULL DEFAULT '[]'`},
+		{"expressions", "tasks_saved", `INTEGER NOT NULL DEFAULT 0`},
+		{"expressions", "critical_path_ms", `INTEGER NOT NULL DEFAULT 0`},
+		{"expressions", "callback_url", `TEXT NOT NULL DEFAULT ''`},
+		{"expressions", "batch_id", `INTEGER NOT NULL DEFAULT 0`},
+		{"results", "accessed_at", `INTEGER NOT NULL DEFAULT 0`},
+		{"tasks", "mode", `TEXT NOT NULL DEFAULT ''`},
+		{"tasks", "scale", `INTEGER NOT NULL DEFAULT 0`},
+		{"tasks", "value", `TEXT NOT NULL DEFAULT ''`},
+		{"tasks", "condition_id", `INTEGER NOT NULL DEFAULT 0`},
+		{"tasks", "branch", `TEXT NOT NULL DEFAULT ''`},
+		{"tasks", "dependencies", `INTEGER NOT NULL DEFAULT 0`},
+		{"tasks", "operands", `TEXT NOT NULL DEFAULT '[]'`},
+		{"tasks", "finished_at", `INTEGER`},
+	}
+
+	for _, c := range columns {
+		if err := d.addColumn(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := d.migrateTaskArgs(); err != nil {
+		return fmt.Errorf("failed to migrate task arguments: %v", err)
+	}
+
+	if _, err := d.db.Exec(readyIndex); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(batchIndex); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(conditionIndex); err != nil {
+		return err
+	}
+
+	return d.TaskRepo.RebuildEdges()
+}
+
+// addColumn добавляет колонку в существующую таблицу, если её там ещё нет.
+func (d *Database) addColumn(table, column, definition string) error {
+	var count int
+	query := `SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2`
+
+	if err := d.db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err := d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// migrateTaskArgs переносит аргументы задач из колонок arg1 и arg2, в которых их хранили базы,
+// созданные до появления функций, в JSON-массив args и удаляет старые колонки.
+// Всё выполняется в одной транзакции: при ошибке база остаётся в старом виде, а сервер не запускается.
+func (d *Database) migrateTaskArgs() error {
+	var count int
+	query := `SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name = 'arg1'`
+
+	if err := d.db.QueryRow(query).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// У унарных задач второй аргумент был пустым
+	statements := []string{
+		`ALTER TABLE tasks ADD COLUMN args TEXT NOT NULL DEFAULT '[]'`,
+		`UPDATE tasks SET args = CASE WHEN arg2 = '' THEN json_array(arg1) ELSE json_array(arg1, arg2) END`,
+		`ALTER TABLE tasks DROP COLUMN arg1`,
+		`ALTER TABLE tasks DROP COLUMN arg2`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// repositories возвращает базу, репозитории которой работают через q: саму базу db или транзакцию в ней.
+func repositories(db *sql.DB, q repository.Querier) *Database {
+	return &Database{
+		db:             db,
+		ExpressionRepo: &expressionrepo.ExpressionRepo{Db: q},
+		UserRepo:       &userrepo.UserRepo{Db: q},
+		TaskRepo:       &taskrepo.TaskRepo{Db: q},
+		VariableRepo:   &variablerepo.VariableRepo{Db: q},
+		FunctionRepo:   &functionrepo.FunctionRepo{Db: q},
+		ResultRepo:     &resultrepo.ResultRepo{Db: q},
+		WebhookRepo:    &webhookrepo.WebhookRepo{Db: q},
+		BatchRepo:      &batchrepo.BatchRepo{Db: q},
+	}
+}
+
+// Transaction выполняет fn в одной транзакции: все репозитории tx работают через неё.
+// Если fn вернула ошибку, изменения откатываются. Пока fn выполняется, нельзя обращаться
+// к базе в обход tx: у базы в памяти одно соединение, и его занимает транзакция.
+func (d *Database) Transaction(fn func(tx *Database) error) error {
+	sqlTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(repositories(d.db, sqlTx)); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+
+	return sqlTx.Commit()
 }
 
 func NewInMemoryDatabase() (*Database, error) {
@@ -54,16 +357,11 @@ func NewInMemoryDatabase() (*Database, error) {
 		return nil, err
 	}
 
-	database := &Database{
-		db: db,
-		ExpressionRepo: &expressionrepo.ExpressionRepo{
-			Db: db,
-		},
-		UserRepo: &userrepo.UserRepo{
-			Db: db,
-		},
-	}
+	// Каждое соединение к ":memory:" открывает свою пустую базу,
+	// поэтому все запросы должны идти через одно соединение.
+	db.SetMaxOpenConns(1)
 
+	database := repositories(db, db)
 	if err = database.createTables(); err != nil {
 		return nil, err
 	}
@@ -77,16 +375,7 @@ func NewDatabase() (*Database, error) {
 		return nil, err
 	}
 
-	database := &Database{
-		db: db,
-		ExpressionRepo: &expressionrepo.ExpressionRepo{
-			Db: db,
-		},
-		UserRepo: &userrepo.UserRepo{
-			Db: db,
-		},
-	}
-
+	database := repositories(db, db)
 	if err = database.createTables(); err != nil {
 		return nil, err
 	}
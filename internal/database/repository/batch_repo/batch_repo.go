@@ -0,0 +1,38 @@
+package batchrepo
+
+import (
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+type BatchRepo struct {
+	Db repository.Querier
+}
+
+// InsertBatch создаёт пакет пользователя. Выражения пакета ссылаются на него через batch_id.
+func (br *BatchRepo) InsertBatch(batch models.Batch) (int64, error) {
+	query := `INSERT INTO batches (user_id, created_at) VALUES ($1, $2)`
+
+	result, err := br.Db.Exec(query, batch.UserID, batch.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetBatchByIDByUser возвращает пакет пользователя без выражений и счётчиков.
+func (br *BatchRepo) GetBatchByIDByUser(id, userId int64) (models.Batch, error) {
+	batch := models.Batch{}
+	var created int64
+
+	query := `SELECT id, user_id, created_at FROM batches WHERE id = $1 AND user_id = $2`
+	if err := br.Db.QueryRow(query, id, userId).Scan(&batch.Id, &batch.UserID, &created); err != nil {
+		return models.Batch{}, err
+	}
+
+	batch.CreatedAt = time.UnixMilli(created)
+	return batch, nil
+}
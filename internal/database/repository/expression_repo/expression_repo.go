@@ -1,20 +1,95 @@
 package expressionrepo
 
 import (
-	"database/sql"
+	"encoding/json"
+	"fmt"
 
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
 	"github.com/MoodyShoo/go-http-calculator/internal/models"
 )
 
+const expressionColumns = `id, expression, status, result, error, user_id, variables, mode, scale, value, functions, tasks_saved, critical_path_ms, callback_url, batch_id`
+
 type ExpressionRepo struct {
-	Db *sql.DB
+	Db repository.Querier
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExpression(s scanner) (models.Expression, error) {
+	e := models.Expression{}
+	var variables, functions string
+
+	err := s.Scan(&e.Id, &e.Expr, &e.Status, &e.Result, &e.Error, &e.UserID, &variables, &e.Mode, &e.Scale, &e.Value,
+		&functions, &e.TasksSaved, &e.CriticalPathMs, &e.CallbackURL, &e.BatchId)
+	if err != nil {
+		return models.Expression{}, err
+	}
+
+	if err := json.Unmarshal([]byte(variables), &e.Variables); err != nil {
+		return models.Expression{}, fmt.Errorf("invalid variables of expression %d: %v", e.Id, err)
+	}
+
+	if len(e.Variables) == 0 {
+		e.Variables = nil
+	}
+
+	if err := json.Unmarshal([]byte(functions), &e.Functions); err != nil {
+		return models.Expression{}, fmt.Errorf("invalid functions of expression %d: %v", e.Id, err)
+	}
+
+	if len(e.Functions) == 0 {
+		e.Functions = nil
+	}
+
+	return e, nil
+}
+
+// encodeVariables сохраняет подставленные переменные как JSON-объект.
+func encodeVariables(variables map[string]float64) (string, error) {
+	if variables == nil {
+		variables = map[string]float64{}
+	}
+
+	data, err := json.Marshal(variables)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// encodeFunctions сохраняет использованные пользовательские функции как JSON-массив.
+func encodeFunctions(functions []models.Function) (string, error) {
+	if functions == nil {
+		functions = []models.Function{}
+	}
+
+	data, err := json.Marshal(functions)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
 }
 
 func (er *ExpressionRepo) InsertExpression(exp models.Expression) (int64, error) {
-	query := `INSERT INTO expressions (expression, status, result, error, user_id)
-				VALUES ($1, $2, $3, $4, $5)`
+	variables, err := encodeVariables(exp.Variables)
+	if err != nil {
+		return 0, err
+	}
 
-	result, err := er.Db.Exec(query, exp.Expr, exp.Status, exp.Result, exp.Error, exp.UserID)
+	functions, err := encodeFunctions(exp.Functions)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO expressions (expression, status, result, error, user_id, variables, mode, scale, value,
+				functions, tasks_saved, critical_path_ms, callback_url, batch_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+
+	result, err := er.Db.Exec(query, exp.Expr, exp.Status, exp.Result, exp.Error, exp.UserID, variables,
+		exp.Mode, exp.Scale, exp.Value, functions, exp.TasksSaved, exp.CriticalPathMs, exp.CallbackURL, exp.BatchId)
 	if err != nil {
 		return 0, err
 	}
@@ -29,10 +104,10 @@ func (er *ExpressionRepo) InsertExpression(exp models.Expression) (int64, error)
 
 func (er *ExpressionRepo) UpdateExpression(id int64, newExpr models.Expression) error {
 	query := `UPDATE expressions 
-			  SET status = $1, result = $2, error = $3 
-			  WHERE id = $4`
+			  SET status = $1, result = $2, error = $3, value = $4
+			  WHERE id = $5`
 
-	_, err := er.Db.Exec(query, newExpr.Status, newExpr.Result, newExpr.Error, id)
+	_, err := er.Db.Exec(query, newExpr.Status, newExpr.Result, newExpr.Error, newExpr.Value, id)
 	if err != nil {
 		return err
 	}
@@ -41,42 +116,52 @@ func (er *ExpressionRepo) UpdateExpression(id int64, newExpr models.Expression)
 }
 
 func (er *ExpressionRepo) GetExpressionByIDByUser(id, userId int64) (models.Expression, error) {
-	e := models.Expression{}
-	query := `SELECT * FROM expressions WHERE id = $1 AND user_id = $2`
+	query := `SELECT ` + expressionColumns + ` FROM expressions WHERE id = $1 AND user_id = $2`
 
-	err := er.Db.QueryRow(query, id, userId).Scan(&e.Id, &e.Expr, &e.Status, &e.Result, &e.Error, &e.UserID)
-	if err != nil {
-		return models.Expression{}, err
-	}
-
-	return e, nil
+	return scanExpression(er.Db.QueryRow(query, id, userId))
 }
 
 func (er *ExpressionRepo) GetExpressionByID(id int64) (models.Expression, error) {
-	e := models.Expression{}
-	query := `SELECT * FROM expressions WHERE id = $1`
+	query := `SELECT ` + expressionColumns + ` FROM expressions WHERE id = $1`
+
+	return scanExpression(er.Db.QueryRow(query, id))
+}
+
+func (er *ExpressionRepo) GetExpressionsByUser(userId int64) ([]models.Expression, error) {
+	var expressions []models.Expression
+	query := "SELECT " + expressionColumns + " FROM expressions WHERE user_id = $1"
 
-	err := er.Db.QueryRow(query, id).Scan(&e.Id, &e.Expr, &e.Status, &e.Result, &e.Error, &e.UserID)
+	rows, err := er.Db.Query(query, userId)
 	if err != nil {
-		return models.Expression{}, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return e, nil
+	for rows.Next() {
+		e, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		expressions = append(expressions, e)
+	}
+
+	return expressions, nil
 }
 
-func (er *ExpressionRepo) GetExpressionsByUser(userId int64) ([]models.Expression, error) {
+// GetExpressionsByBatch возвращает выражения пакета в порядке их создания.
+func (er *ExpressionRepo) GetExpressionsByBatch(batchId int64) ([]models.Expression, error) {
 	var expressions []models.Expression
-	query := "SELECT * FROM expressions WHERE user_id = $1"
+	query := "SELECT " + expressionColumns + " FROM expressions WHERE batch_id = $1 ORDER BY id"
 
-	rows, err := er.Db.Query(query, userId)
+	rows, err := er.Db.Query(query, batchId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		e := models.Expression{}
-		err := rows.Scan(&e.Id, &e.Expr, &e.Status, &e.Result, &e.Error, &e.UserID)
+		e, err := scanExpression(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -87,9 +172,11 @@ func (er *ExpressionRepo) GetExpressionsByUser(userId int64) ([]models.Expressio
 	return expressions, nil
 }
 
-func (er *ExpressionRepo) GetComputingAndPending() ([]models.Expression, error) {
+// GetUnscheduled возвращает незавершённые выражения, для которых ещё не созданы задачи.
+func (er *ExpressionRepo) GetUnscheduled() ([]models.Expression, error) {
 	var expressions []models.Expression
-	query := `SELECT * FROM expressions WHERE status = $1 OR status = $2`
+	query := `SELECT ` + expressionColumns + ` FROM expressions
+			  WHERE (status = $1 OR status = $2) AND id NOT IN (SELECT expression_id FROM tasks)`
 
 	rows, err := er.Db.Query(query, models.StatusComputing, models.StatusPending)
 	if err != nil {
@@ -98,8 +185,7 @@ func (er *ExpressionRepo) GetComputingAndPending() ([]models.Expression, error)
 	defer rows.Close()
 
 	for rows.Next() {
-		e := models.Expression{}
-		err := rows.Scan(&e.Id, &e.Expr, &e.Status, &e.Result, &e.Error, &e.UserID)
+		e, err := scanExpression(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,72 @@
+package functionrepo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+type FunctionRepo struct {
+	Db repository.Querier
+}
+
+// SetFunction сохраняет функцию пользователя. Если функция уже есть, её параметры и тело заменяются.
+func (fr *FunctionRepo) SetFunction(userId int64, function models.Function) error {
+	params, err := encodeParams(function.Params)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO functions (user_id, name, params, body) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (user_id, name) DO UPDATE SET params = excluded.params, body = excluded.body`
+
+	_, err = fr.Db.Exec(query, userId, function.Name, params, function.Body)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetFunctionsByUser возвращает функции пользователя, отсортированные по имени.
+func (fr *FunctionRepo) GetFunctionsByUser(userId int64) ([]models.Function, error) {
+	var functions []models.Function
+	query := `SELECT name, params, body FROM functions WHERE user_id = $1 ORDER BY name`
+
+	rows, err := fr.Db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := models.Function{}
+		var params string
+		if err := rows.Scan(&f.Name, &params, &f.Body); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(params), &f.Params); err != nil {
+			return nil, fmt.Errorf("invalid params of function %s: %v", f.Name, err)
+		}
+
+		functions = append(functions, f)
+	}
+
+	return functions, rows.Err()
+}
+
+// encodeParams сохраняет параметры функции как JSON-массив строк.
+func encodeParams(params []string) (string, error) {
+	if params == nil {
+		params = []string{}
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
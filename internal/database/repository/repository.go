@@ -0,0 +1,11 @@
+package repository
+
+import "database/sql"
+
+// Querier - общее у *sql.DB и *sql.Tx. Репозитории работают через него,
+// поэтому их можно использовать и внутри транзакции (см. database.Database.Transaction).
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
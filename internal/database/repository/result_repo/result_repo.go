@@ -0,0 +1,68 @@
+package resultrepo
+
+import (
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+type ResultRepo struct {
+	Db repository.Querier
+}
+
+// SetResult сохраняет результат операции и время обращения к нему. Повторное сохранение того же ключа заменяет значение.
+func (rr *ResultRepo) SetResult(result models.CachedResult, accessedAt time.Time) error {
+	query := `INSERT INTO results (key, value, accessed_at) VALUES ($1, $2, $3)
+			  ON CONFLICT (key) DO UPDATE SET value = excluded.value, accessed_at = excluded.accessed_at`
+
+	_, err := rr.Db.Exec(query, result.Key, result.Value, accessedAt.UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TouchResult запоминает время последнего обращения к результату.
+func (rr *ResultRepo) TouchResult(key string, accessedAt time.Time) error {
+	_, err := rr.Db.Exec(`UPDATE results SET accessed_at = $1 WHERE key = $2`, accessedAt.UnixMilli(), key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteResult удаляет результат операции.
+func (rr *ResultRepo) DeleteResult(key string) error {
+	_, err := rr.Db.Exec(`DELETE FROM results WHERE key = $1`, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRecentResults возвращает не больше limit результатов, к которым обращались последними, от новых к старым.
+func (rr *ResultRepo) GetRecentResults(limit int) ([]models.CachedResult, error) {
+	var results []models.CachedResult
+	query := `SELECT key, value FROM results ORDER BY accessed_at DESC, id DESC LIMIT $1`
+
+	rows, err := rr.Db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := models.CachedResult{}
+		if err := rows.Scan(&r.Key, &r.Value); err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
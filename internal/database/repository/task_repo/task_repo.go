@@ -0,0 +1,386 @@
+package taskrepo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+const taskColumns = `id, expression_id, args, operation, operation_time, status, result, error, leased_at, lease, lease_expires_at, agent_id, mode, scale, value, condition_id, branch, dependencies, operands, finished_at`
+
+type TaskRepo struct {
+	Db repository.Querier
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(s scanner) (models.Task, error) {
+	t := models.Task{}
+	var args, operands string
+	var leasedAt, leaseExpires, finishedAt sql.NullInt64
+
+	err := s.Scan(&t.Id, &t.ExpressionId, &args, &t.Operation, &t.OperationTime,
+		&t.Status, &t.Result, &t.Error, &leasedAt, &t.Lease, &leaseExpires, &t.AgentId, &t.Mode, &t.Scale, &t.Value,
+		&t.ConditionId, &t.Branch, &t.Dependencies, &operands, &finishedAt)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	if err := json.Unmarshal([]byte(args), &t.Args); err != nil {
+		return models.Task{}, fmt.Errorf("invalid args of task %d: %v", t.Id, err)
+	}
+
+	if err := json.Unmarshal([]byte(operands), &t.Operands); err != nil {
+		return models.Task{}, fmt.Errorf("invalid operands of task %d: %v", t.Id, err)
+	}
+
+	// Задачи, сохранённые до появления operands, знают только текущие аргументы
+	if len(t.Operands) == 0 {
+		t.Operands = t.Args
+	}
+
+	if leasedAt.Valid {
+		t.LeasedAt = time.UnixMilli(leasedAt.Int64)
+	}
+
+	if leaseExpires.Valid {
+		t.LeaseExpires = time.UnixMilli(leaseExpires.Int64)
+	}
+
+	if finishedAt.Valid {
+		t.FinishedAt = time.UnixMilli(finishedAt.Int64)
+	}
+
+	return t, nil
+}
+
+// encodeArgs сохраняет аргументы задачи как JSON-массив строк.
+func encodeArgs(args []string) (string, error) {
+	if args == nil {
+		args = []string{}
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// isReference проверяет, является ли аргумент ссылкой task{id} на результат другой задачи.
+func isReference(arg string) bool {
+	return strings.HasPrefix(arg, "task")
+}
+
+// countDependencies возвращает число разных задач, на которые ссылаются аргументы.
+func countDependencies(args []string) int {
+	refs := make(map[string]bool)
+	for _, arg := range args {
+		if isReference(arg) {
+			refs[arg] = true
+		}
+	}
+	return len(refs)
+}
+
+// nullTime переводит время в миллисекунды для хранения, нулевое время хранится как NULL.
+func nullTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+// InsertTask сохраняет новую задачу. Её аргументы сохраняются ещё и как operands, которые потом не меняются.
+func (tr *TaskRepo) InsertTask(task models.Task) (int64, error) {
+	args, err := encodeArgs(task.Args)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO tasks (expression_id, args, operation, operation_time, status, result, error,
+				leased_at, lease, lease_expires_at, agent_id, mode, scale, value, condition_id, branch, dependencies,
+				operands, finished_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`
+
+	result, err := tr.Db.Exec(query, task.ExpressionId, args, task.Operation, task.OperationTime,
+		task.Status, task.Result, task.Error, nullTime(task.LeasedAt), task.Lease, nullTime(task.LeaseExpires), task.AgentId,
+		task.Mode, task.Scale, task.Value, task.ConditionId, task.Branch, countDependencies(task.Args),
+		args, nullTime(task.FinishedAt))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// InsertEdge добавляет в граф выражения ребро: задача dependentId ждёт результата задачи taskId.
+func (tr *TaskRepo) InsertEdge(taskId, dependentId int64) error {
+	query := `INSERT OR IGNORE INTO task_edges (task_id, dependent_id) VALUES ($1, $2)`
+
+	_, err := tr.Db.Exec(query, taskId, dependentId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateTask сохраняет изменения задачи. Число зависимостей пересчитывается по аргументам:
+// например, у задачи if после выбора ветки остаётся одна ссылка вместо трёх.
+func (tr *TaskRepo) UpdateTask(task models.Task) error {
+	args, err := encodeArgs(task.Args)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE tasks
+			  SET args = $1, status = $2, result = $3, error = $4,
+				  leased_at = $5, lease = $6, lease_expires_at = $7, agent_id = $8, value = $9, dependencies = $10,
+				  finished_at = $11
+			  WHERE id = $12`
+
+	_, err = tr.Db.Exec(query, args, task.Status, task.Result, task.Error,
+		nullTime(task.LeasedAt), task.Lease, nullTime(task.LeaseExpires), task.AgentId, task.Value,
+		countDependencies(task.Args), nullTime(task.FinishedAt), task.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tr *TaskRepo) GetTaskByID(id int64) (models.Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE id = $1`
+
+	return scanTask(tr.Db.QueryRow(query, id))
+}
+
+// GetReadyTask возвращает самую старую задачу в очереди, у которой не осталось невыполненных зависимостей.
+// Поиск идёт по индексу tasks_ready и не зависит от числа ожидающих задач.
+// Условные задачи (if) выполняет сам оркестратор, агентам они не выдаются.
+func (tr *TaskRepo) GetReadyTask() (models.Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks INDEXED BY tasks_ready
+			  WHERE status = $1 AND dependencies = 0 AND operation != $2
+			  ORDER BY id LIMIT 1`
+
+	return scanTask(tr.Db.QueryRow(query, models.StatusPending, calculation.Conditional))
+}
+
+func (tr *TaskRepo) GetTasksByExpression(expressionId int64) ([]models.Task, error) {
+	var tasks []models.Task
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE expression_id = $1 ORDER BY id`
+
+	rows, err := tr.Db.Query(query, expressionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
+// GetTasksByCondition возвращает задачи веток условия conditionId в порядке id.
+// Поиск идёт по индексу tasks_condition, поэтому не зависит от числа задач выражения.
+func (tr *TaskRepo) GetTasksByCondition(conditionId int64) ([]models.Task, error) {
+	var tasks []models.Task
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE condition_id = $1 ORDER BY id`
+
+	rows, err := tr.Db.Query(query, conditionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
+// ResolveReference подставляет результат задачи taskId вместо ссылки task{id} в ожидающие её задачи
+// (по рёбрам графа из task_edges) и уменьшает у них число зависимостей.
+func (tr *TaskRepo) ResolveReference(expressionId, taskId int64, value string) error {
+	// Аргументы хранятся как JSON-массив, поэтому ссылка ищется вместе с кавычками,
+	// чтобы task1 не совпала с task12
+	ref := fmt.Sprintf(`"task%d"`, taskId)
+	query := `UPDATE tasks
+			  SET args = REPLACE(args, $1, $2), dependencies = dependencies - 1
+			  WHERE expression_id = $3 AND status IN ($4, $5) AND INSTR(args, $1) > 0
+				AND id IN (SELECT dependent_id FROM task_edges WHERE task_id = $6)`
+
+	quoted, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = tr.Db.Exec(query, ref, string(quoted), expressionId, models.StatusPending, models.StatusBlocked, taskId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountUnfinished возвращает количество задач выражения, которые ещё не выполнены.
+func (tr *TaskRepo) CountUnfinished(expressionId int64) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM tasks WHERE expression_id = $1 AND status NOT IN ($2, $3, $4, $5)`
+
+	err := tr.Db.QueryRow(query, expressionId,
+		models.StatusDone, models.StatusError, models.StatusCancelled, models.StatusSkipped).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ReleaseExpired возвращает в очередь задачи, аренда которых истекла к моменту now, и снимает их с агентов.
+func (tr *TaskRepo) ReleaseExpired(now time.Time) (int64, error) {
+	query := `UPDATE tasks
+			  SET status = $1, lease_expires_at = NULL, agent_id = ''
+			  WHERE status = $2 AND lease_expires_at < $3`
+
+	result, err := tr.Db.Exec(query, models.StatusPending, models.StatusComputing, now.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetInFlightByAgent возвращает идентификаторы выполняющихся задач, сгруппированные по агентам.
+func (tr *TaskRepo) GetInFlightByAgent() (map[string][]int64, error) {
+	inFlight := make(map[string][]int64)
+	query := `SELECT agent_id, id FROM tasks WHERE status = $1 ORDER BY id`
+
+	rows, err := tr.Db.Query(query, models.StatusComputing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentId string
+		var id int64
+		if err := rows.Scan(&agentId, &id); err != nil {
+			return nil, err
+		}
+
+		inFlight[agentId] = append(inFlight[agentId], id)
+	}
+
+	return inFlight, rows.Err()
+}
+
+// CountCompletedByAgent возвращает количество задач, выполненных каждым агентом.
+func (tr *TaskRepo) CountCompletedByAgent() (map[string]int64, error) {
+	completed := make(map[string]int64)
+	query := `SELECT agent_id, COUNT(*) FROM tasks WHERE status = $1 OR status = $2 GROUP BY agent_id`
+
+	rows, err := tr.Db.Query(query, models.StatusDone, models.StatusError)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var agentId string
+		var count int64
+		if err := rows.Scan(&agentId, &count); err != nil {
+			return nil, err
+		}
+
+		completed[agentId] = count
+	}
+
+	return completed, rows.Err()
+}
+
+// CancelByExpression отменяет все незавершённые задачи выражения и отмечает их завершёнными в finishedAt.
+func (tr *TaskRepo) CancelByExpression(expressionId int64, finishedAt time.Time) error {
+	query := `UPDATE tasks
+			  SET status = $1, lease_expires_at = NULL, finished_at = $2
+			  WHERE expression_id = $3 AND status IN ($4, $5, $6)`
+
+	_, err := tr.Db.Exec(query, models.StatusCancelled, nullTime(finishedAt), expressionId,
+		models.StatusPending, models.StatusComputing, models.StatusBlocked)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RebuildEdges строит граф для задач, сохранённых до появления task_edges: у таких задач
+// есть ссылки в аргументах, но число зависимостей равно 0.
+func (tr *TaskRepo) RebuildEdges() error {
+	query := `SELECT ` + taskColumns + ` FROM tasks
+			  WHERE status IN ($1, $2) AND dependencies = 0 AND args LIKE '%"task%'`
+
+	rows, err := tr.Db.Query(query, models.StatusPending, models.StatusBlocked)
+	if err != nil {
+		return err
+	}
+
+	var tasks []models.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tasks = append(tasks, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		for _, arg := range task.Args {
+			var id int64
+			if _, err := fmt.Sscanf(arg, "task%d", &id); err != nil {
+				continue
+			}
+			if err := tr.InsertEdge(id, task.Id); err != nil {
+				return err
+			}
+		}
+
+		if err := tr.UpdateTask(task); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -7,11 +7,12 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
 	"github.com/MoodyShoo/go-http-calculator/internal/models"
 )
 
 type UserRepo struct {
-	Db *sql.DB
+	Db repository.Querier
 }
 
 func generateSalt() ([]byte, error) {
@@ -0,0 +1,46 @@
+package variablerepo
+
+import (
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+type VariableRepo struct {
+	Db repository.Querier
+}
+
+// SetVariable сохраняет переменную пользователя. Если переменная уже есть, её значение заменяется.
+func (vr *VariableRepo) SetVariable(userId int64, variable models.Variable) error {
+	query := `INSERT INTO variables (user_id, name, value) VALUES ($1, $2, $3)
+			  ON CONFLICT (user_id, name) DO UPDATE SET value = excluded.value`
+
+	_, err := vr.Db.Exec(query, userId, variable.Name, variable.Value)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetVariablesByUser возвращает переменные пользователя, отсортированные по имени.
+func (vr *VariableRepo) GetVariablesByUser(userId int64) ([]models.Variable, error) {
+	var variables []models.Variable
+	query := `SELECT name, value FROM variables WHERE user_id = $1 ORDER BY name`
+
+	rows, err := vr.Db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := models.Variable{}
+		if err := rows.Scan(&v.Name, &v.Value); err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, v)
+	}
+
+	return variables, rows.Err()
+}
@@ -0,0 +1,136 @@
+package webhookrepo
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database/repository"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+const deliveryColumns = `id, user_id, expression_id, url, payload, status, attempts, next_attempt_at,
+	last_error, response_code, created_at, delivered_at`
+
+type WebhookRepo struct {
+	Db repository.Querier
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(s scanner) (models.WebhookDelivery, error) {
+	d := models.WebhookDelivery{}
+	var nextAttempt, created int64
+	var delivered sql.NullInt64
+
+	err := s.Scan(&d.Id, &d.UserID, &d.ExpressionId, &d.URL, &d.Payload, &d.Status, &d.Attempts, &nextAttempt,
+		&d.LastError, &d.ResponseCode, &created, &delivered)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	d.NextAttemptAt = time.UnixMilli(nextAttempt)
+	d.CreatedAt = time.UnixMilli(created)
+	if delivered.Valid {
+		deliveredAt := time.UnixMilli(delivered.Int64)
+		d.DeliveredAt = &deliveredAt
+	}
+
+	return d, nil
+}
+
+// GetSecret возвращает секрет пользователя для подписи webhook, создавая его при первом обращении.
+func (wr *WebhookRepo) GetSecret(userId int64) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	query := `INSERT OR IGNORE INTO webhook_secrets (user_id, secret) VALUES ($1, $2)`
+	if _, err := wr.Db.Exec(query, userId, hex.EncodeToString(secret)); err != nil {
+		return "", err
+	}
+
+	var saved string
+	err := wr.Db.QueryRow(`SELECT secret FROM webhook_secrets WHERE user_id = $1`, userId).Scan(&saved)
+	if err != nil {
+		return "", err
+	}
+
+	return saved, nil
+}
+
+// InsertDelivery ставит доставку в очередь.
+func (wr *WebhookRepo) InsertDelivery(d models.WebhookDelivery) (int64, error) {
+	query := `INSERT INTO webhook_deliveries (user_id, expression_id, url, payload, status, attempts, next_attempt_at,
+				last_error, response_code, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	result, err := wr.Db.Exec(query, d.UserID, d.ExpressionId, d.URL, d.Payload, d.Status, d.Attempts,
+		d.NextAttemptAt.UnixMilli(), d.LastError, d.ResponseCode, d.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// UpdateDelivery сохраняет результат попытки доставки.
+func (wr *WebhookRepo) UpdateDelivery(d models.WebhookDelivery) error {
+	var delivered sql.NullInt64
+	if d.DeliveredAt != nil {
+		delivered = sql.NullInt64{Int64: d.DeliveredAt.UnixMilli(), Valid: true}
+	}
+
+	query := `UPDATE webhook_deliveries
+			  SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, response_code = $5, delivered_at = $6
+			  WHERE id = $7`
+
+	_, err := wr.Db.Exec(query, d.Status, d.Attempts, d.NextAttemptAt.UnixMilli(), d.LastError, d.ResponseCode,
+		delivered, d.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDueDeliveries возвращает не больше limit доставок, время попытки которых наступило к моменту now.
+func (wr *WebhookRepo) GetDueDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+			  WHERE status = $1 AND next_attempt_at <= $2
+			  ORDER BY next_attempt_at, id LIMIT $3`
+
+	return wr.queryDeliveries(query, models.DeliveryPending, now.UnixMilli(), limit)
+}
+
+// GetDeliveriesByUser возвращает доставки пользователя, от новых к старым.
+func (wr *WebhookRepo) GetDeliveriesByUser(userId int64) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE user_id = $1 ORDER BY id DESC`
+
+	return wr.queryDeliveries(query, userId)
+}
+
+func (wr *WebhookRepo) queryDeliveries(query string, args ...any) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	rows, err := wr.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
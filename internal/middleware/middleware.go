@@ -37,3 +37,36 @@ func AuthMiddleware(store *auth.TokenStore, next http.HandlerFunc) http.HandlerF
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// AdminMiddleware работает как AuthMiddleware, но пропускает только пользователей из admins.
+// Нужен для служебных эндпоинтов, которые показывают состояние всего сервера, а не одного пользователя.
+func AdminMiddleware(store *auth.TokenStore, admins map[int64]bool, next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(store, func(w http.ResponseWriter, r *http.Request) {
+		if userId, ok := GetUserID(r); !ok || !admins[userId] {
+			util.SendError(w, "admin access required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BearerProtocol - подпротокол WebSocket, вместе с которым клиент передаёт токен:
+// Sec-WebSocket-Protocol: bearer, <TOKEN>.
+const BearerProtocol = "bearer"
+
+// AuthProtocolMiddleware работает как AuthMiddleware, но принимает токен и из заголовка Sec-WebSocket-Protocol.
+// Нужен для WebSocket: браузер не может передать заголовок Authorization при подключении,
+// а токен в параметре адреса попадал бы в журналы прокси и историю браузера.
+func AuthProtocolMiddleware(store *auth.TokenStore, next http.HandlerFunc) http.HandlerFunc {
+	auth := AuthMiddleware(store, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+		if len(protocols) == 2 && strings.TrimSpace(protocols[0]) == BearerProtocol && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(protocols[1]))
+		}
+
+		auth.ServeHTTP(w, r)
+	}
+}
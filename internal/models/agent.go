@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Agent struct {
+	Id             string    `json:"id"`
+	Hostname       string    `json:"hostname"`
+	ComputingPower int       `json:"computing_power"`
+	Version        string    `json:"version"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastHeartbeat  time.Time `json:"last_heartbeat"`
+	Online         bool      `json:"online"`
+	InFlight       []int64   `json:"in_flight"`
+	Completed      int64     `json:"completed"`
+}
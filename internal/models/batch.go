@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BatchRequest - запрос на вычисление нескольких выражений сразу.
+// Каждое выражение проверяется отдельно, ошибка в одном не мешает принять остальные.
+type BatchRequest struct {
+	Expressions []Request `json:"expressions"`
+}
+
+// BatchItem - результат приёма одного выражения пакета: Id принятого выражения или ошибка.
+type BatchItem struct {
+	Id         int64  `json:"id,omitempty"`
+	TasksSaved int    `json:"tasks_saved,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BatchResponse - ответ на запрос пакета. Items идут в том же порядке, что и выражения запроса.
+// Id равен 0, если не принято ни одно выражение.
+type BatchResponse struct {
+	Id    int64       `json:"id,omitempty"`
+	Items []BatchItem `json:"items"`
+}
+
+func (r *BatchResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Batch - пакет выражений, принятых одним запросом.
+// Status - общий статус пакета: pending, пока ни одно выражение не начало вычисляться, computing, пока
+// не завершились все, затем done, если все выражения вычислены, или error, если хотя бы одно
+// завершилось ошибкой или было отменено. Счётчики показывают, сколько выражений в каждом статусе.
+type Batch struct {
+	Id          int64        `json:"id"`
+	UserID      int64        `json:"-"`
+	Status      Status       `json:"status"`
+	Total       int          `json:"total"`
+	Pending     int          `json:"pending"`
+	Computing   int          `json:"computing"`
+	Done        int          `json:"done"`
+	Error       int          `json:"error"`
+	Cancelled   int          `json:"cancelled"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Expressions []Expression `json:"expressions"`
+}
+
+func (b *Batch) ToJSON() ([]byte, error) {
+	return json.Marshal(b)
+}
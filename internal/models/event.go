@@ -0,0 +1,22 @@
+package models
+
+import "encoding/json"
+
+const (
+	EventExpression = "expression"
+	EventTask       = "task"
+)
+
+// Event - событие для подписчиков потока SSE: изменение статуса выражения (Type = EventExpression,
+// заполнено Expression) или завершение задачи выражения (Type = EventTask, заполнено Task).
+type Event struct {
+	Type         string      `json:"type"`
+	ExpressionId int64       `json:"expression_id"`
+	Expression   *Expression `json:"expression,omitempty"`
+	Task         *TaskNode   `json:"task,omitempty"`
+	UserID       int64       `json:"-"`
+}
+
+func (e *Event) ToJSON() ([]byte, error) {
+	return json.Marshal(e)
+}
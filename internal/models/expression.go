@@ -1,6 +1,10 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
 
 type Status string
 
@@ -9,17 +13,80 @@ const (
 	StatusComputing Status = "computing"
 	StatusDone      Status = "done"
 	StatusError     Status = "error"
+	StatusCancelled Status = "cancelled"
+	// StatusBlocked - задача ветки if ждёт, пока вычислится условие
+	StatusBlocked Status = "blocked"
+	// StatusSkipped - задача ветки if, которую условие не выбрало
+	StatusSkipped Status = "skipped"
 )
 
+// Expression - выражение пользователя.
+// Для режимов, отличных от float64, Value хранит точный результат строкой, а Result - его приближение.
+// В режиме complex result в JSON - объект с действительной и мнимой частью (см. MarshalJSON).
+// Variables - значения переменных пользователя, подставленные в выражение при его создании,
+// Functions - использованные в нём пользовательские функции.
+// TasksSaved - сколько задач не пришлось отправлять агентам благодаря упрощению выражения и кэшу результатов.
+// CriticalPathMs - оценка времени вычисления при неограниченном числе агентов, сделанная при создании задач.
+// CallbackURL - адрес, на который отправляется выражение после завершения (см. WebhookDelivery).
+// BatchId - пакет, в составе которого выражение было принято (см. Batch), или 0.
+// Progress заполняется только при запросе одного выражения, а Tasks - только по запросу ?include=tasks.
 type Expression struct {
-	Id     int64   `json:"id"`
-	Expr   string  `json:"expression"`
-	Status Status  `json:"status"`
-	Result float64 `json:"result"`
-	Error  string  `json:"error,omitempty"`
-	UserID int64   `json:"-"`
+	Id         int64              `json:"id"`
+	Expr       string             `json:"expression"`
+	Status     Status             `json:"status"`
+	Mode       string             `json:"mode,omitempty"`
+	Scale      int                `json:"scale,omitempty"`
+	Result     float64            `json:"result"`
+	Value      string             `json:"value,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	UserID     int64              `json:"-"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Functions  []Function         `json:"functions,omitempty"`
+	TasksSaved int                `json:"tasks_saved,omitempty"`
+	// Время в миллисекундах
+	CriticalPathMs int64      `json:"critical_path_ms,omitempty"`
+	CallbackURL    string     `json:"callback_url,omitempty"`
+	BatchId        int64      `json:"batch_id,omitempty"`
+	Progress       *Progress  `json:"progress,omitempty"`
+	Tasks          []TaskNode `json:"tasks,omitempty"`
+}
+
+// Progress - ход вычисления выражения: сколько его задач в каждом статусе
+// и сколько миллисекунд осталось по самому долгому пути в графе задач.
+type Progress struct {
+	Total       int   `json:"total"`
+	Pending     int   `json:"pending"`
+	Blocked     int   `json:"blocked"`
+	Computing   int   `json:"computing"`
+	Done        int   `json:"done"`
+	Skipped     int   `json:"skipped"`
+	RemainingMs int64 `json:"remaining_ms"`
 }
 
 func (e *Expression) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
 }
+
+// ComplexResult - результат выражения в режиме complex.
+type ComplexResult struct {
+	Real float64 `json:"real"`
+	Imag float64 `json:"imag"`
+}
+
+// MarshalJSON записывает result в режиме complex как {"real": ..., "imag": ...}, в остальных режимах - числом.
+func (e Expression) MarshalJSON() ([]byte, error) {
+	type expression Expression
+
+	if calculation.Mode(e.Mode) != calculation.ModeComplex {
+		return json.Marshal(expression(e))
+	}
+
+	d := calculation.Domain{Mode: calculation.ModeComplex}
+	return json.Marshal(struct {
+		expression
+		Result ComplexResult `json:"result"`
+	}{
+		expression: expression(e),
+		Result:     ComplexResult{Real: d.Float(e.Value), Imag: d.Imag(e.Value)},
+	})
+}
@@ -0,0 +1,14 @@
+package models
+
+import "encoding/json"
+
+// Function - пользовательская функция: имя, параметры и тело-выражение.
+type Function struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	Body   string   `json:"body"`
+}
+
+func (f *Function) ToJSON() ([]byte, error) {
+	return json.Marshal(f)
+}
@@ -1,7 +1,25 @@
 package models
 
+// Request - запрос на вычисление выражения. Mode и Scale задают режим вычислений (см. calculation.NewDomain).
+// Если задан CallbackURL, результат выражения будет отправлен на этот адрес.
 type Request struct {
-	Expression string `json:"expression"`
+	Expression  string `json:"expression"`
+	Mode        string `json:"mode,omitempty"`
+	Scale       *int   `json:"scale,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// VariableRequest - запрос на сохранение переменной. Value - указатель, чтобы отличить 0 от отсутствующего поля.
+type VariableRequest struct {
+	Name  string   `json:"name"`
+	Value *float64 `json:"value"`
+}
+
+// FunctionRequest - запрос на сохранение пользовательской функции. Params может быть пустым.
+type FunctionRequest struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	Body   string   `json:"body"`
 }
 
 type UserRequest struct {
@@ -9,8 +9,10 @@ type Response interface {
 
 // ----- Accepted Response -----
 
+// AcceptedResponse - ответ на принятое выражение. TasksSaved - сколько задач сэкономили упрощение выражения и кэш результатов.
 type AcceptedResponse struct {
-	Id int64 `json:"id"`
+	Id         int64 `json:"id"`
+	TasksSaved int   `json:"tasks_saved,omitempty"`
 }
 
 func (r *AcceptedResponse) ToJSON() ([]byte, error) {
@@ -46,3 +48,46 @@ type AuthResponse struct {
 func (r *AuthResponse) ToJSON() ([]byte, error) {
 	return json.Marshal(r)
 }
+
+// ----- Variables Response -----
+type VariablesResponse struct {
+	Variables []Variable `json:"variables"`
+}
+
+func (r *VariablesResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ----- Functions Response -----
+type FunctionsResponse struct {
+	Functions []Function `json:"functions"`
+}
+
+func (r *FunctionsResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ----- Cache Response -----
+
+// CacheResponse - состояние кэша результатов операций.
+type CacheResponse struct {
+	Size       int   `json:"size"`
+	Capacity   int   `json:"capacity"`
+	Persistent bool  `json:"persistent"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+}
+
+func (r *CacheResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// ----- Agents Response -----
+
+type AgentsResponse struct {
+	Agents []Agent `json:"agents"`
+}
+
+func (r *AgentsResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,7 @@
+package models
+
+// CachedResult - сохранённый результат операции. Key описывает операцию, режим и аргументы.
+type CachedResult struct {
+	Key   string
+	Value string
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+const (
+	BranchThen = "then"
+	BranchElse = "else"
+)
+
+// Task - задача для агента. Задачи веток if(cond, then, else) создаются в статусе StatusBlocked:
+// ConditionId - задача, вычисляющая условие, Branch - ветка, в которой находится задача.
+// Dependencies - сколько задач, на которые ссылаются аргументы, ещё не выполнено. Задача готова, когда их 0.
+// Args меняются по мере вычисления (ссылки заменяются результатами), а Operands - аргументы
+// в момент создания задачи со ссылками task{id}: по ним строится дерево задач выражения.
+type Task struct {
+	Id            int64     `json:"id"`
+	ExpressionId  int64     `json:"expression_id"`
+	Args          []string  `json:"args"`
+	Operands      []string  `json:"operands"`
+	Operation     string    `json:"operation"`
+	OperationTime int64     `json:"operation_time"`
+	Status        Status    `json:"status"`
+	Mode          string    `json:"mode,omitempty"`
+	Scale         int       `json:"scale,omitempty"`
+	Result        float64   `json:"result"`
+	Value         string    `json:"value,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	LeasedAt      time.Time `json:"leased_at,omitempty"`
+	FinishedAt    time.Time `json:"finished_at,omitempty"`
+	Lease         int64     `json:"lease"`
+	LeaseExpires  time.Time `json:"lease_expires,omitempty"`
+	AgentId       string    `json:"agent_id,omitempty"`
+	ConditionId   int64     `json:"condition_id,omitempty"`
+	Branch        string    `json:"branch,omitempty"`
+	Dependencies  int       `json:"dependencies"`
+}
+
+// TaskNode - задача в дереве вычисления выражения. StartedAt - время, когда задачу последний раз
+// получил агент, FinishedAt - время, когда пришёл её результат или ошибка.
+type TaskNode struct {
+	Id         int64      `json:"id"`
+	Operation  string     `json:"operation"`
+	Operands   []Operand  `json:"operands"`
+	Status     Status     `json:"status"`
+	Value      string     `json:"value,omitempty"`
+	AgentId    string     `json:"agent_id,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Operand - аргумент задачи в дереве: число или задача, результат которой в него подставляется.
+type Operand struct {
+	Value string    `json:"value,omitempty"`
+	Task  *TaskNode `json:"task,omitempty"`
+}
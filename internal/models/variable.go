@@ -0,0 +1,12 @@
+package models
+
+import "encoding/json"
+
+type Variable struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+func (v *Variable) ToJSON() ([]byte, error) {
+	return json.Marshal(v)
+}
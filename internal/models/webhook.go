@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeliveryStatus - статус доставки webhook.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery - отправка завершённого выражения на его callback_url.
+// Payload - JSON выражения на момент завершения, он же отправляется при повторных попытках.
+// NextAttemptAt - время следующей попытки для доставок в статусе DeliveryPending.
+type WebhookDelivery struct {
+	Id            int64          `json:"id"`
+	UserID        int64          `json:"-"`
+	ExpressionId  int64          `json:"expression_id"`
+	URL           string         `json:"url"`
+	Payload       string         `json:"-"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	LastError     string         `json:"last_error,omitempty"`
+	ResponseCode  int            `json:"response_code,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// WebhookDeliveriesResponse - доставки webhook пользователя.
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+func (r *WebhookDeliveriesResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// WebhookSecretResponse - секрет, которым подписываются webhook пользователя.
+type WebhookSecretResponse struct {
+	Secret string `json:"secret"`
+}
+
+func (r *WebhookSecretResponse) ToJSON() ([]byte, error) {
+	return json.Marshal(r)
+}
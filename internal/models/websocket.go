@@ -0,0 +1,30 @@
+package models
+
+// Типы сообщений WebSocket
+const (
+	WSCalculate = "calculate"
+	WSAccepted  = "accepted"
+	WSResult    = "result"
+	WSError     = "error"
+)
+
+// WSRequest - сообщение клиента в WebSocket. Поля Request заполняются для Type = WSCalculate.
+// RequestId клиент выбирает сам: он возвращается в ответе, чтобы сопоставить ответ с запросом.
+type WSRequest struct {
+	Type      string `json:"type"`
+	RequestId string `json:"request_id,omitempty"`
+	Request
+}
+
+// WSResponse - сообщение сервера в WebSocket:
+//   - WSAccepted - выражение принято, Id и TasksSaved как в AcceptedResponse;
+//   - WSResult - выражение с идентификатором Id завершилось, Expression - выражение целиком;
+//   - WSError - запрос не выполнен, причина в Error.
+type WSResponse struct {
+	Type       string      `json:"type"`
+	RequestId  string      `json:"request_id,omitempty"`
+	Id         int64       `json:"id,omitempty"`
+	TasksSaved int         `json:"tasks_saved,omitempty"`
+	Expression *Expression `json:"expression,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
@@ -0,0 +1,257 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database"
+	"github.com/MoodyShoo/go-http-calculator/internal/middleware"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/internal/util"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+// maxBatchSize ограничивает число выражений в одном пакете.
+const maxBatchSize = 10000
+
+// validateRequest проверяет поля запроса на вычисление, не разбирая само выражение,
+// и возвращает режим вычислений. Используется в /api/v1/calculate и WebSocket;
+// пакет проверяет те же поля через validateFields и validateCallbackURLs.
+func (o *Orchestrator) validateRequest(req models.Request) (calculation.Domain, error) {
+	domain, err := validateFields(req)
+	if err != nil {
+		return calculation.Domain{}, err
+	}
+
+	if req.CallbackURL != "" {
+		if err := o.validateCallbackURL(context.Background(), req.CallbackURL); err != nil {
+			return calculation.Domain{}, err
+		}
+	}
+
+	return domain, nil
+}
+
+// validateFields проверяет поля запроса, кроме callback_url, и возвращает режим вычислений.
+// Для пустого выражения ошибка та же, что и для неверного JSON.
+func validateFields(req models.Request) (calculation.Domain, error) {
+	if req.Expression == "" {
+		return calculation.Domain{}, fmt.Errorf("unprocessable entity")
+	}
+
+	return calculation.NewDomain(req.Mode, req.Scale)
+}
+
+// BatchCalculateHandler принимает пакет выражений. Каждое выражение проверяется отдельно,
+// а все принятые сохраняются в одной транзакции вместе с пакетом.
+// В ответе для каждого выражения - его id или ошибка, в том же порядке, что и в запросе.
+func (o *Orchestrator) BatchCalculateHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("BatchCalculateHandler: started")
+	defer log.Printf("BatchCalculateHandler: finished")
+
+	if r.Method != http.MethodPost {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Expressions) == 0 {
+		util.SendError(w, "unprocessable entity", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if len(req.Expressions) > maxBatchSize {
+		util.SendError(w, fmt.Sprintf("too many expressions: at most %d are allowed", maxBatchSize), http.StatusUnprocessableEntity)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	// Проверка и разбор выражений идут без o.mu: на большом пакете они заняли бы
+	// блокировку надолго. o.mu берётся только для чтения определений и для записи пакета
+	items := make([]models.BatchItem, len(req.Expressions))
+	domains := make([]calculation.Domain, len(req.Expressions))
+	var urls []string
+	seen := make(map[string]bool)
+	for i, item := range req.Expressions {
+		domain, err := validateFields(item)
+		if err != nil {
+			items[i].Error = err.Error()
+			continue
+		}
+		domains[i] = domain
+
+		if item.CallbackURL != "" && !seen[item.CallbackURL] {
+			seen[item.CallbackURL] = true
+			urls = append(urls, item.CallbackURL)
+		}
+	}
+
+	// Каждый адрес проверяется один раз, а все вместе - не дольше batchResolveTimeout
+	ctx, cancel := context.WithTimeout(r.Context(), batchResolveTimeout)
+	urlErrs := o.validateCallbackURLs(ctx, urls)
+	cancel()
+	for i, item := range req.Expressions {
+		if err := urlErrs[item.CallbackURL]; err != nil && items[i].Error == "" {
+			items[i].Error = err.Error()
+		}
+	}
+
+	// Переменные и функции загружаются один раз на весь пакет
+	o.mu.Lock()
+	vars, functions, err := o.userDefinitions(userId)
+	o.mu.Unlock()
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type prepared struct {
+		index int
+		exp   models.Expression
+		tasks []models.Task
+	}
+
+	var accepted []prepared
+	for i, item := range req.Expressions {
+		if items[i].Error != "" {
+			continue
+		}
+
+		exp, tasks, err := o.prepareExpression(item, domains[i], userId, vars, functions)
+		if err != nil {
+			items[i].Error = err.Error()
+			continue
+		}
+
+		accepted = append(accepted, prepared{index: i, exp: exp, tasks: tasks})
+	}
+
+	if len(accepted) == 0 {
+		util.SendResponse(w, &models.BatchResponse{Items: items}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batch := models.Batch{UserID: userId, CreatedAt: time.Now()}
+	err = o.db.Transaction(func(tx *database.Database) error {
+		id, err := tx.BatchRepo.InsertBatch(batch)
+		if err != nil {
+			return fmt.Errorf("failed to insert batch: %v", err)
+		}
+		batch.Id = id
+
+		for i := range accepted {
+			accepted[i].exp.BatchId = id
+			exp, err := o.storeExpression(tx, accepted[i].exp, accepted[i].tasks)
+			if err != nil {
+				return err
+			}
+			accepted[i].exp = exp
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("BatchCalculateHandler: %v", err)
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// События и webhook - только после фиксации транзакции
+	for _, p := range accepted {
+		items[p.index] = models.BatchItem{Id: p.exp.Id, TasksSaved: p.exp.TasksSaved}
+		o.acceptExpression(p.exp)
+	}
+
+	log.Printf("BatchCalculateHandler: batch %d accepted %d of %d expressions", batch.Id, len(accepted), len(items))
+	util.SendResponse(w, &models.BatchResponse{Id: batch.Id, Items: items}, http.StatusAccepted)
+}
+
+// BatchIdHandler возвращает пакет по его ID: общий статус, счётчики и выражения пакета.
+func (o *Orchestrator) BatchIdHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("BatchIdHandler: started")
+	defer log.Printf("BatchIdHandler: finished")
+
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, BatchIdRoute), 10, 64)
+	if err != nil {
+		util.SendError(w, "invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batch, err := o.db.BatchRepo.GetBatchByIDByUser(id, userId)
+	if err != nil {
+		util.SendError(w, "batch not found", http.StatusNotFound)
+		return
+	}
+
+	expressions, err := o.db.ExpressionRepo.GetExpressionsByBatch(id)
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summarizeBatch(&batch, expressions)
+	util.SendResponse(w, &batch, http.StatusOK)
+}
+
+// summarizeBatch заполняет выражения, счётчики и общий статус пакета (см. models.Batch).
+func summarizeBatch(batch *models.Batch, expressions []models.Expression) {
+	if expressions == nil {
+		expressions = make([]models.Expression, 0)
+	}
+
+	batch.Expressions = expressions
+	batch.Total = len(expressions)
+	for _, exp := range expressions {
+		switch exp.Status {
+		case models.StatusPending:
+			batch.Pending++
+		case models.StatusComputing:
+			batch.Computing++
+		case models.StatusDone:
+			batch.Done++
+		case models.StatusError:
+			batch.Error++
+		case models.StatusCancelled:
+			batch.Cancelled++
+		}
+	}
+
+	switch {
+	case batch.Pending == batch.Total:
+		batch.Status = models.StatusPending
+	case batch.Pending+batch.Computing > 0:
+		batch.Status = models.StatusComputing
+	case batch.Done == batch.Total:
+		batch.Status = models.StatusDone
+	default:
+		batch.Status = models.StatusError
+	}
+}
@@ -0,0 +1,224 @@
+package orchestrator
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	resultrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/result_repo"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+// resultCache хранит результаты операций, которые уже посчитали агенты, чтобы не отправлять
+// такие же задачи повторно. Результат одного агента нельзя считать верным, поэтому общим для всех
+// пользователей он становится, только если его проверил оркестратор или подтвердил другой агент
+// (см. Orchestrator.cacheResult); до этого результат хранится под ключом пользователя (см. userResultKey).
+// Ключ - операция, режим и аргументы (см. resultKey). Когда записей больше capacity,
+// удаляется та, к которой дольше всего не обращались.
+// Если repo не nil, проверенные результаты сохраняются в таблицу results вместе со временем
+// последнего обращения и загружаются при запуске в том же порядке.
+// get не обращается к базе: выражения разбираются и без o.mu, поэтому время обращения
+// запоминается и записывается в базу в flush, который вызывается под o.mu.
+type resultCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	repo     *resultrepo.ResultRepo
+	hits     int64
+	misses   int64
+	// Результаты, которые ждут подтверждения другим агентом. В поиске они не участвуют
+	candidates     map[string]*list.Element
+	candidateOrder *list.List
+	// Время последнего обращения к сохранённым в базу записям, ещё не записанное в неё
+	touched map[string]time.Time
+}
+
+// newResultCache создает кэш на capacity записей. При capacity = 0 кэш ничего не хранит.
+func newResultCache(capacity int, repo *resultrepo.ResultRepo) *resultCache {
+	c := &resultCache{
+		capacity:       capacity,
+		items:          make(map[string]*list.Element),
+		order:          list.New(),
+		repo:           repo,
+		candidates:     make(map[string]*list.Element),
+		candidateOrder: list.New(),
+		touched:        make(map[string]time.Time),
+	}
+
+	if repo == nil || capacity == 0 {
+		return c
+	}
+
+	results, err := repo.GetRecentResults(capacity)
+	if err != nil {
+		log.Printf("Failed to load cached results: %v", err)
+		return c
+	}
+
+	// Результаты приходят от недавно использованных к давним, последним в кэш должен попасть самый свежий
+	for i := len(results) - 1; i >= 0; i-- {
+		c.items[results[i].Key] = c.order.PushFront(&cacheEntry{CachedResult: results[i], persistent: true})
+	}
+
+	return c
+}
+
+// cacheEntry - запись кэша. persistent - запись сохранена в базу.
+type cacheEntry struct {
+	models.CachedResult
+	persistent bool
+}
+
+// resultKey возвращает ключ результата операции. Режим входит в ключ: 1/3 в режимах float и rational - разные числа.
+func resultKey(operation string, args []string, d calculation.Domain) string {
+	return fmt.Sprintf("%s:%d:%s", d.Mode, d.Scale, taskKey(operation, args))
+}
+
+// userResultKey возвращает ключ непроверенного результата, который виден только пользователю userId.
+func userResultKey(userId int64, operation string, args []string, d calculation.Domain) string {
+	return fmt.Sprintf("user%d:%s", userId, resultKey(operation, args, d))
+}
+
+// get возвращает сохранённый результат по первому найденному из ключей keys.
+// Промах или попадание считается один раз на вызов.
+func (c *resultCache) get(keys ...string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		element, ok := c.items[key]
+		if !ok {
+			continue
+		}
+
+		c.hits++
+		c.order.MoveToFront(element)
+
+		entry := element.Value.(*cacheEntry)
+		if entry.persistent {
+			c.touched[key] = time.Now()
+		}
+		return entry.Value, true
+	}
+
+	c.misses++
+	return "", false
+}
+
+// flush записывает в базу время последнего обращения к записям, найденным через get.
+// Вызывается под o.mu.
+func (c *resultCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, at := range c.touched {
+		if err := c.repo.TouchResult(key, at); err != nil {
+			log.Printf("Failed to update cached result %s: %v", key, err)
+		}
+	}
+	clear(c.touched)
+}
+
+// put сохраняет результат операции, вытесняя самую давнюю запись, если кэш заполнен.
+// verified - результат проверен оркестратором: только такие результаты сохраняются в базу.
+func (c *resultCache) put(key, value string, verified bool) {
+	if c.capacity == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{CachedResult: models.CachedResult{Key: key, Value: value}, persistent: verified && c.repo != nil}
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+	} else {
+		c.items[key] = c.order.PushFront(entry)
+	}
+
+	if entry.persistent {
+		delete(c.touched, key)
+		if err := c.repo.SetResult(entry.CachedResult, time.Now()); err != nil {
+			log.Printf("Failed to save cached result %s: %v", key, err)
+		}
+	}
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+
+		entry := oldest.Value.(*cacheEntry)
+		delete(c.items, entry.Key)
+		delete(c.touched, entry.Key)
+
+		if entry.persistent {
+			if err := c.repo.DeleteResult(entry.Key); err != nil {
+				log.Printf("Failed to delete cached result %s: %v", entry.Key, err)
+			}
+		}
+	}
+}
+
+// candidate - результат операции key, который прислал агент agentId.
+type candidate struct {
+	key     string
+	value   string
+	agentId string
+}
+
+// confirm запоминает результат value операции key, который прислал агент agentId, и сообщает,
+// совпал ли он с результатом, который раньше прислал другой агент. Подтверждённый результат
+// больше не ждёт подтверждения. Если результаты разошлись, ждёт подтверждения последний.
+// Результат неизвестного агента (agentId пустой) подтвердить ничего не может.
+func (c *resultCache) confirm(key, value, agentId string) bool {
+	if c.capacity == 0 || agentId == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.candidates[key]; ok {
+		previous := element.Value.(*candidate)
+		if previous.agentId != agentId && previous.value == value {
+			c.candidateOrder.Remove(element)
+			delete(c.candidates, key)
+			return true
+		}
+		if previous.value != value {
+			log.Printf("agents %q and %q returned different results for %s: %q and %q", previous.agentId, agentId, key, previous.value, value)
+		}
+
+		element.Value = &candidate{key: key, value: value, agentId: agentId}
+		c.candidateOrder.MoveToFront(element)
+		return false
+	}
+
+	c.candidates[key] = c.candidateOrder.PushFront(&candidate{key: key, value: value, agentId: agentId})
+	for c.candidateOrder.Len() > c.capacity {
+		oldest := c.candidateOrder.Back()
+		c.candidateOrder.Remove(oldest)
+		delete(c.candidates, oldest.Value.(*candidate).key)
+	}
+
+	return false
+}
+
+// stats возвращает размер кэша и счётчики попаданий и промахов.
+func (c *resultCache) stats() models.CacheResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return models.CacheResponse{
+		Size:       c.order.Len(),
+		Capacity:   c.capacity,
+		Persistent: c.repo != nil,
+		Hits:       c.hits,
+		Misses:     c.misses,
+	}
+}
@@ -1,8 +1,12 @@
 package orchestrator
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
 )
 
 type Config struct {
@@ -13,17 +17,63 @@ type Config struct {
 	TimeSubtractionMs     int
 	TimeMultiplicationsMs int
 	TimeDivisionsMs       int
+	TimeNegationMs        int
+	TimePowerMs           int
+	TimeModuloMs          int
+	TimeFactorialMs       int
+	TimeComparisonMs      int
+	TimeLogicalMs         int
+	TaskLeaseGraceMs      int
+	LeaseCheckIntervalMs  int
+	AgentTimeoutMs        int
+	TimeFunctionsMs       map[string]int
+	// CheapOperations - операции, которые оркестратор вычисляет сам, если все их аргументы - числа
+	CheapOperations map[string]bool
+	// ResultCacheSize - сколько результатов операций хранит кэш, 0 - кэш выключен
+	ResultCacheSize int
+	// ResultCachePersist - сохранять ли кэш результатов в базу
+	ResultCachePersist bool
+	// WebhookMaxAttempts - сколько раз пытаться доставить webhook, прежде чем отметить доставку неудачной
+	WebhookMaxAttempts int
+	// WebhookRetryBaseMs - пауза перед второй попыткой доставки, каждая следующая пауза вдвое дольше
+	WebhookRetryBaseMs     int
+	WebhookCheckIntervalMs int
+	WebhookTimeoutMs       int
+	// WebhookAllowPrivate разрешает webhook на loopback и адреса внутренних сетей (для локальной разработки)
+	WebhookAllowPrivate bool
+	// WSAllowedOrigins - чужие источники (scheme://host[:port]), страницам которых можно подключаться к WebSocket
+	WSAllowedOrigins map[string]bool
+	// AdminUserIds - пользователи, которым доступны служебные эндпоинты /internal/...
+	AdminUserIds map[int64]bool
 }
 
 func configFromEnv() *Config {
 	config := &Config{
-		Address:               "8080",
-		AddressGRPC:           "localhost",
-		PortGRPC:              "5000",
-		TimeAdditionMs:        1000,
-		TimeSubtractionMs:     1000,
-		TimeMultiplicationsMs: 1000,
-		TimeDivisionsMs:       1000,
+		Address:                "8080",
+		AddressGRPC:            "localhost",
+		PortGRPC:               "5000",
+		TimeAdditionMs:         1000,
+		TimeSubtractionMs:      1000,
+		TimeMultiplicationsMs:  1000,
+		TimeDivisionsMs:        1000,
+		TimeNegationMs:         1000,
+		TimePowerMs:            1000,
+		TimeModuloMs:           1000,
+		TimeFactorialMs:        1000,
+		TimeComparisonMs:       1000,
+		TimeLogicalMs:          1000,
+		TaskLeaseGraceMs:       5000,
+		LeaseCheckIntervalMs:   1000,
+		AgentTimeoutMs:         15000,
+		TimeFunctionsMs:        make(map[string]int),
+		CheapOperations:        make(map[string]bool),
+		WSAllowedOrigins:       make(map[string]bool),
+		AdminUserIds:           make(map[int64]bool),
+		ResultCacheSize:        1000,
+		WebhookMaxAttempts:     8,
+		WebhookRetryBaseMs:     1000,
+		WebhookCheckIntervalMs: 1000,
+		WebhookTimeoutMs:       10000,
 	}
 
 	if addr := os.Getenv(PortEnv); addr != "" {
@@ -62,5 +112,138 @@ func configFromEnv() *Config {
 		}
 	}
 
+	if val := os.Getenv(TimeNegationMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimeNegationMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TimePowerMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimePowerMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TimeModuloMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimeModuloMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TimeFactorialMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimeFactorialMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TimeComparisonMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimeComparisonMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TimeLogicalMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TimeLogicalMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(TaskLeaseGraceMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.TaskLeaseGraceMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(LeaseCheckIntervalMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil && timeMs > 0 {
+			config.LeaseCheckIntervalMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(AgentTimeoutMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil {
+			config.AgentTimeoutMs = timeMs
+		}
+	}
+
+	// Операции перечисляются через запятую, например "+,-,neg,abs"
+	if val := os.Getenv(CheapOperationsEnv); val != "" {
+		for _, operation := range strings.Split(val, ",") {
+			if operation = strings.TrimSpace(operation); operation != "" {
+				config.CheapOperations[operation] = true
+			}
+		}
+	}
+
+	if val := os.Getenv(ResultCacheSizeEnv); val != "" {
+		if size, err := strconv.Atoi(val); err == nil && size >= 0 {
+			config.ResultCacheSize = size
+		}
+	}
+
+	if val := os.Getenv(ResultCachePersistEnv); val != "" {
+		if persist, err := strconv.ParseBool(val); err == nil {
+			config.ResultCachePersist = persist
+		}
+	}
+
+	if val := os.Getenv(WebhookMaxAttemptsEnv); val != "" {
+		if attempts, err := strconv.Atoi(val); err == nil && attempts > 0 {
+			config.WebhookMaxAttempts = attempts
+		}
+	}
+
+	if val := os.Getenv(WebhookRetryBaseMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil && timeMs >= 0 {
+			config.WebhookRetryBaseMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(WebhookCheckIntervalMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil && timeMs > 0 {
+			config.WebhookCheckIntervalMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(WebhookTimeoutMsEnv); val != "" {
+		if timeMs, err := strconv.Atoi(val); err == nil && timeMs > 0 {
+			config.WebhookTimeoutMs = timeMs
+		}
+	}
+
+	if val := os.Getenv(WebhookAllowPrivateEnv); val != "" {
+		if allow, err := strconv.ParseBool(val); err == nil {
+			config.WebhookAllowPrivate = allow
+		}
+	}
+
+	// Источники перечисляются через запятую, например "https://app.example.com,http://localhost:3000"
+	if val := os.Getenv(WSAllowedOriginsEnv); val != "" {
+		for _, origin := range strings.Split(val, ",") {
+			if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+				config.WSAllowedOrigins[origin] = true
+			}
+		}
+	}
+
+	// Идентификаторы пользователей перечисляются через запятую, например "1,7"
+	if val := os.Getenv(AdminUserIdsEnv); val != "" {
+		for _, id := range strings.Split(val, ",") {
+			if userId, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil {
+				config.AdminUserIds[userId] = true
+			}
+		}
+	}
+
+	for _, name := range calculation.FunctionNames() {
+		config.TimeFunctionsMs[name] = 1000
+
+		if val := os.Getenv(fmt.Sprintf(TimeFunctionMsEnvFormat, strings.ToUpper(name))); val != "" {
+			if timeMs, err := strconv.Atoi(val); err == nil {
+				config.TimeFunctionsMs[name] = timeMs
+			}
+		}
+	}
+
 	return config
 }
@@ -1,18 +1,57 @@
 package orchestrator
 
 const (
-	RegisterRoute     = "/api/v1/register"
-	LoginRoute        = "/api/v1/login"
-	CalculateRoute    = "/api/v1/calculate"
-	ExpressionsRoute  = "/api/v1/expressions"
-	ExpressionIdRoute = "/api/v1/expressions/"
-	TaskRoute         = "/internal/task"
+	RegisterRoute       = "/api/v1/register"
+	LoginRoute          = "/api/v1/login"
+	CalculateRoute      = "/api/v1/calculate"
+	CalculateBatchRoute = "/api/v1/calculate/batch"
+	BatchIdRoute        = "/api/v1/batches/"
+	ExpressionsRoute    = "/api/v1/expressions"
+	ExpressionIdRoute   = "/api/v1/expressions/"
+	VariablesRoute      = "/api/v1/variables"
+	FunctionsRoute      = "/api/v1/functions"
+	EventsRoute         = "/api/v1/events"
+	EventsSuffix        = "/events"
+	WebSocketRoute      = "/api/v1/ws"
+	DeliveriesRoute     = "/api/v1/webhooks/deliveries"
+	WebhookSecretRoute  = "/api/v1/webhooks/secret"
+	TaskRoute           = "/internal/task"
+	AgentsRoute         = "/internal/agents"
+	CacheRoute          = "/internal/cache"
 
-	PortEnv                  = "PORT"
-	GRPCAddressEnv           = "GRPC_ADDRESS"
-	GRPCPortEnv              = "GRPC_PORT"
-	TimeAdditionMsEnv        = "TIME_ADDITION_MS"
-	TimeSubtractionMsEnv     = "TIME_SUBTRACTION_MS"
-	TimeMultiplicationsMsEnv = "TIME_MULTIPLICATIONS_MS"
-	TimeDivisionsMsEnv       = "TIME_DIVISIONS_MS"
+	PortEnv                   = "PORT"
+	GRPCAddressEnv            = "GRPC_ADDRESS"
+	GRPCPortEnv               = "GRPC_PORT"
+	TimeAdditionMsEnv         = "TIME_ADDITION_MS"
+	TimeSubtractionMsEnv      = "TIME_SUBTRACTION_MS"
+	TimeMultiplicationsMsEnv  = "TIME_MULTIPLICATIONS_MS"
+	TimeDivisionsMsEnv        = "TIME_DIVISIONS_MS"
+	TimeNegationMsEnv         = "TIME_NEGATION_MS"
+	TimePowerMsEnv            = "TIME_POWER_MS"
+	TimeModuloMsEnv           = "TIME_MODULO_MS"
+	TimeFactorialMsEnv        = "TIME_FACTORIAL_MS"
+	TimeComparisonMsEnv       = "TIME_COMPARISON_MS"
+	TimeLogicalMsEnv          = "TIME_LOGICAL_MS"
+	TaskLeaseGraceMsEnv       = "TASK_LEASE_GRACE_MS"
+	LeaseCheckIntervalMsEnv   = "LEASE_CHECK_INTERVAL_MS"
+	AgentTimeoutMsEnv         = "AGENT_TIMEOUT_MS"
+	CheapOperationsEnv        = "CHEAP_OPERATIONS"
+	ResultCacheSizeEnv        = "RESULT_CACHE_SIZE"
+	ResultCachePersistEnv     = "RESULT_CACHE_PERSIST"
+	WebhookMaxAttemptsEnv     = "WEBHOOK_MAX_ATTEMPTS"
+	WebhookRetryBaseMsEnv     = "WEBHOOK_RETRY_BASE_MS"
+	WebhookCheckIntervalMsEnv = "WEBHOOK_CHECK_INTERVAL_MS"
+	WebhookTimeoutMsEnv       = "WEBHOOK_TIMEOUT_MS"
+	WebhookAllowPrivateEnv    = "WEBHOOK_ALLOW_PRIVATE"
+	WSAllowedOriginsEnv       = "WS_ALLOWED_ORIGINS"
+	AdminUserIdsEnv           = "ADMIN_USER_IDS"
+
+	// SignatureHeader - заголовок с подписью webhook: sha256=<HMAC-SHA256 тела в hex>
+	SignatureHeader = "X-Signature-256"
+	// DeliveryHeader - заголовок с идентификатором доставки, одинаковый во всех повторных попытках
+	DeliveryHeader = "X-Webhook-Delivery"
+
+	// TimeFunctionMsEnvFormat - шаблон переменной со временем выполнения встроенной функции,
+	// например TIME_SQRT_MS для sqrt.
+	TimeFunctionMsEnvFormat = "TIME_%s_MS"
 )
@@ -0,0 +1,223 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/internal/util"
+)
+
+const (
+	// eventBuffer - сколько событий может ждать отправки одному подписчику.
+	eventBuffer = 64
+	// eventsKeepAlive - как часто в поток пишется комментарий, чтобы прокси не закрыли простаивающее соединение.
+	eventsKeepAlive = 15 * time.Second
+	// maxWait ограничивает ожидание результата в POST /api/v1/calculate?wait=.
+	maxWait = time.Minute
+)
+
+// eventHub рассылает события выражений подписчикам. Публикация не блокируется:
+// подписчик, который не успевает читать события, отключается, и его канал закрывается.
+// Клиент может переподключиться и узнать текущее состояние из первого события.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscription]struct{}
+}
+
+// subscription - подписка на события пользователя userId.
+// Если expressionId не 0, приходят только события этого выражения.
+// Если filter не nil, приходят только события, для которых он вернул true.
+// filter вызывается при публикации и не должен блокироваться.
+type subscription struct {
+	userId       int64
+	expressionId int64
+	filter       func(models.Event) bool
+	events       chan models.Event
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[*subscription]struct{})}
+}
+
+// subscribe создаёт подписку. После использования её нужно закрыть через unsubscribe.
+func (h *eventHub) subscribe(userId, expressionId int64) *subscription {
+	return h.add(&subscription{userId: userId, expressionId: expressionId})
+}
+
+// subscribeFunc создаёт подписку на события пользователя, которые пропускает filter.
+// Отброшенные события не занимают место в буфере подписчика.
+func (h *eventHub) subscribeFunc(userId int64, filter func(models.Event) bool) *subscription {
+	return h.add(&subscription{userId: userId, filter: filter})
+}
+
+func (h *eventHub) add(s *subscription) *subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s.events = make(chan models.Event, eventBuffer)
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// unsubscribe удаляет подписку и закрывает её канал, если это ещё не сделал publish.
+func (h *eventHub) unsubscribe(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// publish отправляет событие подписчикам его пользователя.
+func (h *eventHub) publish(event models.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		if s.userId != event.UserID || (s.expressionId != 0 && s.expressionId != event.ExpressionId) {
+			continue
+		}
+		if s.filter != nil && !s.filter(event) {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			log.Printf("dropped slow event subscriber of user %d", s.userId)
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+}
+
+// publishExpression сообщает подписчикам новый статус выражения.
+func (o *Orchestrator) publishExpression(exp models.Expression) {
+	o.events.publish(models.Event{
+		Type:         models.EventExpression,
+		ExpressionId: exp.Id,
+		Expression:   &exp,
+		UserID:       exp.UserID,
+	})
+}
+
+// publishTask сообщает подписчикам, что задача выражения завершилась.
+// Вызывается под o.mu.
+func (o *Orchestrator) publishTask(task models.Task) {
+	expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
+	if err != nil {
+		log.Printf("Failed to publish task %d: %v", task.Id, err)
+		return
+	}
+
+	o.events.publish(models.Event{
+		Type:         models.EventTask,
+		ExpressionId: task.ExpressionId,
+		Task:         newTaskNode(task, task.Args),
+		UserID:       expression.UserID,
+	})
+}
+
+// waitExpression ждёт завершения выражения exp не дольше timeout или до отмены ctx
+// и возвращает последнее известное состояние выражения.
+func (o *Orchestrator) waitExpression(ctx context.Context, exp models.Expression, timeout time.Duration) models.Expression {
+	sub := o.events.subscribe(exp.UserID, exp.Id)
+	defer o.events.unsubscribe(sub)
+
+	// Выражение могло завершиться до подписки
+	o.mu.Lock()
+	current, err := o.db.ExpressionRepo.GetExpressionByID(exp.Id)
+	o.mu.Unlock()
+	if err == nil {
+		exp = current
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for !isFinished(exp.Status) {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return exp
+			}
+			if event.Type == models.EventExpression {
+				exp = *event.Expression
+			}
+		case <-timer.C:
+			return exp
+		case <-ctx.Done():
+			return exp
+		}
+	}
+
+	return exp
+}
+
+// streamEvents отправляет события подписки клиенту в формате SSE, пока клиент не отключится.
+// Если first не nil, он отправляется первым. Поток одного выражения закрывается после события его завершения.
+func streamEvents(w http.ResponseWriter, r *http.Request, sub *subscription, first *models.Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		util.SendError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(util.ContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event models.Event) bool {
+		if err := writeEvent(w, event); err != nil {
+			return false
+		}
+		flusher.Flush()
+
+		finished := event.Type == models.EventExpression && isFinished(event.Expression.Status)
+		return !(sub.expressionId != 0 && finished)
+	}
+
+	if first != nil && !send(*first) {
+		return
+	}
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.events:
+			// Канал закрыт, если клиент не успевал читать события
+			if !ok || !send(event) {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent записывает событие в формате SSE: тип события и JSON в поле data.
+func writeEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := event.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
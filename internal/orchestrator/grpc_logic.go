@@ -2,55 +2,197 @@ package orchestrator
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/MoodyShoo/go-http-calculator/internal/models"
 	pb "github.com/MoodyShoo/go-http-calculator/internal/proto"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
 )
 
+// toProtoTask преобразует задачу из базы данных в сообщение gRPC.
+// Первые два аргумента дублируются в arg1 и arg2 для агентов, которые не знают про args.
+func toProtoTask(task models.Task) *pb.Task {
+	protoTask := &pb.Task{
+		Id:            task.Id,
+		ExpressionId:  task.ExpressionId,
+		Args:          task.Args,
+		Operation:     task.Operation,
+		OperationTime: task.OperationTime,
+		Status:        string(task.Status),
+		Result:        task.Result,
+		Error:         task.Error,
+		Lease:         task.Lease,
+		Mode:          task.Mode,
+		Scale:         int32(task.Scale),
+	}
+
+	if len(task.Args) > 0 {
+		protoTask.Arg1 = task.Args[0]
+	}
+	if len(task.Args) > 1 {
+		protoTask.Arg2 = task.Args[1]
+	}
+
+	return protoTask
+}
+
+// acquireTask выдаёт в аренду первую готовую задачу. Если готовых задач нет, возвращает nil.
+// Вызывается под o.mu.
+func (o *Orchestrator) acquireTask(agentId string) (*models.Task, error) {
+	task, err := o.db.TaskRepo.GetReadyTask()
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	task.Status = models.StatusComputing
+	task.LeasedAt = time.Now()
+	task.Lease++
+	task.LeaseExpires = task.LeasedAt.Add(o.leaseDuration(task))
+	task.AgentId = agentId
+	if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+		return nil, err
+	}
+
+	expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if expression.Status == models.StatusPending {
+		expression.Status = models.StatusComputing
+		o.db.ExpressionRepo.UpdateExpression(task.ExpressionId, expression)
+		o.publishExpression(expression)
+	}
+
+	log.Printf("sent task %d to agent %q", task.Id, agentId)
+
+	return &task, nil
+}
+
+// notifyTasksReady будит потоки Dispatch, ожидающие новых задач.
+// Вызывается под o.mu.
+func (o *Orchestrator) notifyTasksReady() {
+	close(o.ready)
+	o.ready = make(chan struct{})
+}
+
+// acquireTasks выдаёт до limit готовых задач и возвращает канал,
+// который закроется при появлении новых задач.
+func (o *Orchestrator) acquireTasks(limit int, agentId string) ([]*models.Task, <-chan struct{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var tasks []*models.Task
+	for len(tasks) < limit {
+		task, err := o.acquireTask(agentId)
+		if err != nil {
+			return tasks, o.ready, err
+		}
+		if task == nil {
+			break
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, o.ready, nil
+}
+
 func (o *Orchestrator) FetchTask(ctx context.Context, in *pb.TaskRequest) (*pb.TaskResponse, error) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
 	log.Println("Invoked FetchTask: ", in)
 
-	for i, task := range o.tasks {
-		if task.Status == string(models.StatusPending) && !isTaskReference(task.Arg1) && !isTaskReference(task.Arg2) {
-			task.Status = string(models.StatusComputing)
-			o.tasks[i] = task
+	task, err := o.acquireTask(in.AgentId)
+	if err != nil {
+		return nil, err
+	}
+
+	if task == nil {
+		log.Println("no tasks available")
+		return nil, fmt.Errorf("no tasks available")
+	}
+
+	return &pb.TaskResponse{Task: toProtoTask(*task)}, nil
+}
 
-			expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
+// Dispatch держит поток с агентом: агент сообщает о свободных воркерах,
+// а сервер отправляет задачи сразу, как только они становятся готовыми.
+func (o *Orchestrator) Dispatch(stream pb.OrchestratorService_DispatchServer) error {
+	ctx := stream.Context()
+	requests := make(chan *pb.DispatchRequest)
+	recvErr := make(chan error, 1)
+
+	go func() {
+		for {
+			req, err := stream.Recv()
 			if err != nil {
-				return nil, err
+				recvErr <- err
+				return
 			}
 
-			if expression.Status != models.StatusDone {
-				expression.Status = models.StatusComputing
-				o.db.ExpressionRepo.UpdateExpression(task.ExpressionId, expression)
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
 			}
+		}
+	}()
 
-			log.Println("sent task: ", task.Id)
+	free := 0
+	agentId := ""
+	var cancels chan int64
+	defer func() {
+		if cancels != nil {
+			o.agents.detach(agentId, cancels)
+		}
+	}()
 
-			return &pb.TaskResponse{
-				Task: &pb.Task{
-					Id:            task.Id,
-					ExpressionId:  task.ExpressionId,
-					Arg1:          task.Arg1,
-					Arg2:          task.Arg2,
-					Operation:     task.Operation,
-					OperationTime: task.OperationTime,
-					Status:        task.Status,
-					Result:        task.Result,
-					Error:         task.Error,
-				},
-			}, nil
+	for {
+		tasks, ready, err := o.acquireTasks(free, agentId)
+		for _, task := range tasks {
+			resp := &pb.DispatchResponse{Payload: &pb.DispatchResponse_Task{Task: toProtoTask(*task)}}
+			if err := stream.Send(resp); err != nil {
+				// Отправленные задачи вернутся в очередь, когда истечёт аренда
+				return err
+			}
+			free--
+		}
+		if err != nil {
+			return err
 		}
-	}
 
-	log.Println("no tasks available")
-	return nil, fmt.Errorf("no tasks available")
+		select {
+		case req := <-requests:
+			free += int(req.FreeSlots)
+			if req.AgentId != "" && cancels == nil {
+				agentId = req.AgentId
+				cancels = o.agents.attach(agentId)
+			}
+		case taskId := <-cancels:
+			resp := &pb.DispatchResponse{Payload: &pb.DispatchResponse_CancelTaskId{CancelTaskId: taskId}}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-ready:
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
 // SubmitTaskResult обрабатывает запрос на обновление результата задачи
@@ -58,83 +200,353 @@ func (o *Orchestrator) SendResult(ctx context.Context, in *pb.TaskResult) (*pb.S
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
-	var task *pb.Task
-	var taskIndex int
-	var found bool
-	for i, t := range o.tasks {
-		if t.Id == in.Id {
-			task = t
-			taskIndex = i
-			found = true
-			break
-		}
+	task, err := o.db.TaskRepo.GetTaskByID(in.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("task not found")
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if !found {
-		return nil, fmt.Errorf("task not found")
+	// Результаты отменённых выражений больше никому не нужны
+	if task.Status == models.StatusCancelled {
+		log.Printf("discarded result for cancelled task %d", task.Id)
+		return &pb.SuccessResponse{Message: "Task cancelled, result discarded."}, nil
+	}
+
+	// Результат принимается только от текущего владельца аренды, пока она не истекла
+	if task.Status != models.StatusComputing || task.Lease != in.Lease || time.Now().After(task.LeaseExpires) {
+		log.Printf("rejected result for task %d: lease %d expired", task.Id, in.Lease)
+		return nil, fmt.Errorf("task lease expired")
+	}
+
+	if task.AgentId != in.AgentId {
+		log.Printf("rejected result for task %d: task is leased to %q, not %q", task.Id, task.AgentId, in.AgentId)
+		return nil, fmt.Errorf("task is leased to another agent")
 	}
 
 	// Обновляет статус задачи
 	if in.Error != "" {
-		task.Status = string(models.StatusError)
+		task.Status = models.StatusError
 		task.Error = in.Error
 	} else {
-		task.Status = string(models.StatusDone)
+		value, err := resultValue(task, in)
+		if err != nil {
+			return nil, err
+		}
+
+		task.Status = models.StatusDone
 		task.Result = in.Result
+		task.Value = value
 	}
+	task.LeaseExpires = time.Time{}
+	task.FinishedAt = time.Now()
 
-	o.tasks[taskIndex] = task
+	if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+		return nil, err
+	}
+	o.publishTask(task)
 
-	// Обновляет аргументы в других задачах, если они ссылаются на эту задачу
-	for i, t := range o.tasks {
-		if t.ExpressionId == task.ExpressionId && t.Status == string(models.StatusPending) {
-			if strings.HasPrefix(t.Arg1, "task") && t.Arg1 == fmt.Sprintf("task%d", task.Id) {
-				t.Arg1 = fmt.Sprintf("%f", task.Result)
-			}
-			if strings.HasPrefix(t.Arg2, "task") && t.Arg2 == fmt.Sprintf("task%d", task.Id) {
-				t.Arg2 = fmt.Sprintf("%f", task.Result)
-			}
-			o.tasks[i] = t
-		}
+	if task.Status == models.StatusError {
+		err = o.failExpression(task)
+	} else {
+		o.cacheResult(task)
+		err = o.completeTask(task)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Проверка, все ли задачи для этого выражения выполнены
-	allTasksDone := true
-	for _, t := range o.tasks {
-		if t.ExpressionId == task.ExpressionId && t.Status != string(models.StatusDone) && t.Status != string(models.StatusError) {
-			allTasksDone = false
-			break
-		}
+	return &pb.SuccessResponse{Message: "Task result accepted."}, nil
+}
+
+// completeTask подставляет результат выполненной задачи в зависимые задачи, открывает ветки условий
+// и завершает выражение, когда все его задачи выполнены.
+// Вызывается под o.mu.
+func (o *Orchestrator) completeTask(task models.Task) error {
+	if err := o.db.TaskRepo.ResolveReference(task.ExpressionId, task.Id, task.Value); err != nil {
+		return err
+	}
+
+	if err := o.applyCondition(task); err != nil {
+		return err
 	}
 
-	if allTasksDone {
+	unfinished, err := o.db.TaskRepo.CountUnfinished(task.ExpressionId)
+	if err != nil {
+		return err
+	}
+
+	// Последней выполняется задача корня выражения: от неё зависят все остальные
+	if unfinished == 0 {
 		expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		expression.Status = models.StatusDone
+		setResult(&expression, task.Value)
+		if err := o.saveFinished(expression); err != nil {
+			return err
 		}
-		expression.Result = task.Result
-		if task.Status == string(models.StatusError) {
-			expression.Status = models.StatusError
-			expression.Error = task.Error
+
+		o.publishExpression(expression)
+		return nil
+	}
+
+	o.notifyTasksReady()
+	return o.resolveConditionals(task.ExpressionId)
+}
+
+// applyCondition открывает задачи ветки, которую выбрал результат задачи-условия cond,
+// и пропускает задачи другой ветки вместе со всеми вложенными в неё условиями.
+// Вызывается под o.mu.
+func (o *Orchestrator) applyCondition(cond models.Task) error {
+	branches, err := o.db.TaskRepo.GetTasksByCondition(cond.Id)
+	if err != nil {
+		return err
+	}
+
+	// Задача не условие: веток у неё нет
+	if len(branches) == 0 {
+		return nil
+	}
+
+	truth, err := taskDomain(cond).Truth(cond.Value)
+	if err != nil {
+		return err
+	}
+
+	chosen := models.BranchElse
+	if truth {
+		chosen = models.BranchThen
+	}
+
+	// Задачи пропущенной ветки могут сами быть условиями вложенных if:
+	// их ветки тоже пропускаются, поэтому обход продолжается по очереди
+	var skipped []int64
+	for _, task := range branches {
+		if task.Branch == chosen {
+			task.Status = models.StatusPending
 		} else {
-			expression.Status = models.StatusDone
+			task.Status = models.StatusSkipped
+			skipped = append(skipped, task.Id)
 		}
-		o.db.ExpressionRepo.UpdateExpression(task.ExpressionId, expression)
 
-		// Удаляет все задачи, связанные с выполненным выражением
-		var remainingTasks []*pb.Task
-		for _, t := range o.tasks {
-			if t.ExpressionId != expression.Id {
-				remainingTasks = append(remainingTasks, t)
+		if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+			return err
+		}
+	}
+
+	for len(skipped) > 0 {
+		nested, err := o.db.TaskRepo.GetTasksByCondition(skipped[0])
+		if err != nil {
+			return err
+		}
+		skipped = skipped[1:]
+
+		for _, task := range nested {
+			task.Status = models.StatusSkipped
+			if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+				return err
 			}
+			skipped = append(skipped, task.Id)
 		}
-		o.tasks = remainingTasks
 	}
 
-	return &pb.SuccessResponse{Message: "Task result accepted."}, nil
+	return nil
+}
+
+// resolveConditionals выполняет задачи if, аргументы которых уже вычислены.
+// Когда известно условие, аргументом if остаётся только выбранная ветка;
+// когда известна и она, задача if завершается её значением.
+// Вызывается под o.mu.
+func (o *Orchestrator) resolveConditionals(expressionId int64) error {
+	tasks, err := o.db.TaskRepo.GetTasksByExpression(expressionId)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if task.Operation != calculation.Conditional || task.Status != models.StatusPending || isTaskReference(task.Args[0]) {
+			continue
+		}
+
+		d := taskDomain(task)
+		if len(task.Args) == 3 {
+			truth, err := d.Truth(task.Args[0])
+			if err != nil {
+				return err
+			}
+
+			if truth {
+				task.Args = task.Args[1:2]
+			} else {
+				task.Args = task.Args[2:3]
+			}
+
+			if isTaskReference(task.Args[0]) {
+				if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		value, err := d.Normalize(task.Args[0])
+		if err != nil {
+			return err
+		}
+
+		task.Status = models.StatusDone
+		task.Value = value
+		task.Result = d.Float(value)
+		task.FinishedAt = time.Now()
+		if err := o.db.TaskRepo.UpdateTask(task); err != nil {
+			return err
+		}
+		o.publishTask(task)
+
+		log.Printf("resolved condition task %d: %s", task.Id, value)
+
+		// completeTask снова вызовет resolveConditionals для условий, которые ждали эту задачу
+		return o.completeTask(task)
+	}
+
+	return nil
+}
+
+// resultValue возвращает точный результат задачи строкой.
+// Агенты, которые не знают про value, присылают только result: его можно принять лишь для float64.
+func resultValue(task models.Task, in *pb.TaskResult) (string, error) {
+	if in.Value != "" {
+		return in.Value, nil
+	}
+
+	if calculation.Mode(task.Mode) != calculation.ModeFloat {
+		return "", fmt.Errorf("agent does not support %s mode", task.Mode)
+	}
+
+	return strconv.FormatFloat(in.Result, 'g', -1, 64), nil
+}
+
+// cacheResult сохраняет результат задачи в кэш результатов. Результат дешёвой операции (см. isCheap)
+// оркестратор пересчитывает сам: совпавший результат становится общим для всех пользователей
+// и сохраняется в базу, а неверный не кэшируется. Результат остальных операций становится общим,
+// когда такой же результат той же операции прислал другой агент (для любого пользователя);
+// до этого он виден только пользователю выражения и не сохраняется в базу.
+// Вызывается под o.mu.
+func (o *Orchestrator) cacheResult(task models.Task) {
+	d := taskDomain(task)
+
+	if o.isCheap(task.Operation) {
+		value, err := d.Normalize(task.Value)
+		if expected, applyErr := d.Apply(task.Operation, task.Args...); err != nil || applyErr != nil || value != expected {
+			log.Printf("result %q of task %d does not match the orchestrator, not cached", task.Value, task.Id)
+			return
+		}
+
+		o.results.put(resultKey(task.Operation, task.Args, d), task.Value, true)
+		return
+	}
+
+	key := resultKey(task.Operation, task.Args, d)
+	if o.results.confirm(key, task.Value, task.AgentId) {
+		o.results.put(key, task.Value, true)
+		return
+	}
+
+	expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
+	if err != nil {
+		log.Printf("Failed to cache result of task %d: %v", task.Id, err)
+		return
+	}
+
+	o.results.put(userResultKey(expression.UserID, task.Operation, task.Args, d), task.Value, false)
 }
 
-// isTaskReference проверяет, является ли аргумент ссылкой на задачу
-func isTaskReference(arg string) bool {
-	return strings.HasPrefix(arg, "task")
+// cancelExpression отменяет выражение: снимает его задачи с очереди
+// и сообщает агентам, которые уже выполняют задачи этого выражения.
+// Вызывается под o.mu.
+func (o *Orchestrator) cancelExpression(expression models.Expression) error {
+	if err := o.stopTasks(expression.Id); err != nil {
+		return err
+	}
+
+	expression.Status = models.StatusCancelled
+	if err := o.db.ExpressionRepo.UpdateExpression(expression.Id, expression); err != nil {
+		return err
+	}
+	o.publishExpression(expression)
+
+	log.Printf("cancelled expression %d", expression.Id)
+	return nil
+}
+
+// failExpression завершает выражение ошибкой задачи. Остальные задачи выражения отменяются:
+// без результата этой задачи зависящие от неё задачи никогда не станут готовыми.
+// Вызывается под o.mu.
+func (o *Orchestrator) failExpression(task models.Task) error {
+	expression, err := o.db.ExpressionRepo.GetExpressionByID(task.ExpressionId)
+	if err != nil {
+		return err
+	}
+
+	if err := o.stopTasks(expression.Id); err != nil {
+		return err
+	}
+
+	expression.Status = models.StatusError
+	expression.Error = task.Error
+	if err := o.saveFinished(expression); err != nil {
+		return err
+	}
+
+	o.publishExpression(expression)
+	return nil
+}
+
+// stopTasks отменяет незавершённые задачи выражения и сообщает агентам, которые их уже выполняют.
+// Вызывается под o.mu.
+func (o *Orchestrator) stopTasks(expressionId int64) error {
+	tasks, err := o.db.TaskRepo.GetTasksByExpression(expressionId)
+	if err != nil {
+		return err
+	}
+
+	if err := o.db.TaskRepo.CancelByExpression(expressionId, time.Now()); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if task.Status == models.StatusComputing && task.AgentId != "" {
+			if o.agents.cancelTask(task.AgentId, task.Id) {
+				log.Printf("asked agent %q to cancel task %d", task.AgentId, task.Id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Register регистрирует агента в реестре оркестратора.
+func (o *Orchestrator) Register(ctx context.Context, in *pb.AgentInfo) (*pb.SuccessResponse, error) {
+	if in.Id == "" {
+		return nil, fmt.Errorf("agent id can't be empty")
+	}
+
+	o.agents.register(in)
+	log.Printf("Registered agent %q (host: %s, computing power: %d, version: %s)",
+		in.Id, in.Hostname, in.ComputingPower, in.Version)
+
+	return &pb.SuccessResponse{Message: "Agent registered."}, nil
+}
+
+// Heartbeat отмечает, что агент продолжает работать.
+func (o *Orchestrator) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.SuccessResponse, error) {
+	if err := o.agents.heartbeat(in.AgentId); err != nil {
+		return nil, err
+	}
+
+	return &pb.SuccessResponse{Message: "Heartbeat accepted."}, nil
 }
@@ -2,46 +2,150 @@ package orchestrator
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/MoodyShoo/go-http-calculator/internal/database"
 	"github.com/MoodyShoo/go-http-calculator/internal/middleware"
 	"github.com/MoodyShoo/go-http-calculator/internal/models"
 	"github.com/MoodyShoo/go-http-calculator/internal/util"
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
 )
 
-// handleCalculateRequest обрабатывает запрос на вычисление выражения.
-func (o *Orchestrator) handleCalculateRequest(req models.Request, userId int64) (int64, error) {
+// handleCalculateRequest обрабатывает запрос на вычисление выражения и возвращает сохранённое выражение.
+func (o *Orchestrator) handleCalculateRequest(req models.Request, d calculation.Domain, userId int64) (models.Expression, error) {
+	vars, functions, err := o.userDefinitions(userId)
+	if err != nil {
+		return models.Expression{}, err
+	}
+
+	exp, tasks, err := o.prepareExpression(req, d, userId, vars, functions)
+	if err != nil {
+		return models.Expression{}, err
+	}
+
+	// Выражение и задачи сохраняются вместе: выражение с частью задач никогда не было бы вычислено
+	err = o.db.Transaction(func(tx *database.Database) error {
+		exp, err = o.storeExpression(tx, exp, tasks)
+		return err
+	})
+	if err != nil {
+		return models.Expression{}, err
+	}
+
+	o.acceptExpression(exp)
+	return exp, nil
+}
+
+// prepareExpression разбирает выражение запроса и строит его задачи, ничего не сохраняя в базу.
+// Если задачи не нужны, выражение возвращается уже вычисленным.
+func (o *Orchestrator) prepareExpression(req models.Request, d calculation.Domain, userId int64,
+	vars map[string]float64, functions []models.Function) (models.Expression, []models.Task, error) {
 	exp := models.Expression{
-		Expr:   req.Expression,
-		Status: models.StatusPending,
-		UserID: userId,
+		Expr:        req.Expression,
+		Status:      models.StatusPending,
+		Mode:        string(d.Mode),
+		Scale:       d.Scale,
+		UserID:      userId,
+		CallbackURL: req.CallbackURL,
 	}
 
-	id, err := o.db.ExpressionRepo.InsertExpression(exp)
+	tasks, root, err := o.buildTasks(&exp, vars, functions)
 	if err != nil {
-		return 0, fmt.Errorf("failed to insert expression: %v", err)
+		return models.Expression{}, nil, err
+	}
+
+	if len(tasks) == 0 {
+		if err := completeWithoutTasks(&exp, root); err != nil {
+			return models.Expression{}, nil, err
+		}
 	}
 
-	err = o.addTasks()
+	return exp, tasks, nil
+}
+
+// storeExpression сохраняет выражение и его задачи через db (базу или транзакцию) и возвращает выражение с Id.
+// Для выражения, вычисленного без задач, там же ставится в очередь webhook.
+func (o *Orchestrator) storeExpression(db *database.Database, exp models.Expression, tasks []models.Task) (models.Expression, error) {
+	id, err := db.ExpressionRepo.InsertExpression(exp)
 	if err != nil {
-		return 0, err
+		return models.Expression{}, fmt.Errorf("failed to insert expression: %v", err)
+	}
+
+	if err := o.saveTasks(db, id, tasks); err != nil {
+		return models.Expression{}, err
+	}
+
+	exp.Id = id
+	if isFinished(exp.Status) {
+		if err := enqueueWebhook(db, exp); err != nil {
+			return models.Expression{}, err
+		}
 	}
 
-	return id, nil
+	return exp, nil
 }
 
-// CalculateHandler обрабатывает HTTP-запрос на вычисление выражения
+// acceptExpression сообщает о сохранённом выражении подписчикам,
+// а если оно вычислено без задач - будит отправку его webhook.
+// Вызывается под o.mu после фиксации транзакции, в которой выражение сохранено.
+func (o *Orchestrator) acceptExpression(exp models.Expression) {
+	o.results.flush()
+	o.publishExpression(exp)
+	if isFinished(exp.Status) {
+		o.notifyWebhooks()
+	}
+}
+
+// userDefinitions возвращает переменные и пользовательские функции пользователя.
+func (o *Orchestrator) userDefinitions(userId int64) (map[string]float64, []models.Function, error) {
+	vars, err := o.userVariables(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	functions, err := o.db.FunctionRepo.GetFunctionsByUser(userId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load functions: %v", err)
+	}
+
+	return vars, functions, nil
+}
+
+// userVariables возвращает переменные пользователя в виде имя -> значение.
+func (o *Orchestrator) userVariables(userId int64) (map[string]float64, error) {
+	variables, err := o.db.VariableRepo.GetVariablesByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load variables: %v", err)
+	}
+
+	vars := make(map[string]float64, len(variables))
+	for _, v := range variables {
+		vars[v.Name] = v.Value
+	}
+
+	return vars, nil
+}
+
+// CalculateHandler обрабатывает HTTP-запрос на вычисление выражения.
+// С параметром ?wait=10s ждёт завершения выражения и возвращает его целиком (200),
+// а если за это время выражение не завершилось - отвечает как без ожидания (202).
 func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("CalculateHandler: started")
 	defer log.Printf("CalculateHandler: finished")
 
-	o.mu.Lock()
-	defer o.mu.Unlock()
+	wait, err := waitDuration(r)
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var req models.Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -50,8 +154,9 @@ func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if req.Expression == "" {
-		util.SendError(w, "unprocessable entity", http.StatusUnprocessableEntity)
+	domain, err := o.validateRequest(req)
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
@@ -63,14 +168,48 @@ func (o *Orchestrator) CalculateHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	expressionId, err := o.handleCalculateRequest(req, userId)
+	// o.mu не держится во время ожидания, иначе выражение не смогло бы завершиться
+	o.mu.Lock()
+	exp, err := o.handleCalculateRequest(req, domain, userId)
+	o.mu.Unlock()
+
+	var syntaxErr *calculation.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		log.Printf("CalculateHandler: invalid expression: %v", err)
+		util.SendError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		log.Printf("CalculateHandler: %v", err)
 		util.SendError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	util.SendResponse(w, &models.AcceptedResponse{Id: expressionId}, http.StatusAccepted)
+	if wait > 0 {
+		exp = o.waitExpression(r.Context(), exp, wait)
+		if isFinished(exp.Status) {
+			util.SendResponse(w, &exp, http.StatusOK)
+			return
+		}
+	}
+
+	util.SendResponse(w, &models.AcceptedResponse{Id: exp.Id, TasksSaved: exp.TasksSaved}, http.StatusAccepted)
+}
+
+// waitDuration возвращает время ожидания из параметра ?wait= (например, 10s или 500ms), не больше maxWait.
+// Без параметра возвращает 0.
+func waitDuration(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait duration %q", value)
+	}
+
+	return min(wait, maxWait), nil
 }
 
 // ExpressionsHandler возвращает список всех выражений
@@ -103,8 +242,15 @@ func (o *Orchestrator) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 	util.SendResponse(w, &models.ExpressionsResponse{Expressions: response}, http.StatusOK)
 }
 
-// ExpressionIdHandler возвращает выражение по его ID
+// ExpressionIdHandler возвращает выражение по его ID вместе с ходом вычисления (GET) или отменяет его (DELETE).
+// С параметром ?include=tasks в ответ добавляется дерево задач выражения.
 func (o *Orchestrator) ExpressionIdHandler(w http.ResponseWriter, r *http.Request) {
+	// Поток событий не должен держать o.mu, пока открыт
+	if strings.HasSuffix(r.URL.Path, EventsSuffix) {
+		o.ExpressionEventsHandler(w, r)
+		return
+	}
+
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
@@ -130,7 +276,328 @@ func (o *Orchestrator) ExpressionIdHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	util.SendResponse(w, &expression, http.StatusOK)
+	switch r.Method {
+	case http.MethodGet:
+		tasks, err := o.db.TaskRepo.GetTasksByExpression(id)
+		if err != nil {
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// У выражений, посчитанных без агентов, задач нет
+		if len(tasks) > 0 {
+			expression.Progress = progress(tasks, time.Now())
+		}
+
+		if slices.Contains(strings.Split(r.URL.Query().Get("include"), ","), "tasks") {
+			expression.Tasks = taskTree(tasks)
+		}
+
+		util.SendResponse(w, &expression, http.StatusOK)
+	case http.MethodDelete:
+		if isFinished(expression.Status) {
+			util.SendError(w, "expression already finished", http.StatusConflict)
+			return
+		}
+
+		if err := o.cancelExpression(expression); err != nil {
+			log.Printf("ExpressionIdHandler: failed to cancel expression %d: %v", id, err)
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		expression.Status = models.StatusCancelled
+		util.SendResponse(w, &expression, http.StatusOK)
+	default:
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ExpressionEventsHandler отправляет события выражения по SSE, пока оно не завершится.
+// Первое событие - текущее состояние выражения.
+func (o *Orchestrator) ExpressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, ExpressionIdRoute), EventsSuffix)
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		util.SendError(w, "invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	// Подписка создаётся до чтения выражения, чтобы не пропустить события между ними
+	sub := o.events.subscribe(userId, id)
+	defer o.events.unsubscribe(sub)
+
+	o.mu.Lock()
+	expression, err := o.db.ExpressionRepo.GetExpressionByIDByUser(id, userId)
+	o.mu.Unlock()
+	if err != nil {
+		util.SendError(w, "expression not found", http.StatusNotFound)
+		return
+	}
+
+	current := models.Event{Type: models.EventExpression, ExpressionId: id, Expression: &expression}
+	streamEvents(w, r, sub, &current)
+}
+
+// EventsHandler отправляет по SSE события всех выражений пользователя.
+func (o *Orchestrator) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	sub := o.events.subscribe(userId, 0)
+	defer o.events.unsubscribe(sub)
+
+	streamEvents(w, r, sub, nil)
+}
+
+// isFinished проверяет, что выражение больше не вычисляется.
+func isFinished(status models.Status) bool {
+	return status == models.StatusDone || status == models.StatusError || status == models.StatusCancelled
+}
+
+// VariablesHandler возвращает переменные пользователя (GET) или сохраняет переменную (POST)
+func (o *Orchestrator) VariablesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("VariablesHandler: received %s request", r.Method)
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		variables, err := o.db.VariableRepo.GetVariablesByUser(userId)
+		if err != nil {
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if variables == nil {
+			variables = make([]models.Variable, 0)
+		}
+
+		util.SendResponse(w, &models.VariablesResponse{Variables: variables}, http.StatusOK)
+	case http.MethodPost:
+		var req models.VariableRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil {
+			util.SendError(w, "unprocessable entity", http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := calculation.ValidateName(req.Name); err != nil {
+			util.SendError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		variable := models.Variable{Name: req.Name, Value: *req.Value}
+		if err := o.db.VariableRepo.SetVariable(userId, variable); err != nil {
+			log.Printf("VariablesHandler: failed to save variable %s: %v", req.Name, err)
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("VariablesHandler: saved variable %s = %g for user %d", variable.Name, variable.Value, userId)
+		util.SendResponse(w, &variable, http.StatusOK)
+	default:
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FunctionsHandler возвращает функции пользователя (GET) или сохраняет функцию (POST).
+// Перед сохранением функция разбирается и проверяется вместе с остальными функциями пользователя.
+func (o *Orchestrator) FunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("FunctionsHandler: received %s request", r.Method)
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		functions, err := o.db.FunctionRepo.GetFunctionsByUser(userId)
+		if err != nil {
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if functions == nil {
+			functions = make([]models.Function, 0)
+		}
+
+		util.SendResponse(w, &models.FunctionsResponse{Functions: functions}, http.StatusOK)
+	case http.MethodPost:
+		var req models.FunctionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Body == "" {
+			util.SendError(w, "unprocessable entity", http.StatusUnprocessableEntity)
+			return
+		}
+
+		existing, err := o.db.FunctionRepo.GetFunctionsByUser(userId)
+		if err != nil {
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		defs := make([]calculation.FunctionDefinition, len(existing))
+		for i, f := range existing {
+			defs[i] = calculation.FunctionDefinition(f)
+		}
+
+		function := models.Function{Name: req.Name, Params: req.Params, Body: req.Body}
+		if function.Params == nil {
+			function.Params = make([]string, 0)
+		}
+
+		if err := calculation.ValidateFunction(calculation.FunctionDefinition(function), defs); err != nil {
+			util.SendError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := o.db.FunctionRepo.SetFunction(userId, function); err != nil {
+			log.Printf("FunctionsHandler: failed to save function %s: %v", req.Name, err)
+			util.SendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("FunctionsHandler: saved function %s(%s) = %s for user %d",
+			function.Name, strings.Join(function.Params, ", "), function.Body, userId)
+		util.SendResponse(w, &function, http.StatusOK)
+	default:
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AgentsHandler возвращает список зарегистрированных агентов с их задачами
+func (o *Orchestrator) AgentsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("AgentsHandler: started")
+	defer log.Printf("AgentsHandler: finished")
+
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	o.mu.Lock()
+	inFlight, err := o.db.TaskRepo.GetInFlightByAgent()
+	if err != nil {
+		o.mu.Unlock()
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	completed, err := o.db.TaskRepo.CountCompletedByAgent()
+	o.mu.Unlock()
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	agents := o.agents.list()
+	timeout := time.Duration(o.config.AgentTimeoutMs) * time.Millisecond
+	for i := range agents {
+		agents[i].Online = time.Since(agents[i].LastHeartbeat) < timeout
+		agents[i].InFlight = inFlight[agents[i].Id]
+		if agents[i].InFlight == nil {
+			agents[i].InFlight = make([]int64, 0)
+		}
+		agents[i].Completed = completed[agents[i].Id]
+	}
+
+	util.SendResponse(w, &models.AgentsResponse{Agents: agents}, http.StatusOK)
+}
+
+// CacheHandler возвращает размер кэша результатов операций и счётчики попаданий и промахов
+func (o *Orchestrator) CacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := o.results.stats()
+	util.SendResponse(w, &stats, http.StatusOK)
+}
+
+// DeliveriesHandler возвращает доставки webhook пользователя, от новых к старым
+func (o *Orchestrator) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("DeliveriesHandler: started")
+	defer log.Printf("DeliveriesHandler: finished")
+
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	deliveries, err := o.db.WebhookRepo.GetDeliveriesByUser(userId)
+	o.mu.Unlock()
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = make([]models.WebhookDelivery, 0)
+	}
+
+	util.SendResponse(w, &models.WebhookDeliveriesResponse{Deliveries: deliveries}, http.StatusOK)
+}
+
+// WebhookSecretHandler возвращает секрет, которым подписываются webhook пользователя
+func (o *Orchestrator) WebhookSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		util.SendError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	secret, err := o.db.WebhookRepo.GetSecret(userId)
+	o.mu.Unlock()
+	if err != nil {
+		util.SendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	util.SendResponse(w, &models.WebhookSecretResponse{Secret: secret}, http.StatusOK)
 }
 
 // Хендлер регистрации
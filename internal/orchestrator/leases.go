@@ -0,0 +1,44 @@
+package orchestrator
+
+import (
+	"log"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+// leaseDuration возвращает время, на которое агент получает задачу:
+// время выполнения операции плюс запас на сеть и задержки агента.
+func (o *Orchestrator) leaseDuration(task models.Task) time.Duration {
+	return time.Duration(task.OperationTime+int64(o.config.TaskLeaseGraceMs)) * time.Millisecond
+}
+
+// ReleaseExpiredLeases возвращает в очередь задачи, агенты которых не прислали результат вовремя.
+func (o *Orchestrator) ReleaseExpiredLeases() (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	released, err := o.db.TaskRepo.ReleaseExpired(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if released > 0 {
+		log.Printf("Released %d tasks with expired lease", released)
+		o.notifyTasksReady()
+	}
+
+	return released, nil
+}
+
+// runLeaseReaper периодически освобождает просроченные задачи.
+func (o *Orchestrator) runLeaseReaper() {
+	ticker := time.NewTicker(time.Duration(o.config.LeaseCheckIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := o.ReleaseExpiredLeases(); err != nil {
+			log.Printf("Lease reaper error: %v", err)
+		}
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/MoodyShoo/go-http-calculator/internal/auth"
 	"github.com/MoodyShoo/go-http-calculator/internal/database"
+	resultrepo "github.com/MoodyShoo/go-http-calculator/internal/database/repository/result_repo"
 	"github.com/MoodyShoo/go-http-calculator/internal/middleware"
 	"github.com/MoodyShoo/go-http-calculator/internal/models"
 	pb "github.com/MoodyShoo/go-http-calculator/internal/proto"
@@ -20,105 +23,465 @@ import (
 
 type Orchestrator struct {
 	pb.OrchestratorServiceServer
-	config     *Config
-	db         *database.Database
-	Ts         auth.TokenStore
-	tasks      []*pb.Task
-	nextTaskId int64
-	mu         sync.Mutex
+	config  *Config
+	db      *database.Database
+	Ts      auth.TokenStore
+	mu      sync.Mutex
+	ready   chan struct{}
+	agents  *registry
+	results *resultCache
+	events  *eventHub
+	// webhooksReady будит отправку webhook, когда в очереди появилась доставка
+	webhooksReady chan struct{}
+	webhookClient *http.Client
 }
 
 func New(db *database.Database) *Orchestrator {
-	return &Orchestrator{
-		config:     configFromEnv(),
-		db:         db,
-		Ts:         *auth.NewTokenStore(),
-		tasks:      make([]*pb.Task, 0),
-		nextTaskId: 1,
+	config := configFromEnv()
+
+	var repo *resultrepo.ResultRepo
+	if config.ResultCachePersist {
+		repo = db.ResultRepo
 	}
-}
 
-// isNumber проверяет, является ли строка числом.
-func isNumber(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
-	return err == nil
+	return &Orchestrator{
+		config:  config,
+		db:      db,
+		Ts:      *auth.NewTokenStore(),
+		ready:   make(chan struct{}),
+		agents:  newRegistry(),
+		results: newResultCache(config.ResultCacheSize, repo),
+		events:  newEventHub(),
+
+		webhooksReady: make(chan struct{}, 1),
+		webhookClient: newWebhookClient(config),
+	}
 }
 
 // operationTime возвращает время выполнения операции.
-func (o *Orchestrator) operationTime(operation rune) int {
+func (o *Orchestrator) operationTime(operation string) int {
 	switch operation {
-	case '+':
+	case "+":
 		return o.config.TimeAdditionMs
-	case '-':
+	case "-":
 		return o.config.TimeSubtractionMs
-	case '*':
+	case "*":
 		return o.config.TimeMultiplicationsMs
-	case '/':
+	case "/":
 		return o.config.TimeDivisionsMs
-	default:
+	case "^":
+		return o.config.TimePowerMs
+	case "%":
+		return o.config.TimeModuloMs
+	case calculation.Negation:
+		return o.config.TimeNegationMs
+	case calculation.Factorial:
+		return o.config.TimeFactorialMs
+	case "==", "!=", "<", "<=", ">", ">=":
+		return o.config.TimeComparisonMs
+	case "&&", "||", calculation.Not:
+		return o.config.TimeLogicalMs
+	case calculation.Conditional:
+		// Условие выбирает ветку сам оркестратор, агенту задача не отправляется
 		return 0
+	default:
+		return o.config.TimeFunctionsMs[operation]
+	}
+}
+
+// isCheap проверяет, можно ли вычислить операцию над числами сразу, не отправляя задачу агенту.
+func (o *Orchestrator) isCheap(operation string) bool {
+	return o.config.CheapOperations[operation]
+}
+
+// taskReference возвращает ссылку на результат задачи, которая используется в аргументах других задач.
+func taskReference(id int64) string {
+	return fmt.Sprintf("task%d", id)
+}
+
+// isTaskReference проверяет, является ли аргумент ссылкой на результат другой задачи.
+func isTaskReference(arg string) bool {
+	return strings.HasPrefix(arg, "task")
+}
+
+// guard - условие и ветка if, внутри которой создаётся задача. Нулевое значение - задача вне веток if.
+type guard struct {
+	conditionId int64
+	branch      string
+}
+
+// createTasks создает задачи для выражения, обходя его дерево снизу вверх.
+// Задачи нумеруются по порядку, начиная с 1, и ссылаются друг на друга по этим номерам.
+// Настоящие идентификаторы задачи получают при сохранении в saveTasks.
+// Вторым значением возвращается результат выражения: ссылка на последнюю задачу
+// или само число, если задачи не нужны (например, для "-5").
+// Все задачи вычисляются в режиме домена d.
+//
+// Одинаковые подвыражения, например (2+3)*(2+3), вычисляются одной задачей: задача с той же операцией
+// и теми же аргументами не создаётся повторно. Аргументы коммутативных операций сравниваются без учёта порядка.
+// Если результат операции над числами уже есть в кэше результатов (общий или пользователя userId),
+// задача не создаётся, а вместо ссылки на неё подставляется сам результат.
+//
+// Для if(cond, then, else) создаются задачи условия, задачи обеих веток в статусе StatusBlocked
+// с ConditionId = задача условия и задача if с аргументами [cond, then, else].
+// Если условие - число, задачи создаются только для выбранной ветки.
+// Задача ветки переиспользуется только внутри той же ветки: задача невыбранной ветки не будет выполнена.
+// Вложенный if с тем же условием сразу заменяется веткой, в которой он находится.
+func (o *Orchestrator) createTasks(root calculation.Node, d calculation.Domain, userId int64) ([]models.Task, string, error) {
+	var tasks []models.Task
+
+	// Вложенные ветки if, в которых сейчас создаются задачи. guards[0] - задачи вне веток
+	guards := []guard{{}}
+
+	type created struct {
+		ref   string
+		guard guard
+	}
+	seen := make(map[string]created)
+	ids := make(map[string]int64)
+
+	addTask := func(operation string, args ...string) string {
+		current := guards[len(guards)-1]
+
+		key := taskKey(operation, args)
+		if task, ok := seen[key]; ok && slices.Contains(guards, task.guard) {
+			return task.ref
+		}
+
+		if operation != calculation.Conditional && !slices.ContainsFunc(args, isTaskReference) {
+			if value, ok := o.results.get(resultKey(operation, args, d), userResultKey(userId, operation, args, d)); ok {
+				return value
+			}
+		}
+
+		task := models.Task{
+			Id:            int64(len(tasks) + 1),
+			Args:          args,
+			Operation:     operation,
+			OperationTime: int64(o.operationTime(operation)),
+			Status:        models.StatusPending,
+			Mode:          string(d.Mode),
+			Scale:         d.Scale,
+			ConditionId:   current.conditionId,
+			Branch:        current.branch,
+		}
+		if current.conditionId != 0 {
+			task.Status = models.StatusBlocked
+		}
+
+		tasks = append(tasks, task)
+
+		ref := taskReference(task.Id)
+		seen[key] = created{ref: ref, guard: current}
+		ids[ref] = task.Id
+		return ref
+	}
+
+	var walk func(node calculation.Node) (string, error)
+	walk = func(node calculation.Node) (string, error) {
+		switch n := node.(type) {
+		case *calculation.NumberNode:
+			return n.Value, nil
+		case *calculation.UnaryNode:
+			arg, err := walk(n.Operand)
+			if err != nil {
+				return "", err
+			}
+			return addTask(n.Op, arg), nil
+		case *calculation.BinaryNode:
+			arg1, err := walk(n.Left)
+			if err != nil {
+				return "", err
+			}
+			arg2, err := walk(n.Right)
+			if err != nil {
+				return "", err
+			}
+			return addTask(n.Op, arg1, arg2), nil
+		case *calculation.CallNode:
+			args := make([]string, len(n.Args))
+			for i, argNode := range n.Args {
+				arg, err := walk(argNode)
+				if err != nil {
+					return "", err
+				}
+				args[i] = arg
+			}
+			return addTask(n.Name, args...), nil
+		case *calculation.IfNode:
+			cond, err := walk(n.Cond)
+			if err != nil {
+				return "", err
+			}
+
+			if !isTaskReference(cond) {
+				truth, err := d.Truth(cond)
+				if err != nil {
+					return "", err
+				}
+				if truth {
+					return walk(n.Then)
+				}
+				return walk(n.Else)
+			}
+
+			// Внутри ветки того же условия результат if уже известен
+			for _, g := range guards {
+				if g.conditionId == ids[cond] && g.branch == models.BranchThen {
+					return walk(n.Then)
+				}
+				if g.conditionId == ids[cond] && g.branch == models.BranchElse {
+					return walk(n.Else)
+				}
+			}
+
+			// Задачи веток ждут результата задачи условия
+			guards = append(guards, guard{conditionId: ids[cond], branch: models.BranchThen})
+			then, err := walk(n.Then)
+			if err != nil {
+				return "", err
+			}
+
+			guards[len(guards)-1].branch = models.BranchElse
+			otherwise, err := walk(n.Else)
+			if err != nil {
+				return "", err
+			}
+
+			guards = guards[:len(guards)-1]
+			return addTask(calculation.Conditional, cond, then, otherwise), nil
+		}
+
+		return "", fmt.Errorf("unsupported node %T at position %d", node, node.Pos())
 	}
+
+	result, err := walk(root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tasks, result, nil
 }
 
-// createTasks создает задачи для выражения.
-func (o *Orchestrator) createTasks(tokens []string, expressionId int64) ([]*pb.Task, error) {
-	var tasks []*pb.Task
-	var stack []string
+// taskKey возвращает ключ, по которому находятся одинаковые задачи.
+func taskKey(operation string, args []string) string {
+	if calculation.IsCommutative(operation) {
+		args = slices.Clone(args)
+		slices.Sort(args)
+	}
+	return operation + "(" + strings.Join(args, ",") + ")"
+}
 
-	for _, token := range tokens {
-		if isNumber(token) {
-			stack = append(stack, token)
-		} else if calculation.IsOperator(rune(token[0])) {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("not enough operands for operator: %s", token)
+// countTasks возвращает, сколько задач создал бы createTasks для дерева без упрощений и без повторного
+// использования задач. Разница с настоящим числом задач показывает, сколько задач сэкономлено.
+func countTasks(node calculation.Node, d calculation.Domain) int {
+	switch n := node.(type) {
+	case *calculation.UnaryNode:
+		return countTasks(n.Operand, d) + 1
+	case *calculation.BinaryNode:
+		return countTasks(n.Left, d) + countTasks(n.Right, d) + 1
+	case *calculation.CallNode:
+		count := 1
+		for _, arg := range n.Args {
+			count += countTasks(arg, d)
+		}
+		return count
+	case *calculation.IfNode:
+		if number, ok := n.Cond.(*calculation.NumberNode); ok {
+			if truth, err := d.Truth(number.Value); err == nil {
+				if truth {
+					return countTasks(n.Then, d)
+				}
+				return countTasks(n.Else, d)
 			}
+		}
+		return countTasks(n.Cond, d) + countTasks(n.Then, d) + countTasks(n.Else, d) + 1
+	}
 
-			arg2 := stack[len(stack)-1]
-			arg1 := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
-
-			task := &pb.Task{
-				Id:            int64(o.nextTaskId),
-				ExpressionId:  int64(expressionId),
-				Arg1:          arg1,
-				Arg2:          arg2,
-				Operation:     token,
-				OperationTime: int64(o.operationTime(rune(token[0]))),
-				Status:        string(models.StatusPending),
+	return 0
+}
+
+// saveTasks сохраняет задачи выражения через db (базу или транзакцию),
+// заменяя локальные ссылки task{n} и ConditionId на идентификаторы, выданные базой.
+// Для каждой ссылки в аргументах сохраняется ребро графа зависимостей (см. TaskRepo.InsertEdge).
+func (o *Orchestrator) saveTasks(db *database.Database, expressionId int64, tasks []models.Task) error {
+	ids := make(map[int64]int64, len(tasks))
+	refs := make(map[string]string, len(tasks))
+	dbIds := make(map[string]int64, len(tasks))
+	resolve := func(arg string) string {
+		if ref, ok := refs[arg]; ok {
+			return ref
+		}
+		return arg
+	}
+
+	for _, task := range tasks {
+		localRef := taskReference(task.Id)
+
+		task.ExpressionId = expressionId
+		args := make([]string, len(task.Args))
+		for i, arg := range task.Args {
+			args[i] = resolve(arg)
+		}
+		task.Args = args
+		if task.ConditionId != 0 {
+			task.ConditionId = ids[task.ConditionId]
+		}
+
+		id, err := db.TaskRepo.InsertTask(task)
+		if err != nil {
+			return fmt.Errorf("failed to save task: %v", err)
+		}
+
+		for _, arg := range args {
+			if dependency, ok := dbIds[arg]; ok {
+				if err := db.TaskRepo.InsertEdge(dependency, id); err != nil {
+					return fmt.Errorf("failed to save task dependency: %v", err)
+				}
 			}
+		}
+
+		ids[task.Id] = id
+		refs[localRef] = taskReference(id)
+		dbIds[taskReference(id)] = id
+		log.Printf("Added task id: %d; ExpressionId: %d; Args: %s; Operation: %s; OperationTime: %d;",
+			id, expressionId, strings.Join(task.Args, ", "), task.Operation, task.OperationTime)
+	}
+
+	o.notifyTasksReady()
+
+	return nil
+}
+
+// buildTasks разбирает выражение exp, раскрывает в нём пользовательские функции functions,
+// подставляет переменные vars, упрощает дерево (см. calculation.Domain.Simplify) и создает задачи в режиме выражения.
+// В exp.Variables и exp.Functions записываются переменные и функции, которые встретились в выражении,
+// в exp.TasksSaved - сколько задач сэкономили упрощение, повторное использование задач и кэш результатов,
+// а в exp.CriticalPathMs - сколько займёт вычисление при неограниченном числе агентов (см. criticalPath).
+// Ошибки разбора, неизвестные переменные и рекурсия в функциях возвращаются как есть (*calculation.SyntaxError),
+// чтобы клиент видел позицию ошибки.
+func (o *Orchestrator) buildTasks(exp *models.Expression, vars map[string]float64, functions []models.Function) ([]models.Task, string, error) {
+	defs := make([]calculation.FunctionDefinition, len(functions))
+	for i, f := range functions {
+		defs[i] = calculation.FunctionDefinition(f)
+	}
+
+	compiled, err := calculation.CompileFunctions(defs)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to compile functions: %v", err)
+	}
+
+	node, err := calculation.ParseWith(exp.Expr, compiled)
+	if err != nil {
+		return nil, "", err
+	}
+
+	node, usedFunctions, err := calculation.Inline(node, compiled)
+	if err != nil {
+		return nil, "", err
+	}
 
-			tasks = append(tasks, task)
-			o.nextTaskId++
-			stack = append(stack, fmt.Sprintf("task%d", task.Id))
-		} else {
-			return nil, fmt.Errorf("invalid token: %s", token)
+	node, used, err := calculation.Substitute(node, vars)
+	if err != nil {
+		return nil, "", err
+	}
+
+	d := expressionDomain(*exp)
+	if err := d.Check(node); err != nil {
+		return nil, "", err
+	}
+
+	tasks, root, err := o.createTasks(d.Simplify(node, o.isCheap), d, exp.UserID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create tasks: %v", err)
+	}
+
+	exp.TasksSaved = countTasks(node, d) - len(tasks)
+	exp.CriticalPathMs = criticalPath(tasks, time.Now())
+
+	exp.Variables = used
+	exp.Functions = nil
+	for _, f := range functions {
+		if usedFunctions[f.Name] {
+			exp.Functions = append(exp.Functions, f)
 		}
 	}
 
-	return tasks, nil
+	return tasks, root, nil
+}
+
+// taskDomain возвращает режим, в котором вычисляется задача.
+func taskDomain(task models.Task) calculation.Domain {
+	return calculation.Domain{Mode: calculation.Mode(task.Mode), Scale: task.Scale}
+}
+
+// expressionDomain возвращает режим, в котором вычисляется выражение.
+func expressionDomain(exp models.Expression) calculation.Domain {
+	return calculation.Domain{Mode: calculation.Mode(exp.Mode), Scale: exp.Scale}
+}
+
+// setResult записывает результат в выражение. Точное значение сохраняется только для режимов,
+// отличных от float64: для них Result - лишь приближение.
+func setResult(exp *models.Expression, value string) {
+	d := expressionDomain(*exp)
+	exp.Result = d.Float(value)
+	if d.Mode != calculation.ModeFloat {
+		exp.Value = value
+	}
+}
+
+// completeWithoutTasks сразу завершает выражение, если для него не нужно ни одной задачи.
+func completeWithoutTasks(exp *models.Expression, root string) error {
+	value, err := expressionDomain(*exp).Normalize(root)
+	if err != nil {
+		return fmt.Errorf("invalid expression result: %s", root)
+	}
+
+	exp.Status = models.StatusDone
+	setResult(exp, value)
+	return nil
 }
 
-func (o *Orchestrator) addTasks() error {
-	expressions, err := o.db.ExpressionRepo.GetComputingAndPending()
+// RestoreTasks ставит в очередь задачи выражений, которые были приняты, но не успели получить задачи
+// (например, если сервер остановился сразу после сохранения выражения).
+// Задачи остальных выражений уже лежат в базе и продолжают выполняться после перезапуска.
+func (o *Orchestrator) RestoreTasks() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.results.flush()
+
+	expressions, err := o.db.ExpressionRepo.GetUnscheduled()
 	if err != nil {
 		return err
 	}
 
 	for _, exp := range expressions {
-		tokens, err := calculation.ShuntingYard(exp.Expr)
-		if err != nil {
-			return fmt.Errorf("failed to parse expression: %v", err)
+		// Переменные и функции берутся из выражения, а не у пользователя: их могли изменить после отправки
+		tasks, root, err := o.buildTasks(&exp, exp.Variables, exp.Functions)
+		if err == nil && len(tasks) == 0 {
+			err = completeWithoutTasks(&exp, root)
 		}
 
-		tasks, err := o.createTasks(tokens, exp.Id)
+		// Выражение, которое больше не удаётся разобрать, завершается ошибкой:
+		// иначе оно навсегда осталось бы в очереди, а клиенты, ждущие его результата, не получили бы ответ
 		if err != nil {
-			return fmt.Errorf("failed to create tasks: %v", err)
+			log.Printf("Expression %d: %v", exp.Id, err)
+			exp.Status = models.StatusError
+			exp.Error = err.Error()
 		}
 
-		for _, task := range tasks {
-			o.tasks = append(o.tasks, task)
-			log.Printf("Added task id: %d; ExpressionId: %d; Arg1: %s; Arg2: %s; Operation: %s; OperationTime: %d;",
-				task.Id, task.ExpressionId, task.Arg1, task.Arg2, task.Operation, task.OperationTime)
+		if isFinished(exp.Status) {
+			if err := o.saveFinished(exp); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = o.db.Transaction(func(tx *database.Database) error {
+			return o.saveTasks(tx, exp.Id, tasks)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
@@ -130,8 +493,18 @@ func (o *Orchestrator) RunServer() error {
 	http.HandleFunc(RegisterRoute, o.RegisterHandler)
 	http.HandleFunc(LoginRoute, o.LoginHandler)
 	http.HandleFunc(CalculateRoute, middleware.AuthMiddleware(&o.Ts, o.CalculateHandler))
+	http.HandleFunc(CalculateBatchRoute, middleware.AuthMiddleware(&o.Ts, o.BatchCalculateHandler))
+	http.HandleFunc(BatchIdRoute, middleware.AuthMiddleware(&o.Ts, o.BatchIdHandler))
 	http.HandleFunc(ExpressionsRoute, middleware.AuthMiddleware(&o.Ts, o.ExpressionsHandler))
 	http.HandleFunc(ExpressionIdRoute, middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler))
+	http.HandleFunc(VariablesRoute, middleware.AuthMiddleware(&o.Ts, o.VariablesHandler))
+	http.HandleFunc(FunctionsRoute, middleware.AuthMiddleware(&o.Ts, o.FunctionsHandler))
+	http.HandleFunc(EventsRoute, middleware.AuthMiddleware(&o.Ts, o.EventsHandler))
+	http.HandleFunc(WebSocketRoute, middleware.AuthProtocolMiddleware(&o.Ts, o.WebSocketHandler))
+	http.HandleFunc(DeliveriesRoute, middleware.AuthMiddleware(&o.Ts, o.DeliveriesHandler))
+	http.HandleFunc(WebhookSecretRoute, middleware.AuthMiddleware(&o.Ts, o.WebhookSecretHandler))
+	http.HandleFunc(AgentsRoute, middleware.AdminMiddleware(&o.Ts, o.config.AdminUserIds, o.AgentsHandler))
+	http.HandleFunc(CacheRoute, middleware.AdminMiddleware(&o.Ts, o.config.AdminUserIds, o.CacheHandler))
 
 	// горутина для gRPC сервера
 	go func() {
@@ -153,7 +526,12 @@ func (o *Orchestrator) RunServer() error {
 		}
 	}()
 
-	o.addTasks()
+	if err := o.RestoreTasks(); err != nil {
+		log.Printf("Failed to restore tasks: %v", err)
+	}
+
+	go o.runLeaseReaper()
+	go o.runWebhookSender()
 
 	log.Printf("HTTP server running on: %s", o.config.Address)
 	return http.ListenAndServe(":"+o.config.Address, nil)
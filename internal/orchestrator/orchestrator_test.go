@@ -1,28 +1,52 @@
 package orchestrator_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MoodyShoo/go-http-calculator/internal/database"
 	"github.com/MoodyShoo/go-http-calculator/internal/middleware"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
 	"github.com/MoodyShoo/go-http-calculator/internal/orchestrator"
+	pb "github.com/MoodyShoo/go-http-calculator/internal/proto"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func registerAndLogin(t *testing.T, o *orchestrator.Orchestrator) string {
-	registerReq := httptest.NewRequest(http.MethodPost, orchestrator.RegisterRoute, bytes.NewBufferString(`{"login":"test","password":"1234"}`))
+	return registerAndLoginAs(t, o, "test")
+}
+
+func registerAndLoginAs(t *testing.T, o *orchestrator.Orchestrator, login string) string {
+	credentials := fmt.Sprintf(`{"login":%q,"password":"1234"}`, login)
+
+	registerReq := httptest.NewRequest(http.MethodPost, orchestrator.RegisterRoute, bytes.NewBufferString(credentials))
 	registerW := httptest.NewRecorder()
 	o.RegisterHandler(registerW, registerReq)
 	if registerW.Code != http.StatusOK {
 		t.Fatalf("register failed: status = %d, body = %s", registerW.Code, registerW.Body.String())
 	}
 
-	loginReq := httptest.NewRequest(http.MethodPost, orchestrator.LoginRoute, bytes.NewBufferString(`{"login":"test","password":"1234"}`))
+	loginReq := httptest.NewRequest(http.MethodPost, orchestrator.LoginRoute, bytes.NewBufferString(credentials))
 	loginW := httptest.NewRecorder()
 	o.LoginHandler(loginW, loginReq)
 	if loginW.Code != http.StatusOK {
@@ -40,6 +64,35 @@ func registerAndLogin(t *testing.T, o *orchestrator.Orchestrator) string {
 	return resp.Token
 }
 
+func submitExpression(t *testing.T, o *orchestrator.Orchestrator, token, body string) {
+	req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("submit %s: expected status %d, got %d", body, http.StatusAccepted, w.Code)
+	}
+}
+
+// startGRPC поднимает gRPC сервер оркестратора в памяти и возвращает клиента к нему.
+func startGRPC(t *testing.T, o *orchestrator.Orchestrator) pb.OrchestratorServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	pb.RegisterOrchestratorServiceServer(srv, o)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewOrchestratorServiceClient(conn)
+}
+
 func TestCalculateRoute(t *testing.T) {
 	cases := []struct {
 		name       string
@@ -61,9 +114,111 @@ func TestCalculateRoute(t *testing.T) {
 		},
 		{
 			name:       "Invalid expression",
-			statusCode: http.StatusInternalServerError,
+			statusCode: http.StatusUnprocessableEntity,
 			request:    `{"expression": "2+2-"}`,
-			want:       `{"error":"failed to create tasks: not enough operands for operator: -"}`,
+			want:       `{"error":"unexpected end of expression at position 5"}`,
+		},
+		{
+			name:       "Unexpected parenthesis",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "(2+3)*)"}`,
+			want:       `{"error":"unexpected ')' at position 7"}`,
+		},
+		{
+			name:       "Invalid character",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "2+3$4"}`,
+			want:       `{"error":"invalid character '$' at position 4"}`,
+		},
+		{
+			name:       "Unknown function",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "1+foo(2)"}`,
+			want:       `{"error":"unknown function 'foo' at position 3"}`,
+		},
+		{
+			name:       "Wrong number of function arguments",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "sqrt(1, 2)"}`,
+			want:       `{"error":"function sqrt expects 1 argument(s), got 2 at position 1"}`,
+		},
+		{
+			name:       "Function call",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "sqrt(16) + max(3, 7, 2) * log(100, 10)"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Unknown variable",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "2*rate"}`,
+			want:       `{"error":"unknown variable 'rate' at position 3"}`,
+		},
+		{
+			name:       "Built-in constants",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "2*pi*e"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Decimal mode",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "0.1 + 0.2", "mode": "decimal", "scale": 28}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Rational mode",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "1/3 + 1/6", "mode": "rational"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Complex mode",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "(1+2i)*(3-i)", "mode": "complex"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Conditional expression",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "if(2 > 1 && 3 != 0, 2 * 0.9, !2)"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Integer mode",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "20! / 7", "mode": "integer"}`,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Fraction in integer mode",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "2 * 0.5", "mode": "integer"}`,
+			want:       `{"error":"invalid integer: 0.5 at position 5"}`,
+		},
+		{
+			name:       "Imaginary number without complex mode",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "1 + 2i"}`,
+			want:       `{"error":"imaginary numbers require complex mode at position 5"}`,
+		},
+		{
+			name:       "Unknown mode",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "0.1 + 0.2", "mode": "exact"}`,
+			want:       `{"error":"unknown mode: exact"}`,
+		},
+		{
+			name:       "Scale without decimal mode",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "0.1 + 0.2", "scale": 2}`,
+			want:       `{"error":"scale is only supported in decimal mode"}`,
+		},
+		{
+			name:       "Scale out of range",
+			statusCode: http.StatusUnprocessableEntity,
+			request:    `{"expression": "0.1 + 0.2", "mode": "decimal", "scale": -1}`,
+			want:       `{"error":"scale must be between 0 and 1000"}`,
 		},
 		{
 			name:       "Empty expression",
@@ -89,6 +244,18 @@ func TestCalculateRoute(t *testing.T) {
 			request:    `{"expression": "2+2*3-4/2+6*5-10+8"}`,
 			want:       `{"id":1}`,
 		},
+		{
+			name:       "Common subexpression",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "(2+3)*(3+2)"}`,
+			want:       `{"id":1,"tasks_saved":1}`,
+		},
+		{
+			name:       "Algebraic identities",
+			statusCode: http.StatusAccepted,
+			request:    `{"expression": "1000*0 + sqrt(2)*1 - 0"}`,
+			want:       `{"id":1,"tasks_saved":4}`,
+		},
 	}
 
 	for _, tc := range cases {
@@ -116,6 +283,121 @@ func TestCalculateRoute(t *testing.T) {
 	}
 }
 
+func TestSimplifiedTasks(t *testing.T) {
+	type task struct {
+		operation string
+		args      []string
+		status    models.Status
+	}
+
+	cases := []struct {
+		name       string
+		expression string
+		cheap      string
+		wantTasks  []task
+		wantSaved  int
+	}{
+		{
+			name:       "Repeated subexpression is computed once",
+			expression: "sqrt(5) * sqrt(5) + sqrt(5)",
+			wantTasks: []task{
+				{"sqrt", []string{"5"}, models.StatusPending},
+				{"*", []string{"task1", "task1"}, models.StatusPending},
+				{"+", []string{"task2", "task1"}, models.StatusPending},
+			},
+			wantSaved: 2,
+		},
+		{
+			name:       "Cheap operations are folded",
+			expression: "(2+3) * sqrt(4-1)",
+			cheap:      "+, -",
+			wantTasks: []task{
+				{"sqrt", []string{"3"}, models.StatusPending},
+				{"*", []string{"5", "task1"}, models.StatusPending},
+			},
+			wantSaved: 2,
+		},
+		{
+			name:       "Failing operation is not folded",
+			expression: "1/0 + 1",
+			cheap:      "/",
+			wantTasks: []task{
+				{"/", []string{"1", "0"}, models.StatusPending},
+				{"+", []string{"task1", "1"}, models.StatusPending},
+			},
+		},
+		{
+			name:       "Unconditional task is reused in branch",
+			expression: "if(sqrt(2) > 1, sqrt(2), 0)",
+			wantTasks: []task{
+				{"sqrt", []string{"2"}, models.StatusPending},
+				{">", []string{"task1", "1"}, models.StatusPending},
+				{"if", []string{"task2", "task1", "0"}, models.StatusPending},
+			},
+			wantSaved: 1,
+		},
+		{
+			name:       "Branch task is not reused in other branch",
+			expression: "if(1 > sqrt(2), sqrt(3), sqrt(3) + 1)",
+			wantTasks: []task{
+				{"sqrt", []string{"2"}, models.StatusPending},
+				{">", []string{"1", "task1"}, models.StatusPending},
+				{"sqrt", []string{"3"}, models.StatusBlocked},
+				{"sqrt", []string{"3"}, models.StatusBlocked},
+				{"+", []string{"task4", "1"}, models.StatusBlocked},
+				{"if", []string{"task2", "task3", "task5"}, models.StatusPending},
+			},
+		},
+		{
+			name:       "Nested if with the same condition",
+			expression: "if(sqrt(2) > 1, if(sqrt(2) > 1, 1, 1/0), 2)",
+			wantTasks: []task{
+				{"sqrt", []string{"2"}, models.StatusPending},
+				{">", []string{"task1", "1"}, models.StatusPending},
+				{"if", []string{"task2", "1", "2"}, models.StatusPending},
+			},
+			wantSaved: 4,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(orchestrator.CheapOperationsEnv, tc.cheap)
+
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			token := registerAndLogin(t, o)
+
+			req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute,
+				bytes.NewBufferString(fmt.Sprintf(`{"expression": %q}`, tc.expression)))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+
+			want := `{"id":1}`
+			if tc.wantSaved > 0 {
+				want = fmt.Sprintf(`{"id":1,"tasks_saved":%d}`, tc.wantSaved)
+			}
+			if w.Code != http.StatusAccepted || w.Body.String() != want {
+				t.Fatalf("Expected %d %s, got %d %s", http.StatusAccepted, want, w.Code, w.Body.String())
+			}
+
+			tasks, err := db.TaskRepo.GetTasksByExpression(1)
+			if err != nil {
+				t.Fatalf("GetTasksByExpression() error = %v", err)
+			}
+
+			var got []task
+			for _, tk := range tasks {
+				got = append(got, task{tk.Operation, tk.Args, tk.Status})
+			}
+			if !reflect.DeepEqual(got, tc.wantTasks) {
+				t.Errorf("Expected tasks %v, got %v", tc.wantTasks, got)
+			}
+		})
+	}
+}
+
 func TestExpressionsHandler(t *testing.T) {
 	cases := []struct {
 		name        string
@@ -127,13 +409,14 @@ func TestExpressionsHandler(t *testing.T) {
 			name:        "One valid expression",
 			expressions: []string{`{"expression": "2+2"}`},
 			statusCode:  http.StatusOK,
-			want:        `{"expressions":[{"id":1,"expression":"2+2","status":"pending","result":0}]}`,
+			want:        `{"expressions":[{"id":1,"expression":"2+2","status":"pending","result":0,"critical_path_ms":1000}]}`,
 		},
 		{
 			name:        "Multiple valid expressions",
 			expressions: []string{`{"expression": "2+2"}`, `{"expression": "3*3"}`},
 			statusCode:  http.StatusOK,
-			want:        `{"expressions":[{"id":1,"expression":"2+2","status":"pending","result":0},{"id":2,"expression":"3*3","status":"pending","result":0}]}`,
+			want: `{"expressions":[{"id":1,"expression":"2+2","status":"pending","result":0,"critical_path_ms":1000},` +
+				`{"id":2,"expression":"3*3","status":"pending","result":0,"critical_path_ms":1000}]}`,
 		},
 		{
 			name:        "Empty list",
@@ -203,7 +486,43 @@ func TestExpressionIdHandler(t *testing.T) {
 			expression: `{"expression": "2+2"}`,
 			id:         1,
 			statusCode: http.StatusOK,
-			want:       `{"id":1,"expression":"2+2","status":"pending","result":0}`,
+			want: `{"id":1,"expression":"2+2","status":"pending","result":0,"critical_path_ms":1000,` +
+				`"progress":{"total":1,"pending":1,"blocked":0,"computing":0,"done":0,"skipped":0,"remaining_ms":1000}}`,
+		},
+		{
+			name:       "Negative number without tasks",
+			expression: `{"expression": "-5"}`,
+			id:         1,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"-5","status":"done","result":-5}`,
+		},
+		{
+			name:       "Decimal number without tasks",
+			expression: `{"expression": "0.10", "mode": "decimal", "scale": 5}`,
+			id:         1,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"0.10","status":"done","mode":"decimal","scale":5,"result":0.1,"value":"0.1"}`,
+		},
+		{
+			name:       "Rational number without tasks",
+			expression: `{"expression": "0.5", "mode": "rational"}`,
+			id:         1,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"0.5","status":"done","mode":"rational","result":0.5,"value":"1/2"}`,
+		},
+		{
+			name:       "Large integer without tasks",
+			expression: `{"expression": "18446744073709551617", "mode": "integer"}`,
+			id:         1,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"18446744073709551617","status":"done","mode":"integer","result":18446744073709551616,"value":"18446744073709551617"}`,
+		},
+		{
+			name:       "Complex number without tasks",
+			expression: `{"expression": "-2i", "mode": "complex"}`,
+			id:         1,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"-2i","status":"done","mode":"complex","result":{"real":0,"imag":-2},"value":"-2i"}`,
 		},
 		{
 			name:       "Invalid expression ID",
@@ -267,3 +586,2066 @@ func TestExpressionIdHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestTasksSurviveRestart(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	submitExpression(t, o, token, `{"expression": "2+3*4"}`)
+
+	first, err := o.FetchTask(context.Background(), &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+
+	if _, err := o.SendResult(context.Background(), &pb.TaskResult{Id: first.Task.Id, Result: 12, Lease: first.Task.Lease}); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	// Новый оркестратор поверх той же базы продолжает с того же места
+	restarted := orchestrator.New(db)
+
+	second, err := restarted.FetchTask(context.Background(), &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() after restart error = %v", err)
+	}
+
+	if second.Task.Id == first.Task.Id {
+		t.Errorf("Expected a new task id, got %d again", second.Task.Id)
+	}
+
+	if !reflect.DeepEqual(second.Task.Args, []string{"2", "12"}) || second.Task.Operation != "+" {
+		t.Errorf("Unexpected task after restart: %v", second.Task)
+	}
+
+	if _, err := restarted.FetchTask(context.Background(), &pb.TaskRequest{}); err == nil {
+		t.Errorf("Expected no tasks available after restart")
+	}
+}
+
+func TestTaskArgsMigration(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	// Задачи в базах, созданных до появления функций, хранили аргументы в arg1 и arg2
+	old, err := sql.Open("sqlite", "calculator.db")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = old.Exec(`
+	CREATE TABLE tasks(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		expression_id INTEGER NOT NULL,
+		arg1 TEXT NOT NULL,
+		arg2 TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		operation_time INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		result REAL NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		leased_at INTEGER,
+		lease INTEGER NOT NULL DEFAULT 0,
+		lease_expires_at INTEGER,
+		agent_id TEXT NOT NULL DEFAULT ''
+	);
+	INSERT INTO tasks (expression_id, arg1, arg2, operation, operation_time, status) VALUES
+		(1, '3', '4', '*', 1000, 'pending'),
+		(1, '2', 'task1', '+', 1000, 'pending'),
+		(1, 'task2', '', 'neg', 1000, 'pending');`)
+	old.Close()
+	if err != nil {
+		t.Fatalf("failed to create old schema: %v", err)
+	}
+
+	db, err := database.NewDatabase()
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+
+	tasks, err := db.TaskRepo.GetTasksByExpression(1)
+	if err != nil {
+		t.Fatalf("GetTasksByExpression() error = %v", err)
+	}
+
+	want := [][]string{{"3", "4"}, {"2", "task1"}, {"task2"}}
+	if len(tasks) != len(want) {
+		t.Fatalf("Expected %d tasks, got %+v", len(want), tasks)
+	}
+	for i, task := range tasks {
+		if !reflect.DeepEqual(task.Args, want[i]) {
+			t.Errorf("Expected args %v of task %d, got %v", want[i], task.Id, task.Args)
+		}
+	}
+
+	// Старые колонки удалены, поэтому новые задачи сохраняются
+	if _, err := db.TaskRepo.InsertTask(models.Task{ExpressionId: 2, Operation: "+", Args: []string{"1", "1"}, Status: models.StatusPending}); err != nil {
+		t.Errorf("InsertTask() after migration error = %v", err)
+	}
+}
+
+func TestRestoreTasks(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	registerAndLogin(t, o)
+
+	cases := []struct {
+		expression string
+		wantStatus models.Status
+		wantError  string
+		wantTasks  int
+	}{
+		{expression: "2+3", wantStatus: models.StatusPending, wantTasks: 1},
+		{expression: "-5", wantStatus: models.StatusDone},
+		{expression: "2+", wantStatus: models.StatusError, wantError: "unexpected end of expression at position 3"},
+		{expression: "7*2", wantStatus: models.StatusPending, wantTasks: 1},
+	}
+
+	// Выражения сохранены, но сервер остановился до создания их задач
+	for _, tc := range cases {
+		exp := models.Expression{Expr: tc.expression, Status: models.StatusPending, UserID: 1}
+		if _, err := db.ExpressionRepo.InsertExpression(exp); err != nil {
+			t.Fatalf("InsertExpression() error = %v", err)
+		}
+	}
+
+	if err := o.RestoreTasks(); err != nil {
+		t.Fatalf("RestoreTasks() error = %v", err)
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.expression, func(t *testing.T) {
+			id := int64(i + 1)
+			exp, err := db.ExpressionRepo.GetExpressionByID(id)
+			if err != nil {
+				t.Fatalf("GetExpressionByID() error = %v", err)
+			}
+			if exp.Status != tc.wantStatus || exp.Error != tc.wantError {
+				t.Errorf("Expected status %s with error %q, got %s with error %q", tc.wantStatus, tc.wantError, exp.Status, exp.Error)
+			}
+
+			tasks, err := db.TaskRepo.GetTasksByExpression(id)
+			if err != nil || len(tasks) != tc.wantTasks {
+				t.Errorf("Expected %d tasks, got %v (%v)", tc.wantTasks, tasks, err)
+			}
+		})
+	}
+}
+
+func TestExpressionSavedAtomically(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	registerAndLogin(t, orchestrator.New(db))
+
+	// Ошибка посреди сохранения откатывает и выражение, и уже сохранённые задачи
+	err := db.Transaction(func(tx *database.Database) error {
+		id, err := tx.ExpressionRepo.InsertExpression(models.Expression{Expr: "2+3", Status: models.StatusPending, UserID: 1})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.TaskRepo.InsertTask(models.Task{ExpressionId: id, Operation: "+", Args: []string{"2", "3"}, Status: models.StatusPending}); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to save task dependency")
+	})
+	if err == nil {
+		t.Fatalf("Expected transaction error")
+	}
+
+	if _, err := db.ExpressionRepo.GetExpressionByID(1); err == nil {
+		t.Errorf("Expected expression to be rolled back")
+	}
+	if tasks, err := db.TaskRepo.GetTasksByExpression(1); err != nil || len(tasks) != 0 {
+		t.Errorf("Expected tasks to be rolled back, got %v (%v)", tasks, err)
+	}
+}
+
+func TestExpiredLeaseIsRequeued(t *testing.T) {
+	t.Setenv(orchestrator.TimeAdditionMsEnv, "0")
+	t.Setenv(orchestrator.TaskLeaseGraceMsEnv, "0")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	submitExpression(t, o, token, `{"expression": "2+2"}`)
+
+	stale, err := o.FetchTask(context.Background(), &pb.TaskRequest{AgentId: "agent-1"})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := o.SendResult(context.Background(), &pb.TaskResult{Id: stale.Task.Id, Result: 4, Lease: stale.Task.Lease}); err == nil {
+		t.Fatalf("Expected result with expired lease to be rejected")
+	}
+
+	released, err := o.ReleaseExpiredLeases()
+	if err != nil {
+		t.Fatalf("ReleaseExpiredLeases() error = %v", err)
+	}
+	if released != 1 {
+		t.Fatalf("Expected 1 released task, got %d", released)
+	}
+
+	// Вернувшаяся в очередь задача больше не числится за агентом
+	if task, err := db.TaskRepo.GetTaskByID(stale.Task.Id); err != nil || task.AgentId != "" {
+		t.Errorf("Expected released task without agent, got %+v (%v)", task, err)
+	}
+
+	fresh, err := o.FetchTask(context.Background(), &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() after release error = %v", err)
+	}
+
+	if fresh.Task.Id != stale.Task.Id || fresh.Task.Lease == stale.Task.Lease {
+		t.Errorf("Expected task %d with a new lease, got task %d with lease %d", stale.Task.Id, fresh.Task.Id, fresh.Task.Lease)
+	}
+}
+
+func TestDispatchPushesReadyTasks(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	client := startGRPC(t, o)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Dispatch(ctx)
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if err := stream.Send(&pb.DispatchRequest{FreeSlots: 1}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	submitExpression(t, o, token, `{"expression": "2+3*4"}`)
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if first.GetTask().Operation != "*" {
+		t.Fatalf("Expected multiplication first, got %v", first)
+	}
+
+	if _, err := client.SendResult(ctx, &pb.TaskResult{Id: first.GetTask().Id, Result: 12, Lease: first.GetTask().Lease}); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+	if err := stream.Send(&pb.DispatchRequest{FreeSlots: 1}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if second.GetTask().Operation != "+" || second.GetTask().Args[1] != "12" {
+		t.Errorf("Expected dependent addition to be pushed, got %v", second)
+	}
+}
+
+func TestFunctionCallTasks(t *testing.T) {
+	t.Setenv("TIME_MAX_MS", "7")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "max(1, 2+3, 4)"}`)
+
+	sum, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if sum.Task.Operation != "+" || !reflect.DeepEqual(sum.Task.Args, []string{"2", "3"}) {
+		t.Fatalf("Expected addition inside the call first, got %v", sum.Task)
+	}
+
+	if _, err := o.SendResult(ctx, &pb.TaskResult{Id: sum.Task.Id, Result: 5, Lease: sum.Task.Lease}); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	call, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if call.Task.Operation != "max" || !reflect.DeepEqual(call.Task.Args, []string{"1", "5", "4"}) {
+		t.Fatalf("Expected max with three arguments, got %v", call.Task)
+	}
+	if call.Task.OperationTime != 7 {
+		t.Errorf("Expected operation time from TIME_MAX_MS, got %d", call.Task.OperationTime)
+	}
+
+	if _, err := o.SendResult(ctx, &pb.TaskResult{Id: call.Task.Id, Result: 5, Lease: call.Task.Lease}); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	expression, err := db.ExpressionRepo.GetExpressionByID(call.Task.ExpressionId)
+	if err != nil {
+		t.Fatalf("GetExpressionByID() error = %v", err)
+	}
+	if expression.Status != models.StatusDone || expression.Result != 5 {
+		t.Errorf("Expected done expression with result 5, got %+v", expression)
+	}
+}
+
+// fetchAndComplete берёт следующую готовую задачу, проверяет её операцию и отправляет результат value.
+func fetchAndComplete(t *testing.T, o *orchestrator.Orchestrator, operation, value string) *pb.Task {
+	t.Helper()
+	ctx := context.Background()
+
+	resp, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v, want %s task", err, operation)
+	}
+	if resp.Task.Operation != operation {
+		t.Fatalf("Expected %s task, got %v", operation, resp.Task)
+	}
+
+	result := &pb.TaskResult{Id: resp.Task.Id, Value: value, Lease: resp.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	return resp.Task
+}
+
+// getExpression возвращает выражение из ответа GET /api/v1/expressions/{id}.
+func getExpression(t *testing.T, o *orchestrator.Orchestrator, token string, id int64) models.Expression {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s%d", orchestrator.ExpressionIdRoute, id), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ExpressionIdHandler: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var expression models.Expression
+	if err := json.Unmarshal(w.Body.Bytes(), &expression); err != nil {
+		t.Fatalf("Failed to unmarshal expression: %v", err)
+	}
+
+	return expression
+}
+
+func TestTaskGraph(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "10")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "20")
+	t.Setenv("TIME_SQRT_MS", "50")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "(1+2)*(3+4) + sqrt(9)"}`)
+
+	// Самый долгий путь: sqrt (50) и сложение (10)
+	expression := getExpression(t, o, token, 1)
+	if expression.CriticalPathMs != 60 {
+		t.Errorf("Expected critical path 60 ms, got %d", expression.CriticalPathMs)
+	}
+	want := models.Progress{Total: 5, Pending: 5, RemainingMs: 60}
+	if expression.Progress == nil || *expression.Progress != want {
+		t.Errorf("Expected progress %+v, got %+v", want, expression.Progress)
+	}
+
+	// Готовы сразу три задачи, умножение и итоговое сложение ждут их результатов
+	var running []*pb.Task
+	for _, operation := range []string{"+", "+", "sqrt"} {
+		resp, err := o.FetchTask(ctx, &pb.TaskRequest{})
+		if err != nil {
+			t.Fatalf("FetchTask() error = %v, want %s task", err, operation)
+		}
+		if resp.Task.Operation != operation {
+			t.Fatalf("Expected %s task, got %v", operation, resp.Task)
+		}
+		running = append(running, resp.Task)
+	}
+
+	if resp, err := o.FetchTask(ctx, &pb.TaskRequest{}); err == nil {
+		t.Fatalf("Expected no ready tasks, got %v", resp.Task)
+	}
+
+	for i, value := range []string{"3", "7", "3"} {
+		result := &pb.TaskResult{Id: running[i].Id, Value: value, Lease: running[i].Lease}
+		if _, err := o.SendResult(ctx, result); err != nil {
+			t.Fatalf("SendResult() error = %v", err)
+		}
+	}
+
+	mul := fetchAndComplete(t, o, "*", "21")
+	if !reflect.DeepEqual(mul.Args, []string{"3", "7"}) {
+		t.Errorf("Expected resolved arguments [3 7], got %v", mul.Args)
+	}
+
+	expression = getExpression(t, o, token, 1)
+	want = models.Progress{Total: 5, Pending: 1, Done: 4, RemainingMs: 10}
+	if expression.Progress == nil || *expression.Progress != want {
+		t.Errorf("Expected progress %+v, got %+v", want, expression.Progress)
+	}
+
+	fetchAndComplete(t, o, "+", "24")
+
+	expression = getExpression(t, o, token, 1)
+	want = models.Progress{Total: 5, Done: 5}
+	if expression.Status != models.StatusDone || expression.Progress == nil || *expression.Progress != want {
+		t.Errorf("Expected done expression with progress %+v, got %+v", want, expression)
+	}
+}
+
+func TestExpressionTaskTree(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "10 / (3 - 3)"}`)
+
+	fetchAndComplete(t, o, "-", "0")
+
+	div, err := o.FetchTask(ctx, &pb.TaskRequest{AgentId: "agent-1"})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	result := &pb.TaskResult{Id: div.Task.Id, Error: "division by zero", Lease: div.Task.Lease, AgentId: "agent-1"}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	if expression := getExpression(t, o, token, 1); expression.Tasks != nil {
+		t.Errorf("Expected no tasks without include, got %+v", expression.Tasks)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, orchestrator.ExpressionIdRoute+"1?include=tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+
+	var expression models.Expression
+	if err := json.Unmarshal(w.Body.Bytes(), &expression); err != nil {
+		t.Fatalf("Failed to unmarshal expression: %v", err)
+	}
+
+	if len(expression.Tasks) != 1 {
+		t.Fatalf("Expected one root task, got %+v", expression.Tasks)
+	}
+
+	root := expression.Tasks[0]
+	if root.Operation != "/" || root.Status != models.StatusError || root.Error != "division by zero" || root.AgentId != "agent-1" {
+		t.Errorf("Expected failed division on agent-1, got %+v", root)
+	}
+	if root.StartedAt == nil || root.FinishedAt == nil || root.FinishedAt.Before(*root.StartedAt) {
+		t.Errorf("Expected start and finish times, got %v and %v", root.StartedAt, root.FinishedAt)
+	}
+	if len(root.Operands) != 2 || root.Operands[0].Value != "10" || root.Operands[1].Task == nil {
+		t.Fatalf("Expected operands 10 and subtraction task, got %+v", root.Operands)
+	}
+
+	sub := root.Operands[1].Task
+	if sub.Operation != "-" || sub.Status != models.StatusDone || sub.Value != "0" {
+		t.Errorf("Expected done subtraction with value 0, got %+v", sub)
+	}
+	if len(sub.Operands) != 2 || sub.Operands[0].Value != "3" || sub.Operands[1].Value != "3" {
+		t.Errorf("Expected operands 3 and 3, got %+v", sub.Operands)
+	}
+}
+
+// openEvents открывает поток SSE по адресу url и возвращает функцию чтения следующего события.
+// После конца потока функция возвращает ok = false.
+func openEvents(t *testing.T, url, token string) func() (event models.Event, ok bool) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s: status = %d, content type = %q", url, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	return func() (models.Event, bool) {
+		var event models.Event
+		for scanner.Scan() {
+			line := scanner.Text()
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &event); err != nil {
+					t.Fatalf("Failed to unmarshal event %s: %v", data, err)
+				}
+			}
+			if line == "" && event.Type != "" {
+				return event, true
+			}
+		}
+		return event, false
+	}
+}
+
+// describeEvent возвращает событие в виде "expression computing" или "task * done".
+func describeEvent(event models.Event) string {
+	if event.Task != nil {
+		return fmt.Sprintf("%s %s %s", event.Type, event.Task.Operation, event.Task.Status)
+	}
+	return fmt.Sprintf("%s %s", event.Type, event.Expression.Status)
+}
+
+func TestExpressionEvents(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(orchestrator.ExpressionIdRoute, middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler))
+	mux.HandleFunc(orchestrator.EventsRoute, middleware.AuthMiddleware(&o.Ts, o.EventsHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	submitExpression(t, o, token, `{"expression": "2+3*4"}`)
+
+	expressionEvents := openEvents(t, srv.URL+orchestrator.ExpressionIdRoute+"1"+orchestrator.EventsSuffix, token)
+	userEvents := openEvents(t, srv.URL+orchestrator.EventsRoute, token)
+
+	// Первое событие потока выражения - его текущее состояние
+	if event, ok := expressionEvents(); !ok || describeEvent(event) != "expression pending" || event.ExpressionId != 1 {
+		t.Fatalf("Expected pending expression first, got %+v", event)
+	}
+
+	fetchAndComplete(t, o, "*", "12")
+	fetchAndComplete(t, o, "+", "14")
+
+	want := []string{"expression computing", "task * done", "task + done", "expression done"}
+	for name, next := range map[string]func() (models.Event, bool){"expression": expressionEvents, "user": userEvents} {
+		for _, w := range want {
+			event, ok := next()
+			if !ok || describeEvent(event) != w {
+				t.Fatalf("%s stream: expected %q, got %+v", name, w, event)
+			}
+		}
+	}
+
+	if event, ok := expressionEvents(); ok {
+		t.Errorf("Expected expression stream to end after done, got %+v", event)
+	}
+
+	// Поток пользователя получает события и следующих выражений
+	submitExpression(t, o, token, `{"expression": "-5"}`)
+	if event, ok := userEvents(); !ok || describeEvent(event) != "expression done" || event.Expression.Result != -5 {
+		t.Errorf("Expected done expression -5, got %+v", event)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	t.Setenv(orchestrator.WSAllowedOriginsEnv, "http://app.example.com")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	srv := httptest.NewServer(middleware.AuthProtocolMiddleware(&o.Ts, o.WebSocketHandler))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + orchestrator.WebSocketRoute
+
+	// dial подключается со страницы origin и передаёт токен в Sec-WebSocket-Protocol
+	dial := func(url, token, origin string) (*websocket.Conn, error) {
+		config, err := websocket.NewConfig(url, origin)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			config.Protocol = []string{middleware.BearerProtocol, token}
+		}
+		return websocket.DialConfig(config)
+	}
+
+	handshakes := []struct {
+		name    string
+		url     string
+		token   string
+		origin  string
+		wantErr bool
+	}{
+		{name: "Without token", url: url, origin: srv.URL, wantErr: true},
+		{name: "Invalid token", url: url, token: "invalid", origin: srv.URL, wantErr: true},
+		{name: "Token in query", url: url + "?token=" + token, origin: srv.URL, wantErr: true},
+		{name: "Foreign origin", url: url, token: token, origin: "http://evil.example.com", wantErr: true},
+		{name: "Allowed origin", url: url, token: token, origin: "http://app.example.com"},
+	}
+
+	for _, tc := range handshakes {
+		t.Run(tc.name, func(t *testing.T) {
+			ws, err := dial(tc.url, tc.token, tc.origin)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if err == nil {
+				ws.Close()
+			}
+		})
+	}
+
+	ws, err := dial(url, token, srv.URL)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	exchange := func(request string) models.WSResponse {
+		t.Helper()
+
+		if request != "" {
+			if err := websocket.Message.Send(ws, request); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+		}
+
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var resp models.WSResponse
+		if err := websocket.JSON.Receive(ws, &resp); err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		return resp
+	}
+
+	cases := []struct {
+		name    string
+		request string
+		want    models.WSResponse
+	}{
+		{
+			name:    "Invalid JSON",
+			request: `{"type":`,
+			want:    models.WSResponse{Type: models.WSError, Error: "invalid message"},
+		},
+		{
+			name:    "Unknown type",
+			request: `{"type": "subscribe", "request_id": "a"}`,
+			want:    models.WSResponse{Type: models.WSError, RequestId: "a", Error: `unknown message type "subscribe"`},
+		},
+		{
+			name:    "Syntax error",
+			request: `{"type": "calculate", "request_id": "b", "expression": "2+"}`,
+			want:    models.WSResponse{Type: models.WSError, RequestId: "b", Error: "unexpected end of expression at position 3"},
+		},
+		{
+			name:    "Accepted",
+			request: `{"type": "calculate", "request_id": "c", "expression": "2+3"}`,
+			want:    models.WSResponse{Type: models.WSAccepted, RequestId: "c", Id: 1},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := exchange(tc.request); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+
+	fetchAndComplete(t, o, "+", "5")
+
+	result := exchange("")
+	if result.Type != models.WSResult || result.Id != 1 || result.Expression.Status != models.StatusDone || result.Expression.Result != 5 {
+		t.Errorf("Expected result 5 of expression 1, got %+v", result)
+	}
+
+	// Выражение без задач завершается сразу, но результат приходит после ответа о приёме
+	if accepted := exchange(`{"type": "calculate", "request_id": "d", "expression": "-5"}`); accepted.Type != models.WSAccepted || accepted.Id != 2 {
+		t.Fatalf("Expected expression 2 to be accepted, got %+v", accepted)
+	}
+	if result := exchange(""); result.Type != models.WSResult || result.Id != 2 || result.Expression.Result != -5 {
+		t.Errorf("Expected result -5 of expression 2, got %+v", result)
+	}
+
+	// Событий больше, чем помещается в буфер подписки: сессия получает только завершения своих выражений,
+	// поэтому выражения пакета того же пользователя её не переполняют
+	const watched = 2 * 64
+	for i := range watched {
+		request := fmt.Sprintf(`{"type": "calculate", "expression": "%d+1"}`, i+10)
+		if accepted := exchange(request); accepted.Type != models.WSAccepted || accepted.Id != int64(i+3) {
+			t.Fatalf("Expected expression %d to be accepted, got %+v", i+3, accepted)
+		}
+	}
+
+	var batch strings.Builder
+	for i := range 4 * 64 {
+		if i > 0 {
+			batch.WriteString(", ")
+		}
+		fmt.Fprintf(&batch, `{"expression": "-%d"}`, i+1)
+	}
+	req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateBatchRoute, strings.NewReader(`{"expressions": [`+batch.String()+`]}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.BatchCalculateHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected batch status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	ctx := context.Background()
+	for range watched {
+		task, err := o.FetchTask(ctx, &pb.TaskRequest{})
+		if err != nil {
+			t.Fatalf("FetchTask() error = %v", err)
+		}
+		arg, _ := strconv.Atoi(task.Task.Args[0])
+		result := &pb.TaskResult{Id: task.Task.Id, Value: strconv.Itoa(arg + 1), Lease: task.Task.Lease}
+		if _, err := o.SendResult(ctx, result); err != nil {
+			t.Fatalf("SendResult() error = %v", err)
+		}
+	}
+
+	got := make(map[int64]bool)
+	for range watched {
+		result := exchange("")
+		if result.Type != models.WSResult || result.Expression.Result != float64(result.Id+8) {
+			t.Fatalf("Expected result of a watched expression, got %+v", result)
+		}
+		got[result.Id] = true
+	}
+	if len(got) != watched {
+		t.Errorf("Expected %d different results, got %d", watched, len(got))
+	}
+}
+
+func TestCalculateWait(t *testing.T) {
+	cases := []struct {
+		name       string
+		query      string
+		expression string
+		complete   bool
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "Finished without tasks",
+			query:      "?wait=1s",
+			expression: `{"expression": "-5"}`,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"-5","status":"done","result":-5}`,
+		},
+		{
+			name:       "Finished by agent",
+			query:      "?wait=5s",
+			expression: `{"expression": "2+3"}`,
+			complete:   true,
+			statusCode: http.StatusOK,
+			want:       `{"id":1,"expression":"2+3","status":"done","result":5,"critical_path_ms":1000}`,
+		},
+		{
+			name:       "Timeout",
+			query:      "?wait=50ms",
+			expression: `{"expression": "2+3"}`,
+			statusCode: http.StatusAccepted,
+			want:       `{"id":1}`,
+		},
+		{
+			name:       "Invalid duration",
+			query:      "?wait=soon",
+			expression: `{"expression": "2+3"}`,
+			statusCode: http.StatusBadRequest,
+			want:       `{"error":"invalid wait duration \"soon\""}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			token := registerAndLogin(t, o)
+
+			req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute+tc.query, bytes.NewBufferString(tc.expression))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+			}()
+
+			if tc.complete {
+				// Обработчик не держит блокировку, пока ждёт, поэтому агент может получить задачу
+				var task *pb.Task
+				for deadline := time.Now().Add(5 * time.Second); task == nil && time.Now().Before(deadline); {
+					if resp, err := o.FetchTask(context.Background(), &pb.TaskRequest{}); err == nil {
+						task = resp.Task
+					} else {
+						time.Sleep(10 * time.Millisecond)
+					}
+				}
+				if task == nil {
+					t.Fatalf("Expected task to be available while the handler waits")
+				}
+
+				result := &pb.TaskResult{Id: task.Id, Value: "5", Lease: task.Lease}
+				if _, err := o.SendResult(context.Background(), result); err != nil {
+					t.Fatalf("SendResult() error = %v", err)
+				}
+			}
+
+			<-done
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d", tc.statusCode, w.Code)
+			}
+			if w.Body.String() != tc.want {
+				t.Errorf("Expected body %s, got %s", tc.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// authGet выполняет GET-запрос пользователя к handler и возвращает тело ответа.
+func authGet(t *testing.T, o *orchestrator.Orchestrator, token, route string, handler http.HandlerFunc) []byte {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, route, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, handler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, body = %s", route, w.Code, w.Body.String())
+	}
+
+	return w.Body.Bytes()
+}
+
+func TestWebhooks(t *testing.T) {
+	cases := []struct {
+		name         string
+		callback     string
+		expression   string
+		result       *pb.TaskResult
+		failures     int
+		maxAttempts  string
+		denyPrivate  bool
+		statusCode   int
+		wantStatus   models.DeliveryStatus
+		wantAttempts int
+		wantPayload  string
+	}{
+		{
+			name:         "Delivered after retry",
+			expression:   "2+3",
+			result:       &pb.TaskResult{Value: "5"},
+			failures:     1,
+			statusCode:   http.StatusAccepted,
+			wantStatus:   models.DeliveryDelivered,
+			wantAttempts: 2,
+			wantPayload:  `{"id":1,"expression":"2+3","status":"done","result":5,"critical_path_ms":1000,"callback_url":"%s"}`,
+		},
+		{
+			name:         "Expression error",
+			expression:   "1/0",
+			result:       &pb.TaskResult{Error: "division by zero"},
+			statusCode:   http.StatusAccepted,
+			wantStatus:   models.DeliveryDelivered,
+			wantAttempts: 1,
+			wantPayload:  `{"id":1,"expression":"1/0","status":"error","result":0,"error":"division by zero","critical_path_ms":1000,"callback_url":"%s"}`,
+		},
+		{
+			name:         "Finished without tasks",
+			expression:   "-5",
+			statusCode:   http.StatusAccepted,
+			wantStatus:   models.DeliveryDelivered,
+			wantAttempts: 1,
+			wantPayload:  `{"id":1,"expression":"-5","status":"done","result":-5,"callback_url":"%s"}`,
+		},
+		{
+			name:         "Failed after max attempts",
+			expression:   "2+3",
+			result:       &pb.TaskResult{Value: "5"},
+			failures:     10,
+			maxAttempts:  "3",
+			statusCode:   http.StatusAccepted,
+			wantStatus:   models.DeliveryFailed,
+			wantAttempts: 3,
+		},
+		{
+			name:       "Invalid callback_url",
+			callback:   "ftp://example.com/hook",
+			expression: "2+3",
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "Loopback callback_url",
+			expression:  "2+3",
+			denyPrivate: true,
+			statusCode:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "Cloud metadata callback_url",
+			callback:    "http://169.254.169.254/latest/meta-data",
+			expression:  "2+3",
+			denyPrivate: true,
+			statusCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(orchestrator.WebhookRetryBaseMsEnv, "0")
+			// Получатель слушает 127.0.0.1, поэтому внутренние адреса разрешены, кроме проверки самого запрета
+			t.Setenv(orchestrator.WebhookAllowPrivateEnv, strconv.FormatBool(!tc.denyPrivate))
+			if tc.maxAttempts != "" {
+				t.Setenv(orchestrator.WebhookMaxAttemptsEnv, tc.maxAttempts)
+			}
+
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			token := registerAndLogin(t, o)
+			ctx := context.Background()
+
+			var secret models.WebhookSecretResponse
+			if err := json.Unmarshal(authGet(t, o, token, orchestrator.WebhookSecretRoute, o.WebhookSecretHandler), &secret); err != nil {
+				t.Fatalf("failed to parse secret: %v", err)
+			}
+
+			calls := 0
+			var payload string
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				body, _ := io.ReadAll(r.Body)
+
+				mac := hmac.New(sha256.New, []byte(secret.Secret))
+				mac.Write(body)
+				if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(orchestrator.SignatureHeader) != want {
+					t.Errorf("Expected signature %s, got %s", want, r.Header.Get(orchestrator.SignatureHeader))
+				}
+
+				if calls <= tc.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				payload = string(body)
+			}))
+			defer receiver.Close()
+
+			callback := tc.callback
+			if callback == "" {
+				callback = receiver.URL + "/hook"
+			}
+
+			body := fmt.Sprintf(`{"expression": %q, "callback_url": %q}`, tc.expression, callback)
+			req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute, bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+			if w.Code != tc.statusCode {
+				t.Fatalf("Expected status %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+			if tc.statusCode != http.StatusAccepted {
+				return
+			}
+
+			if tc.result != nil {
+				task, err := o.FetchTask(ctx, &pb.TaskRequest{})
+				if err != nil {
+					t.Fatalf("FetchTask() error = %v", err)
+				}
+
+				tc.result.Id, tc.result.Lease = task.Task.Id, task.Task.Lease
+				if _, err := o.SendResult(ctx, tc.result); err != nil {
+					t.Fatalf("SendResult() error = %v", err)
+				}
+			}
+
+			// Повторные попытки наступают сразу, так как WEBHOOK_RETRY_BASE_MS = 0
+			for i := 0; i < 5; i++ {
+				if _, err := o.SendWebhooks(); err != nil {
+					t.Fatalf("SendWebhooks() error = %v", err)
+				}
+			}
+
+			var resp models.WebhookDeliveriesResponse
+			if err := json.Unmarshal(authGet(t, o, token, orchestrator.DeliveriesRoute, o.DeliveriesHandler), &resp); err != nil {
+				t.Fatalf("failed to parse deliveries: %v", err)
+			}
+			if len(resp.Deliveries) != 1 {
+				t.Fatalf("Expected 1 delivery, got %+v", resp.Deliveries)
+			}
+
+			delivery := resp.Deliveries[0]
+			if delivery.Status != tc.wantStatus || delivery.Attempts != tc.wantAttempts || delivery.URL != callback {
+				t.Errorf("Expected %s delivery to %s after %d attempts, got %+v", tc.wantStatus, callback, tc.wantAttempts, delivery)
+			}
+			if calls != tc.wantAttempts {
+				t.Errorf("Expected %d calls, got %d", tc.wantAttempts, calls)
+			}
+
+			if tc.wantPayload != "" {
+				if want := fmt.Sprintf(tc.wantPayload, callback); payload != want {
+					t.Errorf("Expected payload %s, got %s", want, payload)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchCalculate(t *testing.T) {
+	cases := []struct {
+		name       string
+		body       string
+		results    []*pb.TaskResult
+		statusCode int
+		want       string
+		wantBatch  string
+	}{
+		{
+			name:       "Valid and invalid expressions",
+			body:       `{"expressions": [{"expression": "2+3"}, {"expression": ""}, {"expression": "2+"}, {"expression": "-5"}]}`,
+			statusCode: http.StatusAccepted,
+			want:       `{"id":1,"items":[{"id":1},{"error":"unprocessable entity"},{"error":"unexpected end of expression at position 3"},{"id":2}]}`,
+			wantBatch:  `"status":"computing","total":2,"pending":1,"computing":0,"done":1,"error":0,"cancelled":0`,
+		},
+		{
+			name:       "All done",
+			body:       `{"expressions": [{"expression": "2+3"}, {"expression": "1+1", "mode": "integer"}]}`,
+			results:    []*pb.TaskResult{{Value: "5"}, {Value: "2"}},
+			statusCode: http.StatusAccepted,
+			want:       `{"id":1,"items":[{"id":1},{"id":2}]}`,
+			wantBatch:  `"status":"done","total":2,"pending":0,"computing":0,"done":2,"error":0,"cancelled":0`,
+		},
+		{
+			name:       "One failed",
+			body:       `{"expressions": [{"expression": "1/0"}, {"expression": "7"}]}`,
+			results:    []*pb.TaskResult{{Error: "division by zero"}},
+			statusCode: http.StatusAccepted,
+			want:       `{"id":1,"items":[{"id":1},{"id":2}]}`,
+			wantBatch:  `"status":"error","total":2,"pending":0,"computing":0,"done":1,"error":1,"cancelled":0`,
+		},
+		{
+			name:       "All invalid",
+			body:       `{"expressions": [{"expression": "2+"}, {"expression": "1", "mode": "octal"}]}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"items":[{"error":"unexpected end of expression at position 3"},{"error":"unknown mode: octal"}]}`,
+		},
+		{
+			name: "Callback URLs",
+			body: `{"expressions": [{"expression": "-5", "callback_url": "http://127.0.0.1/hook"}, {"expression": "-6", "callback_url": "ftp://example.com"}, ` +
+				`{"expression": "-7", "callback_url": "http://127.0.0.1/hook"}, {"expression": "-8"}]}`,
+			statusCode: http.StatusAccepted,
+			want: `{"id":1,"items":[{"error":"invalid callback_url: internal address 127.0.0.1 is not allowed"},` +
+				`{"error":"invalid callback_url: only absolute http and https URLs are allowed"},` +
+				`{"error":"invalid callback_url: internal address 127.0.0.1 is not allowed"},{"id":1}]}`,
+			wantBatch: `"status":"done","total":1,"pending":0,"computing":0,"done":1,"error":0,"cancelled":0`,
+		},
+		{
+			name:       "Empty batch",
+			body:       `{"expressions": []}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"unprocessable entity"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			token := registerAndLogin(t, o)
+			ctx := context.Background()
+
+			req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateBatchRoute, bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(&o.Ts, o.BatchCalculateHandler).ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d", tc.statusCode, w.Code)
+			}
+			if w.Body.String() != tc.want {
+				t.Fatalf("Expected body %s, got %s", tc.want, w.Body.String())
+			}
+			if tc.wantBatch == "" {
+				return
+			}
+
+			for _, result := range tc.results {
+				task, err := o.FetchTask(ctx, &pb.TaskRequest{})
+				if err != nil {
+					t.Fatalf("FetchTask() error = %v", err)
+				}
+
+				result.Id, result.Lease = task.Task.Id, task.Task.Lease
+				if _, err := o.SendResult(ctx, result); err != nil {
+					t.Fatalf("SendResult() error = %v", err)
+				}
+			}
+
+			batch := string(authGet(t, o, token, orchestrator.BatchIdRoute+"1", o.BatchIdHandler))
+			if !strings.Contains(batch, tc.wantBatch) {
+				t.Errorf("Expected batch with %s, got %s", tc.wantBatch, batch)
+			}
+			if !strings.Contains(batch, `"batch_id":1`) {
+				t.Errorf("Expected expressions of batch 1, got %s", batch)
+			}
+		})
+	}
+}
+
+func TestBatchIdHandler(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateBatchRoute, bytes.NewBufferString(`{"expressions": [{"expression": "2"}]}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	middleware.AuthMiddleware(&o.Ts, o.BatchCalculateHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	cases := []struct {
+		name       string
+		path       string
+		statusCode int
+	}{
+		{name: "Existing batch", path: "1", statusCode: http.StatusOK},
+		{name: "Unknown batch", path: "2", statusCode: http.StatusNotFound},
+		{name: "Invalid ID", path: "abc", statusCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, orchestrator.BatchIdRoute+tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(&o.Ts, o.BatchIdHandler).ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebhookDialRejectsInternalAddress(t *testing.T) {
+	t.Setenv(orchestrator.WebhookAllowPrivateEnv, "true")
+	t.Setenv(orchestrator.WebhookMaxAttemptsEnv, "1")
+
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer receiver.Close()
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	submitExpression(t, o, token, fmt.Sprintf(`{"expression": "2+3", "callback_url": %q}`, receiver.URL))
+	fetchAndComplete(t, o, "+", "5")
+
+	// Адрес проверяется и при подключении, например если DNS стал возвращать внутренний адрес
+	t.Setenv(orchestrator.WebhookAllowPrivateEnv, "false")
+	restarted := orchestrator.New(db)
+	if _, err := restarted.SendWebhooks(); err != nil {
+		t.Fatalf("SendWebhooks() error = %v", err)
+	}
+
+	var resp models.WebhookDeliveriesResponse
+	if err := json.Unmarshal(authGet(t, restarted, token, orchestrator.DeliveriesRoute, restarted.DeliveriesHandler), &resp); err != nil {
+		t.Fatalf("failed to parse deliveries: %v", err)
+	}
+	if len(resp.Deliveries) != 1 || resp.Deliveries[0].Status != models.DeliveryFailed ||
+		!strings.Contains(resp.Deliveries[0].LastError, "internal address") {
+		t.Errorf("Expected delivery rejected at dial time, got %+v", resp.Deliveries)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no calls to the receiver, got %d", calls)
+	}
+}
+
+func TestSlowWebhookDoesNotDelayOthers(t *testing.T) {
+	t.Setenv(orchestrator.WebhookAllowPrivateEnv, "true")
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer slow.Close()
+	defer close(release)
+
+	fastCalled := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fastCalled <- struct{}{} }))
+	defer fast.Close()
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	slowToken := registerAndLoginAs(t, o, "alice")
+	fastToken := registerAndLoginAs(t, o, "bob")
+
+	// Доставка медленному получателю стоит в очереди первой
+	submitExpression(t, o, slowToken, fmt.Sprintf(`{"expression": "-5", "callback_url": %q}`, slow.URL))
+	submitExpression(t, o, fastToken, fmt.Sprintf(`{"expression": "-7", "callback_url": %q}`, fast.URL))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o.SendWebhooks()
+	}()
+
+	select {
+	case <-fastCalled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected fast receiver to be called while slow receiver is hanging")
+	}
+
+	release <- struct{}{}
+	<-done
+}
+
+func cacheStats(t *testing.T, o *orchestrator.Orchestrator) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.CacheHandler(w, httptest.NewRequest(http.MethodGet, orchestrator.CacheRoute, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("CacheHandler: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	return w.Body.String()
+}
+
+func TestResultCache(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "sqrt(16) + 1"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+	fetchAndComplete(t, o, "+", "5")
+
+	// Известный результат подставляется сразу, без задачи для агента
+	submitExpression(t, o, token, `{"expression": "sqrt(16) * 2"}`)
+
+	mul, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if mul.Task.Operation != "*" || !reflect.DeepEqual(mul.Task.Args, []string{"4", "2"}) {
+		t.Fatalf("Expected cached sqrt result in args, got %v", mul.Task)
+	}
+
+	// Результат в другом режиме не подходит
+	submitExpression(t, o, token, `{"expression": "sqrt(16)", "mode": "decimal"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+
+	// Выражение целиком из кэша сразу готово
+	submitExpression(t, o, token, `{"expression": "1 + sqrt(16)"}`)
+
+	req := httptest.NewRequest(http.MethodGet, orchestrator.ExpressionIdRoute+"4", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+
+	want := `{"id":4,"expression":"1 + sqrt(16)","status":"done","result":5,"tasks_saved":2}`
+	if w.Body.String() != want {
+		t.Errorf("Expected body %s, got %s", want, w.Body.String())
+	}
+
+	want = `{"size":3,"capacity":1000,"persistent":false,"hits":3,"misses":3}`
+	if got := cacheStats(t, o); got != want {
+		t.Errorf("Expected cache stats %s, got %s", want, got)
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	t.Setenv(orchestrator.ResultCacheSizeEnv, "1")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	submitExpression(t, o, token, `{"expression": "sqrt(16)"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+	submitExpression(t, o, token, `{"expression": "sqrt(9)"}`)
+	fetchAndComplete(t, o, "sqrt", "3")
+
+	// sqrt(16) вытеснен более новым результатом
+	submitExpression(t, o, token, `{"expression": "sqrt(16)"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+
+	want := `{"size":1,"capacity":1,"persistent":false,"hits":0,"misses":3}`
+	if got := cacheStats(t, o); got != want {
+		t.Errorf("Expected cache stats %s, got %s", want, got)
+	}
+}
+
+func TestResultCacheSurvivesRestart(t *testing.T) {
+	t.Setenv(orchestrator.ResultCachePersistEnv, "true")
+	t.Setenv(orchestrator.CheapOperationsEnv, "*")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+
+	// Результат sqrt не проверен и не сохраняется, результат умножения оркестратор пересчитал сам
+	submitExpression(t, o, token, `{"expression": "sqrt(16) * 2"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+	fetchAndComplete(t, o, "*", "8")
+
+	t.Setenv(orchestrator.CheapOperationsEnv, "")
+	restarted := orchestrator.New(db)
+	submitExpression(t, restarted, token, `{"expression": "4 * 2 + 1"}`)
+	fetchAndComplete(t, restarted, "+", "9")
+
+	want := `{"size":2,"capacity":1000,"persistent":true,"hits":1,"misses":1}`
+	if got := cacheStats(t, restarted); got != want {
+		t.Errorf("Expected cache stats %s, got %s", want, got)
+	}
+}
+
+func TestResultCacheIsolation(t *testing.T) {
+	t.Setenv(orchestrator.CheapOperationsEnv, "*")
+
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	alice := registerAndLoginAs(t, o, "alice")
+	bob := registerAndLoginAs(t, o, "bob")
+
+	// Неверный результат дешёвой операции не попадает в кэш
+	submitExpression(t, o, alice, `{"expression": "sqrt(16) * 2"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+	fetchAndComplete(t, o, "*", "9")
+
+	want := `{"size":1,"capacity":1000,"persistent":false,"hits":0,"misses":1}`
+	if got := cacheStats(t, o); got != want {
+		t.Errorf("Expected cache stats %s, got %s", want, got)
+	}
+
+	// Непроверенный результат sqrt другого пользователя не используется
+	submitExpression(t, o, bob, `{"expression": "sqrt(16) * 2"}`)
+	fetchAndComplete(t, o, "sqrt", "4")
+	fetchAndComplete(t, o, "*", "8")
+
+	want = `{"size":3,"capacity":1000,"persistent":false,"hits":0,"misses":2}`
+	if got := cacheStats(t, o); got != want {
+		t.Errorf("Expected cache stats %s, got %s", want, got)
+	}
+}
+
+func TestResultCacheSharedAfterConfirmation(t *testing.T) {
+	cases := []struct {
+		name       string
+		agents     [2]string
+		values     [2]string
+		wantShared bool
+	}{
+		{
+			name:       "Confirmed by another agent",
+			agents:     [2]string{"agent-1", "agent-2"},
+			values:     [2]string{"1.4142135623730951", "1.4142135623730951"},
+			wantShared: true,
+		},
+		{
+			name:   "Same agent does not confirm",
+			agents: [2]string{"agent-1", "agent-1"},
+			values: [2]string{"1.4142135623730951", "1.4142135623730951"},
+		},
+		{
+			name:   "Different results",
+			agents: [2]string{"agent-1", "agent-2"},
+			values: [2]string{"1.4142135623730951", "1.5"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(orchestrator.ResultCachePersistEnv, "true")
+
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			ctx := context.Background()
+
+			// Алиса и Боб считают sqrt(2) на своих агентах
+			for i, login := range []string{"alice", "bob"} {
+				submitExpression(t, o, registerAndLoginAs(t, o, login), `{"expression": "sqrt(2)"}`)
+
+				task, err := o.FetchTask(ctx, &pb.TaskRequest{AgentId: tc.agents[i]})
+				if err != nil {
+					t.Fatalf("FetchTask() error = %v", err)
+				}
+				result := &pb.TaskResult{Id: task.Task.Id, Value: tc.values[i], Lease: task.Task.Lease, AgentId: tc.agents[i]}
+				if _, err := o.SendResult(ctx, result); err != nil {
+					t.Fatalf("SendResult() error = %v", err)
+				}
+			}
+
+			// Третий пользователь получает подтверждённый результат без задачи для агента
+			req := httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute, bytes.NewBufferString(`{"expression": "sqrt(2)"}`))
+			req.Header.Set("Authorization", "Bearer "+registerAndLoginAs(t, o, "carol"))
+			w := httptest.NewRecorder()
+			middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+
+			want := `{"id":3}`
+			if tc.wantShared {
+				want = `{"id":3,"tasks_saved":1}`
+			}
+			if w.Body.String() != want {
+				t.Errorf("Expected body %s, got %s", want, w.Body.String())
+			}
+
+			// Подтверждённый результат сохраняется в базу и переживает перезапуск
+			restarted := cacheStats(t, orchestrator.New(db))
+			if shared := !strings.HasPrefix(restarted, `{"size":0,`); shared != tc.wantShared {
+				t.Errorf("Expected persisted shared result %v, got %s", tc.wantShared, restarted)
+			}
+		})
+	}
+}
+
+func TestConditionalTasks(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		steps      [][2]string
+		want       float64
+	}{
+		{
+			name:       "Then branch",
+			expression: "if(2 > 1, 3 * 4, 5 - 6) + 1",
+			steps:      [][2]string{{">", "1"}, {"*", "12"}, {"+", "13"}},
+			want:       13,
+		},
+		{
+			name:       "Else branch with number",
+			expression: "if(2 < 1, 3 * 4, 5)",
+			steps:      [][2]string{{"<", "0"}},
+			want:       5,
+		},
+		{
+			name:       "Nested condition in skipped branch",
+			expression: "if(1 > 2, if(3 > 4, 1 * 1, 2 * 2), 5 + 5)",
+			steps:      [][2]string{{">", "0"}, {"+", "10"}},
+			want:       10,
+		},
+		{
+			name:       "Nested condition in chosen branch",
+			expression: "if(2 > 1, if(3 > 4, 1 * 1, 2 * 2), 5 + 5)",
+			steps:      [][2]string{{">", "1"}, {">", "0"}, {"*", "4"}},
+			want:       4,
+		},
+		{
+			name:       "Condition is known without tasks",
+			expression: "if(1, 2 * 3, 1 / 0)",
+			steps:      [][2]string{{"*", "6"}},
+			want:       6,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, _ := database.NewInMemoryDatabase()
+			o := orchestrator.New(db)
+			token := registerAndLogin(t, o)
+
+			submitExpression(t, o, token, fmt.Sprintf(`{"expression": %q}`, tc.expression))
+
+			for _, step := range tc.steps {
+				fetchAndComplete(t, o, step[0], step[1])
+			}
+
+			if task, err := o.FetchTask(context.Background(), &pb.TaskRequest{}); err == nil {
+				t.Fatalf("Expected no tasks left, got %v", task.Task)
+			}
+
+			expression, err := db.ExpressionRepo.GetExpressionByID(1)
+			if err != nil {
+				t.Fatalf("GetExpressionByID() error = %v", err)
+			}
+			if expression.Status != models.StatusDone || expression.Result != tc.want {
+				t.Errorf("Expected done expression with result %v, got %+v", tc.want, expression)
+			}
+		})
+	}
+}
+
+func TestTaskErrorFailsExpression(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "1/0 + 2*3"}`)
+
+	division, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+
+	result := &pb.TaskResult{Id: division.Task.Id, Error: "division by zero", Lease: division.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	expression, err := db.ExpressionRepo.GetExpressionByID(division.Task.ExpressionId)
+	if err != nil {
+		t.Fatalf("GetExpressionByID() error = %v", err)
+	}
+	if expression.Status != models.StatusError || expression.Error != "division by zero" {
+		t.Errorf("Expected failed expression, got %+v", expression)
+	}
+
+	if task, err := o.FetchTask(ctx, &pb.TaskRequest{}); err == nil {
+		t.Errorf("Expected remaining tasks to be cancelled, got %v", task.Task)
+	}
+}
+
+func TestDecimalMode(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "1/3*3", "mode": "decimal", "scale": 28}`)
+
+	division, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if division.Task.Mode != "decimal" || division.Task.Scale != 28 {
+		t.Fatalf("Expected task in decimal mode with scale 28, got %v", division.Task)
+	}
+
+	// Агент старой версии присылает только float64, такой результат для decimal не принимается
+	legacy := &pb.TaskResult{Id: division.Task.Id, Result: 1.0 / 3, Lease: division.Task.Lease}
+	if _, err := o.SendResult(ctx, legacy); err == nil {
+		t.Fatalf("Expected result without value to be rejected in decimal mode")
+	}
+
+	result := &pb.TaskResult{Id: division.Task.Id, Value: "0.3333333333333333333333333333", Result: 1.0 / 3, Lease: division.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	multiplication, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if want := []string{"0.3333333333333333333333333333", "3"}; !reflect.DeepEqual(multiplication.Task.Args, want) {
+		t.Fatalf("Expected exact value to be substituted, got %v", multiplication.Task.Args)
+	}
+
+	result = &pb.TaskResult{Id: multiplication.Task.Id, Value: "0.9999999999999999999999999999", Result: 1, Lease: multiplication.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	expression, err := db.ExpressionRepo.GetExpressionByID(multiplication.Task.ExpressionId)
+	if err != nil {
+		t.Fatalf("GetExpressionByID() error = %v", err)
+	}
+	if expression.Status != models.StatusDone || expression.Value != "0.9999999999999999999999999999" || expression.Result != 1 {
+		t.Errorf("Expected exact decimal result, got %+v", expression)
+	}
+}
+
+func TestComplexMode(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	submitExpression(t, o, token, `{"expression": "sqrt(-4) * i", "mode": "complex"}`)
+
+	root, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if root.Task.Mode != "complex" || root.Task.Operation != "sqrt" {
+		t.Fatalf("Expected sqrt task in complex mode, got %v", root.Task)
+	}
+
+	result := &pb.TaskResult{Id: root.Task.Id, Value: "2i", Imag: 2, Lease: root.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	multiplication, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if want := []string{"2i", "1i"}; !reflect.DeepEqual(multiplication.Task.Args, want) {
+		t.Fatalf("Expected complex arguments %v, got %v", want, multiplication.Task.Args)
+	}
+
+	result = &pb.TaskResult{Id: multiplication.Task.Id, Value: "-2", Result: -2, Lease: multiplication.Task.Lease}
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	expression, err := db.ExpressionRepo.GetExpressionByID(multiplication.Task.ExpressionId)
+	if err != nil {
+		t.Fatalf("GetExpressionByID() error = %v", err)
+	}
+
+	body, err := expression.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if want := `"result":{"real":-2,"imag":0}`; !strings.Contains(string(body), want) {
+		t.Errorf("Expected %s in %s", want, body)
+	}
+}
+
+func TestVariablesHandler(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	handler := middleware.AuthMiddleware(&o.Ts, o.VariablesHandler)
+
+	cases := []struct {
+		name       string
+		method     string
+		request    string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "Save variable",
+			method:     http.MethodPost,
+			request:    `{"name": "rate", "value": 0.13}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"rate","value":0.13}`,
+		},
+		{
+			name:       "Save another variable",
+			method:     http.MethodPost,
+			request:    `{"name": "price", "value": 100}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"price","value":100}`,
+		},
+		{
+			name:       "Overwrite variable",
+			method:     http.MethodPost,
+			request:    `{"name": "rate", "value": 0.2}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"rate","value":0.2}`,
+		},
+		{
+			name:       "Missing value",
+			method:     http.MethodPost,
+			request:    `{"name": "x"}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"unprocessable entity"}`,
+		},
+		{
+			name:       "Invalid name",
+			method:     http.MethodPost,
+			request:    `{"name": "1x", "value": 1}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"invalid name \"1x\": only letters, digits and '_' are allowed, and it can't start with a digit"}`,
+		},
+		{
+			name:       "Reserved constant",
+			method:     http.MethodPost,
+			request:    `{"name": "pi", "value": 3}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"name \"pi\" is reserved for a built-in constant"}`,
+		},
+		{
+			name:       "Reserved function",
+			method:     http.MethodPost,
+			request:    `{"name": "sqrt", "value": 3}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"name \"sqrt\" is reserved for a built-in function"}`,
+		},
+		{
+			name:       "List variables",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			want:       `{"variables":[{"name":"price","value":100},{"name":"rate","value":0.2}]}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, orchestrator.VariablesRoute, bytes.NewBufferString(tc.request))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d", tc.statusCode, w.Code)
+			}
+
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("Expected body %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpressionWithVariables(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	for _, body := range []string{`{"name": "price", "value": 100}`, `{"name": "rate", "value": 0.13}`} {
+		req := httptest.NewRequest(http.MethodPost, orchestrator.VariablesRoute, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		middleware.AuthMiddleware(&o.Ts, o.VariablesHandler).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("save variable %s: status = %d, body = %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	submitExpression(t, o, token, `{"expression": "price * (1 + rate)"}`)
+
+	sum, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if sum.Task.Operation != "+" || !reflect.DeepEqual(sum.Task.Args, []string{"1", "0.13"}) {
+		t.Fatalf("Expected variables to be substituted, got %v", sum.Task)
+	}
+
+	// Изменение переменной не влияет на уже принятое выражение
+	req := httptest.NewRequest(http.MethodPost, orchestrator.VariablesRoute, bytes.NewBufferString(`{"name": "rate", "value": 0.5}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	middleware.AuthMiddleware(&o.Ts, o.VariablesHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodGet, orchestrator.ExpressionIdRoute+"1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+
+	// Оставшееся время зависит от того, сколько уже выполняется задача, поэтому прогресс не сравнивается
+	want := `{"id":1,"expression":"price * (1 + rate)","status":"computing","result":0,"variables":{"price":100,"rate":0.13},` +
+		`"critical_path_ms":2000,"progress":`
+	if !strings.HasPrefix(w.Body.String(), want) {
+		t.Errorf("Expected body to start with %s, got %s", want, w.Body.String())
+	}
+}
+
+func TestFunctionsHandler(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	handler := middleware.AuthMiddleware(&o.Ts, o.FunctionsHandler)
+
+	cases := []struct {
+		name       string
+		method     string
+		request    string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "Save function",
+			method:     http.MethodPost,
+			request:    `{"name": "vat", "params": ["x"], "body": "x * 1.2"}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"vat","params":["x"],"body":"x * 1.2"}`,
+		},
+		{
+			name:       "Function without parameters",
+			method:     http.MethodPost,
+			request:    `{"name": "tau", "body": "2 * pi"}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"tau","params":[],"body":"2 * pi"}`,
+		},
+		{
+			name:       "Function calls another function",
+			method:     http.MethodPost,
+			request:    `{"name": "gross", "params": ["x", "n"], "body": "vat(x) * n"}`,
+			statusCode: http.StatusOK,
+			want:       `{"name":"gross","params":["x","n"],"body":"vat(x) * n"}`,
+		},
+		{
+			name:       "Missing body",
+			method:     http.MethodPost,
+			request:    `{"name": "f", "params": ["x"]}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"unprocessable entity"}`,
+		},
+		{
+			name:       "Reserved name",
+			method:     http.MethodPost,
+			request:    `{"name": "max", "params": ["x"], "body": "x"}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"name \"max\" is reserved for a built-in function"}`,
+		},
+		{
+			name:       "Syntax error in body",
+			method:     http.MethodPost,
+			request:    `{"name": "f", "params": ["x"], "body": "x * (1"}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"function f: unexpected end of expression at position 7"}`,
+		},
+		{
+			name:       "Unknown variable in body",
+			method:     http.MethodPost,
+			request:    `{"name": "f", "params": ["x"], "body": "x * y"}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       `{"error":"function f: unknown variable 'y' at position 5"}`,
+		},
+		{
+			name:       "Recursion cycle",
+			method:     http.MethodPost,
+			request:    `{"name": "vat", "params": ["x"], "body": "gross(x, 1)"}`,
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "List functions",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			want: `{"functions":[{"name":"gross","params":["x","n"],"body":"vat(x) * n"},` +
+				`{"name":"tau","params":[],"body":"2 * pi"},{"name":"vat","params":["x"],"body":"x * 1.2"}]}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, orchestrator.FunctionsRoute, bytes.NewBufferString(tc.request))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d", tc.statusCode, w.Code)
+			}
+
+			if got := w.Body.String(); tc.want != "" && got != tc.want {
+				t.Errorf("Expected body %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpressionWithFunctions(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	saveFunction := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, orchestrator.FunctionsRoute, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		middleware.AuthMiddleware(&o.Ts, o.FunctionsHandler).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("save function %s: status = %d, body = %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	saveFunction(`{"name": "vat", "params": ["x"], "body": "x * 1.2"}`)
+	saveFunction(`{"name": "unused", "params": [], "body": "1"}`)
+
+	submitExpression(t, o, token, `{"expression": "vat(100) + 1"}`)
+
+	mul, err := o.FetchTask(ctx, &pb.TaskRequest{})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	if mul.Task.Operation != "*" || !reflect.DeepEqual(mul.Task.Args, []string{"100", "1.2"}) {
+		t.Fatalf("Expected function call to be inlined, got %v", mul.Task)
+	}
+
+	// Изменение функции не влияет на уже принятое выражение
+	saveFunction(`{"name": "vat", "params": ["x"], "body": "x * 1.18"}`)
+
+	req := httptest.NewRequest(http.MethodGet, orchestrator.ExpressionIdRoute+"1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+
+	want := `{"id":1,"expression":"vat(100) + 1","status":"computing","result":0,` +
+		`"functions":[{"name":"vat","params":["x"],"body":"x * 1.2"}],"critical_path_ms":2000,"progress":`
+	if !strings.HasPrefix(w.Body.String(), want) {
+		t.Errorf("Expected body to start with %s, got %s", want, w.Body.String())
+	}
+
+	// Неверное число аргументов - ошибка разбора с позицией вызова
+	req = httptest.NewRequest(http.MethodPost, orchestrator.CalculateRoute, bytes.NewBufferString(`{"expression": "1 + vat(1, 2)"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.CalculateHandler).ServeHTTP(w, req)
+
+	want = `{"error":"function vat expects 1 argument(s), got 2 at position 5"}`
+	if w.Code != http.StatusUnprocessableEntity || w.Body.String() != want {
+		t.Errorf("Expected %d %s, got %d %s", http.StatusUnprocessableEntity, want, w.Code, w.Body.String())
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	adminToken := registerAndLoginAs(t, o, "admin")
+	userToken := registerAndLoginAs(t, o, "user")
+
+	cases := []struct {
+		name       string
+		token      string
+		statusCode int
+	}{
+		{name: "Admin", token: adminToken, statusCode: http.StatusOK},
+		{name: "Other user", token: userToken, statusCode: http.StatusForbidden},
+		{name: "Without token", statusCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, orchestrator.AgentsRoute, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			middleware.AdminMiddleware(&o.Ts, map[int64]bool{1: true}, o.AgentsHandler).ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAgentsHandler(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	ctx := context.Background()
+
+	if _, err := o.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: "agent-1"}); err == nil {
+		t.Errorf("Expected heartbeat from unregistered agent to fail")
+	}
+
+	info := &pb.AgentInfo{Id: "agent-1", Hostname: "host", ComputingPower: 2, Version: "test"}
+	if _, err := o.Register(ctx, info); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := o.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: "agent-1"}); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+
+	submitExpression(t, o, token, `{"expression": "2+2"}`)
+	submitExpression(t, o, token, `{"expression": "3*3"}`)
+
+	done, err := o.FetchTask(ctx, &pb.TaskRequest{AgentId: "agent-1"})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+	result := &pb.TaskResult{Id: done.Task.Id, Result: 4, Lease: done.Task.Lease, AgentId: "agent-2"}
+	if _, err := o.SendResult(ctx, result); err == nil {
+		t.Errorf("Expected result from another agent to be rejected")
+	}
+	result.AgentId = "agent-1"
+	if _, err := o.SendResult(ctx, result); err != nil {
+		t.Fatalf("SendResult() error = %v", err)
+	}
+
+	running, err := o.FetchTask(ctx, &pb.TaskRequest{AgentId: "agent-1"})
+	if err != nil {
+		t.Fatalf("FetchTask() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	o.AgentsHandler(w, httptest.NewRequest(http.MethodGet, orchestrator.AgentsRoute, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var got struct {
+		Agents []struct {
+			Id        string  `json:"id"`
+			Hostname  string  `json:"hostname"`
+			Online    bool    `json:"online"`
+			InFlight  []int64 `json:"in_flight"`
+			Completed int64   `json:"completed"`
+		} `json:"agents"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+
+	if len(got.Agents) != 1 {
+		t.Fatalf("Expected 1 agent, got %d", len(got.Agents))
+	}
+
+	agent := got.Agents[0]
+	if agent.Id != "agent-1" || agent.Hostname != "host" || !agent.Online || agent.Completed != 1 {
+		t.Errorf("Unexpected agent: %+v", agent)
+	}
+	if !reflect.DeepEqual(agent.InFlight, []int64{running.Task.Id}) {
+		t.Errorf("Expected in-flight tasks %v, got %v", []int64{running.Task.Id}, agent.InFlight)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	db, _ := database.NewInMemoryDatabase()
+	o := orchestrator.New(db)
+	token := registerAndLogin(t, o)
+	client := startGRPC(t, o)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Dispatch(ctx)
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if err := stream.Send(&pb.DispatchRequest{FreeSlots: 1, AgentId: "agent-1"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	submitExpression(t, o, token, `{"expression": "2+3*4"}`)
+
+	running, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, orchestrator.ExpressionIdRoute+"1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if want := `{"id":1,"expression":"2+3*4","status":"cancelled","result":0,"critical_path_ms":2000}`; w.Body.String() != want {
+		t.Errorf("Expected body %s, got %s", want, w.Body.String())
+	}
+
+	notice, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	if notice.GetCancelTaskId() != running.GetTask().Id {
+		t.Errorf("Expected cancel notice for task %d, got %v", running.GetTask().Id, notice)
+	}
+
+	late := &pb.TaskResult{Id: running.GetTask().Id, Result: 12, Lease: running.GetTask().Lease, AgentId: "agent-1"}
+	if _, err := client.SendResult(ctx, late); err != nil {
+		t.Errorf("SendResult() for cancelled task error = %v", err)
+	}
+
+	// Отменённые задачи завершены: у них есть время завершения
+	tasks, err := db.TaskRepo.GetTasksByExpression(1)
+	if err != nil {
+		t.Fatalf("GetTasksByExpression() error = %v", err)
+	}
+	for _, task := range tasks {
+		if task.Status != models.StatusCancelled || task.FinishedAt.IsZero() {
+			t.Errorf("Expected cancelled task with finished_at, got %+v", task)
+		}
+	}
+
+	if _, err := o.FetchTask(ctx, &pb.TaskRequest{}); err == nil {
+		t.Errorf("Expected no tasks after cancellation")
+	}
+
+	w = httptest.NewRecorder()
+	middleware.AuthMiddleware(&o.Ts, o.ExpressionIdHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for second cancel, got %d", http.StatusConflict, w.Code)
+	}
+}
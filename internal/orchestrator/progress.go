@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+// criticalPath возвращает длину самого долгого пути в графе задач в миллисекундах, то есть сколько
+// займёт вычисление при неограниченном числе агентов. Вес задачи - её OperationTime.
+// Обе ветки if входят в оценку, потому что до выполнения условия неизвестно, какая из них понадобится.
+// Выполненные и пропущенные задачи ничего не стоят, у выполняющихся учитывается только оставшееся
+// к моменту now время. Задачи должны быть отсортированы по Id: зависимости всегда создаются раньше.
+func criticalPath(tasks []models.Task, now time.Time) int64 {
+	finish := make(map[string]int64, len(tasks))
+	var longest int64
+
+	for _, task := range tasks {
+		var start int64
+		for _, arg := range task.Args {
+			start = max(start, finish[arg])
+		}
+		if task.ConditionId != 0 {
+			start = max(start, finish[taskReference(task.ConditionId)])
+		}
+
+		end := start + remainingTime(task, now)
+		finish[taskReference(task.Id)] = end
+		longest = max(longest, end)
+	}
+
+	return longest
+}
+
+// remainingTime возвращает, сколько миллисекунд ещё займёт сама задача.
+func remainingTime(task models.Task, now time.Time) int64 {
+	switch task.Status {
+	case models.StatusPending, models.StatusBlocked:
+		return task.OperationTime
+	case models.StatusComputing:
+		return max(0, task.OperationTime-now.Sub(task.LeasedAt).Milliseconds())
+	}
+	return 0
+}
+
+// progress считает задачи выражения по статусам и оценивает оставшееся время.
+func progress(tasks []models.Task, now time.Time) *models.Progress {
+	p := &models.Progress{Total: len(tasks), RemainingMs: criticalPath(tasks, now)}
+
+	for _, task := range tasks {
+		switch task.Status {
+		case models.StatusPending:
+			p.Pending++
+		case models.StatusBlocked:
+			p.Blocked++
+		case models.StatusComputing:
+			p.Computing++
+		case models.StatusDone:
+			p.Done++
+		case models.StatusSkipped:
+			p.Skipped++
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	pb "github.com/MoodyShoo/go-http-calculator/internal/proto"
+)
+
+// registry хранит сведения об агентах, которые зарегистрировались в оркестраторе.
+// Какие задачи держит агент, хранится в таблице tasks, поэтому не теряется при перезапуске.
+type registry struct {
+	mu      sync.Mutex
+	agents  map[string]*models.Agent
+	streams map[string]chan int64
+}
+
+func newRegistry() *registry {
+	return &registry{
+		agents:  make(map[string]*models.Agent),
+		streams: make(map[string]chan int64),
+	}
+}
+
+// register добавляет агента или обновляет сведения о нём при повторной регистрации.
+func (r *registry) register(info *pb.AgentInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.agents[info.Id] = &models.Agent{
+		Id:             info.Id,
+		Hostname:       info.Hostname,
+		ComputingPower: int(info.ComputingPower),
+		Version:        info.Version,
+		RegisteredAt:   now,
+		LastHeartbeat:  now,
+	}
+}
+
+// heartbeat отмечает, что агент жив.
+func (r *registry) heartbeat(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, ok := r.agents[id]
+	if !ok {
+		return fmt.Errorf("agent not registered")
+	}
+
+	agent.LastHeartbeat = time.Now()
+	return nil
+}
+
+// list возвращает копию списка агентов, отсортированную по id.
+func (r *registry) list() []models.Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agents := make([]models.Agent, 0, len(r.agents))
+	for _, agent := range r.agents {
+		agents = append(agents, *agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Id < agents[j].Id
+	})
+
+	return agents
+}
+
+// attach запоминает поток Dispatch агента и возвращает канал, в который приходят id отменённых задач.
+func (r *registry) attach(agentId string) chan int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancels := make(chan int64, 16)
+	r.streams[agentId] = cancels
+	return cancels
+}
+
+// detach забывает поток агента, если он не был заменён более новым подключением.
+func (r *registry) detach(agentId string, cancels chan int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.streams[agentId] == cancels {
+		delete(r.streams, agentId)
+	}
+}
+
+// cancelTask сообщает агенту, что выполнять задачу больше не нужно.
+// Возвращает false, если у агента нет открытого потока.
+func (r *registry) cancelTask(agentId string, taskId int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancels, ok := r.streams[agentId]
+	if !ok {
+		return false
+	}
+
+	select {
+	case cancels <- taskId:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+)
+
+// taskTree собирает задачи выражения в дерево по их исходным аргументам (Operands).
+// Корни - задачи, на которые не ссылается ни одна другая задача; обычно это одна задача корня выражения.
+// Одинаковые подвыражения считаются одной задачей, поэтому такая задача встречается в дереве несколько раз.
+// Задачи должны быть отсортированы по Id.
+func taskTree(tasks []models.Task) []models.TaskNode {
+	nodes := make(map[string]*models.TaskNode, len(tasks))
+	referenced := make(map[string]bool)
+
+	// Зависимости создаются раньше зависящих от них задач, поэтому их узлы уже готовы
+	for _, task := range tasks {
+		node := newTaskNode(task, task.Operands)
+		for i, operand := range task.Operands {
+			if child, ok := nodes[operand]; ok {
+				node.Operands[i] = models.Operand{Task: child}
+				referenced[operand] = true
+			}
+		}
+
+		nodes[taskReference(task.Id)] = node
+	}
+
+	var roots []models.TaskNode
+	for _, task := range tasks {
+		if ref := taskReference(task.Id); !referenced[ref] {
+			roots = append(roots, *nodes[ref])
+		}
+	}
+
+	return roots
+}
+
+// newTaskNode возвращает узел задачи с операндами-числами operands.
+func newTaskNode(task models.Task, operands []string) *models.TaskNode {
+	node := &models.TaskNode{
+		Id:         task.Id,
+		Operation:  task.Operation,
+		Operands:   make([]models.Operand, len(operands)),
+		Status:     task.Status,
+		Value:      task.Value,
+		AgentId:    task.AgentId,
+		StartedAt:  timeOrNil(task.LeasedAt),
+		FinishedAt: timeOrNil(task.FinishedAt),
+		Error:      task.Error,
+	}
+
+	for i, operand := range operands {
+		node.Operands[i].Value = operand
+	}
+
+	return node
+}
+
+// timeOrNil возвращает nil для нулевого времени, чтобы оно не попадало в JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,365 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/database"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/internal/util"
+)
+
+const (
+	// webhookBatch - сколько доставок отправляется за один проход.
+	webhookBatch = 100
+	// webhookWorkers - на сколько адресов webhook отправляются одновременно.
+	webhookWorkers = 8
+	// resolveTimeout ограничивает проверку хоста callback_url.
+	resolveTimeout = 5 * time.Second
+	// resolveWorkers - сколько хостов callback_url пакета проверяется одновременно.
+	resolveWorkers = 16
+	// batchResolveTimeout ограничивает проверку всех callback_url одного пакета.
+	batchResolveTimeout = 10 * time.Second
+)
+
+// validateCallbackURL проверяет, что на адрес можно отправить webhook: это абсолютный http или https адрес,
+// и его хост не ведёт на сам сервер или во внутреннюю сеть (см. isInternalIP). Иначе любой пользователь
+// мог бы заставить сервер отправлять запросы на localhost, адрес метаданных облака или соседние сервисы.
+func (o *Orchestrator) validateCallbackURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url: only absolute http and https URLs are allowed")
+	}
+
+	if o.config.WebhookAllowPrivate {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: cannot resolve host %q", u.Hostname())
+	}
+
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("invalid callback_url: internal address %s is not allowed", addr.IP)
+		}
+	}
+
+	return nil
+}
+
+// validateCallbackURLs проверяет адреса urls так же, как validateCallbackURL, но не больше resolveWorkers
+// одновременно, и возвращает ошибку для каждого адреса (nil, если адрес подходит).
+// Проверка ограничена сроком ctx: после него непроверенные хосты считаются неразрешимыми.
+func (o *Orchestrator) validateCallbackURLs(ctx context.Context, urls []string) map[string]error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error, len(urls))
+	)
+
+	queue := make(chan string)
+	for i := 0; i < min(resolveWorkers, len(urls)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				err := o.validateCallbackURL(ctx, u)
+
+				mu.Lock()
+				errs[u] = err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, u := range urls {
+		queue <- u
+	}
+	close(queue)
+	wg.Wait()
+
+	return errs
+}
+
+// isInternalIP проверяет, что адрес - loopback, адрес внутренней сети, link-local, multicast или 0.0.0.0.
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// newWebhookClient возвращает HTTP-клиент для отправки webhook. Адрес проверяется ещё раз
+// при каждом подключении: между validateCallbackURL и отправкой DNS может вернуть другой адрес (DNS rebinding),
+// а получатель может перенаправить запрос во внутреннюю сеть.
+func newWebhookClient(config *Config) *http.Client {
+	dialer := &net.Dialer{Timeout: resolveTimeout}
+	if !config.WebhookAllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+				return fmt.Errorf("webhook to internal address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Запросы идут напрямую: через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   time.Duration(config.WebhookTimeoutMs) * time.Millisecond,
+		Transport: transport,
+	}
+}
+
+// enqueueWebhook ставит в очередь отправку завершённого выражения на его callback_url.
+// Вызывается через ту же транзакцию db, в которой сохраняется итоговый статус выражения,
+// чтобы завершённое выражение не осталось без доставки. После фиксации нужно вызвать notifyWebhooks.
+func enqueueWebhook(db *database.Database, exp models.Expression) error {
+	if exp.CallbackURL == "" {
+		return nil
+	}
+
+	payload, err := exp.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook of expression %d: %v", exp.Id, err)
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		UserID:        exp.UserID,
+		ExpressionId:  exp.Id,
+		URL:           exp.CallbackURL,
+		Payload:       string(payload),
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	if _, err := db.WebhookRepo.InsertDelivery(delivery); err != nil {
+		return fmt.Errorf("failed to enqueue webhook of expression %d: %v", exp.Id, err)
+	}
+
+	return nil
+}
+
+// notifyWebhooks будит отправку webhook, не дожидаясь следующего тика.
+func (o *Orchestrator) notifyWebhooks() {
+	select {
+	case o.webhooksReady <- struct{}{}:
+	default:
+	}
+}
+
+// saveFinished сохраняет итоговый статус выражения и в той же транзакции ставит в очередь его webhook.
+// Вызывается под o.mu.
+func (o *Orchestrator) saveFinished(exp models.Expression) error {
+	err := o.db.Transaction(func(tx *database.Database) error {
+		if err := tx.ExpressionRepo.UpdateExpression(exp.Id, exp); err != nil {
+			return err
+		}
+		return enqueueWebhook(tx, exp)
+	})
+	if err != nil {
+		return err
+	}
+
+	o.notifyWebhooks()
+	return nil
+}
+
+// SendWebhooks отправляет доставки, время которых наступило, и возвращает число доставленных.
+// Доставки на один адрес отправляются по очереди, а на разные адреса - параллельно, не больше webhookWorkers
+// сразу, поэтому медленный получатель задерживает только свои доставки. После неудачной попытки
+// остальные доставки на тот же адрес ждут следующего прохода.
+// Неудачная попытка повторяется через WebhookRetryBaseMs, затем через вдвое большее время и так далее;
+// после WebhookMaxAttempts попыток доставка отмечается как неудачная.
+// o.mu не удерживается во время HTTP-запросов.
+func (o *Orchestrator) SendWebhooks() (int, error) {
+	o.mu.Lock()
+	deliveries, err := o.db.WebhookRepo.GetDueDeliveries(time.Now(), webhookBatch)
+	o.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// Доставки группируются по адресу, внутри группы сохраняется порядок очереди
+	var urls []string
+	groups := make(map[string][]models.WebhookDelivery)
+	for _, d := range deliveries {
+		if _, ok := groups[d.URL]; !ok {
+			urls = append(urls, d.URL)
+		}
+		groups[d.URL] = append(groups[d.URL], d)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+		firstErr  error
+	)
+
+	queue := make(chan []models.WebhookDelivery)
+	for i := 0; i < min(webhookWorkers, len(urls)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				n, err := o.sendWebhookGroup(group)
+
+				mu.Lock()
+				delivered += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, u := range urls {
+		queue <- groups[u]
+	}
+	close(queue)
+	wg.Wait()
+
+	return delivered, firstErr
+}
+
+// sendWebhookGroup отправляет по очереди доставки на один адрес, пока получатель отвечает успешно,
+// и возвращает число доставленных.
+func (o *Orchestrator) sendWebhookGroup(group []models.WebhookDelivery) (int, error) {
+	delivered := 0
+	for _, d := range group {
+		ok, err := o.sendWebhook(d)
+		if err != nil {
+			return delivered, err
+		}
+		if !ok {
+			break
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// sendWebhook выполняет одну попытку доставки и сохраняет её результат. Возвращает, удалась ли попытка.
+func (o *Orchestrator) sendWebhook(d models.WebhookDelivery) (bool, error) {
+	o.mu.Lock()
+	secret, err := o.db.WebhookRepo.GetSecret(d.UserID)
+	o.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	code, sendErr := o.deliverWebhook(d, secret)
+	now := time.Now()
+	d.Attempts++
+	d.ResponseCode = code
+
+	switch {
+	case sendErr == nil:
+		d.Status = models.DeliveryDelivered
+		d.LastError = ""
+		d.DeliveredAt = &now
+	case d.Attempts >= o.config.WebhookMaxAttempts:
+		d.Status = models.DeliveryFailed
+		d.LastError = sendErr.Error()
+		log.Printf("Webhook %d to %s failed after %d attempts: %v", d.Id, d.URL, d.Attempts, sendErr)
+	default:
+		d.LastError = sendErr.Error()
+		d.NextAttemptAt = now.Add(o.webhookBackoff(d.Attempts))
+	}
+
+	o.mu.Lock()
+	err = o.db.WebhookRepo.UpdateDelivery(d)
+	o.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return sendErr == nil, nil
+}
+
+// webhookBackoff возвращает паузу после attempts неудачных попыток.
+func (o *Orchestrator) webhookBackoff(attempts int) time.Duration {
+	base := time.Duration(o.config.WebhookRetryBaseMs) * time.Millisecond
+	return base << min(attempts-1, 20)
+}
+
+// deliverWebhook отправляет выражение на адрес доставки и возвращает код ответа.
+// Доставка успешна, если получатель ответил 2xx.
+func (o *Orchestrator) deliverWebhook(d models.WebhookDelivery, secret string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set(util.ContentType, util.ApplicationJson)
+	req.Header.Set(SignatureHeader, "sha256="+signPayload(secret, d.Payload))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.Id, 10))
+
+	resp, err := o.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Тело ответа не нужно, но его чтение позволяет переиспользовать соединение
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// signPayload возвращает HMAC-SHA256 тела webhook в hex.
+func signPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookSender отправляет webhook по таймеру и сразу после появления новых доставок.
+func (o *Orchestrator) runWebhookSender() {
+	ticker := time.NewTicker(time.Duration(o.config.WebhookCheckIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.webhooksReady:
+		}
+
+		if _, err := o.SendWebhooks(); err != nil {
+			log.Printf("Webhook sender error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/MoodyShoo/go-http-calculator/internal/middleware"
+	"github.com/MoodyShoo/go-http-calculator/internal/models"
+	"github.com/MoodyShoo/go-http-calculator/internal/util"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketHandler открывает сессию WebSocket: клиент отправляет выражения и получает
+// по тому же соединению ответы о приёме и результаты. Протокол описан в README.
+func (o *Orchestrator) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := middleware.GetUserID(r)
+	if !ok {
+		util.SendError(w, "user ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: o.checkHandshake,
+		Handler: func(ws *websocket.Conn) {
+			o.serveWebSocket(ws, userId)
+		},
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+// checkHandshake принимает подключения только со страниц самого сервера или из WS_ALLOWED_ORIGINS,
+// чтобы чужая страница не открыла соединение от имени пользователя. Клиенты вне браузера Origin не передают.
+// Если токен пришёл в Sec-WebSocket-Protocol, сервер выбирает подпротокол bearer: сам токен в ответ не попадает.
+func (o *Orchestrator) checkHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+
+	if origin != nil && origin.Host != r.Host && !o.config.WSAllowedOrigins[origin.Scheme+"://"+origin.Host] {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	config.Origin = origin
+
+	if slices.Contains(config.Protocol, middleware.BearerProtocol) {
+		config.Protocol = []string{middleware.BearerProtocol}
+	} else {
+		config.Protocol = nil
+	}
+
+	return nil
+}
+
+// wsSession - сессия WebSocket. Ответы на сообщения клиента и результаты выражений отправляются
+// из разных горутин, поэтому отправка защищена sendMu.
+type wsSession struct {
+	ws     *websocket.Conn
+	sendMu sync.Mutex
+
+	mu sync.Mutex
+	// Выражения сессии, результаты которых ещё не отправлены
+	watched map[int64]bool
+}
+
+// send отправляет сообщение клиенту.
+func (s *wsSession) send(resp models.WSResponse) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	return websocket.JSON.Send(s.ws, resp)
+}
+
+// watch начинает ждать результат выражения id.
+func (s *wsSession) watch(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watched[id] = true
+}
+
+// finish перестаёт ждать результат выражения id и сообщает, ждала ли его сессия.
+// Результат отправляет тот, кто первым получил true, поэтому клиент получает его один раз.
+func (s *wsSession) finish(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.watched[id] {
+		return false
+	}
+	delete(s.watched, id)
+	return true
+}
+
+// accepts пропускает в подписку сессии только завершения её выражений: остальные события пользователя
+// (например, выражения большого пакета) не занимают буфер подписки.
+func (s *wsSession) accepts(event models.Event) bool {
+	return event.Type == models.EventExpression && isFinished(event.Expression.Status) && s.finish(event.ExpressionId)
+}
+
+// serveWebSocket обрабатывает сообщения клиента и отправляет ему результаты выражений, принятых в этой сессии.
+// Сообщения клиента обрабатываются в этой горутине, а результаты отправляются из отдельной:
+// ожидание o.mu при обработке сообщения не задерживает чтение событий.
+func (o *Orchestrator) serveWebSocket(ws *websocket.Conn, userId int64) {
+	defer ws.Close()
+
+	s := &wsSession{ws: ws, watched: make(map[int64]bool)}
+	sub := o.events.subscribeFunc(userId, s.accepts)
+	defer o.events.unsubscribe(sub)
+
+	go func() {
+		// Канал закрывается в конце сессии или если клиент не успевает читать результаты.
+		// Во втором случае, как и при ошибке отправки, соединение закрывается, чтобы завершилось и чтение
+		defer ws.Close()
+		for event := range sub.events {
+			if err := s.send(wsResult(*event.Expression)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			return
+		}
+
+		resp := o.handleWebSocketMessage(data, userId)
+		if err := s.send(resp); err != nil {
+			return
+		}
+		if resp.Type != models.WSAccepted {
+			continue
+		}
+
+		// Результат ждётся только после ответа о приёме, поэтому всегда приходит позже него.
+		// Выражение могло завершиться раньше, тогда его результат отправляется сразу
+		s.watch(resp.Id)
+
+		o.mu.Lock()
+		exp, err := o.db.ExpressionRepo.GetExpressionByID(resp.Id)
+		o.mu.Unlock()
+		if err != nil || !isFinished(exp.Status) || !s.finish(exp.Id) {
+			continue
+		}
+
+		if err := s.send(wsResult(exp)); err != nil {
+			return
+		}
+	}
+}
+
+// wsResult возвращает сообщение с результатом выражения exp.
+func wsResult(exp models.Expression) models.WSResponse {
+	return models.WSResponse{Type: models.WSResult, Id: exp.Id, Expression: &exp}
+}
+
+// handleWebSocketMessage выполняет запрос клиента и возвращает ответ на него.
+func (o *Orchestrator) handleWebSocketMessage(data []byte, userId int64) models.WSResponse {
+	var req models.WSRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return models.WSResponse{Type: models.WSError, Error: "invalid message"}
+	}
+
+	fail := func(err error) models.WSResponse {
+		return models.WSResponse{Type: models.WSError, RequestId: req.RequestId, Error: err.Error()}
+	}
+
+	if req.Type != models.WSCalculate {
+		return fail(fmt.Errorf("unknown message type %q", req.Type))
+	}
+
+	domain, err := o.validateRequest(req.Request)
+	if err != nil {
+		return fail(err)
+	}
+
+	o.mu.Lock()
+	exp, err := o.handleCalculateRequest(req.Request, domain, userId)
+	o.mu.Unlock()
+	if err != nil {
+		return fail(err)
+	}
+
+	return models.WSResponse{Type: models.WSAccepted, RequestId: req.RequestId, Id: exp.Id, TasksSaved: exp.TasksSaved}
+}
@@ -22,16 +22,26 @@ const (
 )
 
 type Task struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	ExpressionId  int64                  `protobuf:"varint,2,opt,name=expressionId,proto3" json:"expressionId,omitempty"`
-	Arg1          string                 `protobuf:"bytes,3,opt,name=arg1,proto3" json:"arg1,omitempty"`
-	Arg2          string                 `protobuf:"bytes,4,opt,name=arg2,proto3" json:"arg2,omitempty"`
-	Operation     string                 `protobuf:"bytes,5,opt,name=operation,proto3" json:"operation,omitempty"`
-	OperationTime int64                  `protobuf:"varint,6,opt,name=operationTime,proto3" json:"operationTime,omitempty"`
-	Status        string                 `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
-	Result        float64                `protobuf:"fixed64,8,opt,name=result,proto3" json:"result,omitempty"`
-	Error         string                 `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	ExpressionId int64                  `protobuf:"varint,2,opt,name=expressionId,proto3" json:"expressionId,omitempty"`
+	// arg1 и arg2 заполняются для агентов, которые не знают про args
+	//
+	// Deprecated: Marked as deprecated in internal/proto/orchestrator.proto.
+	Arg1 string `protobuf:"bytes,3,opt,name=arg1,proto3" json:"arg1,omitempty"`
+	// Deprecated: Marked as deprecated in internal/proto/orchestrator.proto.
+	Arg2          string   `protobuf:"bytes,4,opt,name=arg2,proto3" json:"arg2,omitempty"`
+	Operation     string   `protobuf:"bytes,5,opt,name=operation,proto3" json:"operation,omitempty"`
+	OperationTime int64    `protobuf:"varint,6,opt,name=operationTime,proto3" json:"operationTime,omitempty"`
+	Status        string   `protobuf:"bytes,7,opt,name=status,proto3" json:"status,omitempty"`
+	Result        float64  `protobuf:"fixed64,8,opt,name=result,proto3" json:"result,omitempty"`
+	Error         string   `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	Lease         int64    `protobuf:"varint,10,opt,name=lease,proto3" json:"lease,omitempty"`
+	Args          []string `protobuf:"bytes,11,rep,name=args,proto3" json:"args,omitempty"`
+	// mode и scale задают режим вычислений (см. calculation.Domain), пустой mode - float64.
+	// В режиме complex аргументы записываются как "1+2i"
+	Mode          string `protobuf:"bytes,12,opt,name=mode,proto3" json:"mode,omitempty"`
+	Scale         int32  `protobuf:"varint,13,opt,name=scale,proto3" json:"scale,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -80,6 +90,7 @@ func (x *Task) GetExpressionId() int64 {
 	return 0
 }
 
+// Deprecated: Marked as deprecated in internal/proto/orchestrator.proto.
 func (x *Task) GetArg1() string {
 	if x != nil {
 		return x.Arg1
@@ -87,6 +98,7 @@ func (x *Task) GetArg1() string {
 	return ""
 }
 
+// Deprecated: Marked as deprecated in internal/proto/orchestrator.proto.
 func (x *Task) GetArg2() string {
 	if x != nil {
 		return x.Arg2
@@ -129,8 +141,37 @@ func (x *Task) GetError() string {
 	return ""
 }
 
+func (x *Task) GetLease() int64 {
+	if x != nil {
+		return x.Lease
+	}
+	return 0
+}
+
+func (x *Task) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *Task) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Task) GetScale() int32 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
 type TaskRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agentId,proto3" json:"agentId,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -165,6 +206,13 @@ func (*TaskRequest) Descriptor() ([]byte, []int) {
 	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{1}
 }
 
+func (x *TaskRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
 type TaskResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Task          *Task                  `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
@@ -210,10 +258,16 @@ func (x *TaskResponse) GetTask() *Task {
 }
 
 type TaskResult struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
-	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Result  float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	Error   string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Lease   int64                  `protobuf:"varint,4,opt,name=lease,proto3" json:"lease,omitempty"`
+	AgentId string                 `protobuf:"bytes,5,opt,name=agentId,proto3" json:"agentId,omitempty"`
+	// value - точный результат строкой, result - его приближение
+	Value string `protobuf:"bytes,6,opt,name=value,proto3" json:"value,omitempty"`
+	// imag - мнимая часть приближения в режиме complex, result - действительная
+	Imag          float64 `protobuf:"fixed64,7,opt,name=imag,proto3" json:"imag,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -269,6 +323,280 @@ func (x *TaskResult) GetError() string {
 	return ""
 }
 
+func (x *TaskResult) GetLease() int64 {
+	if x != nil {
+		return x.Lease
+	}
+	return 0
+}
+
+func (x *TaskResult) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *TaskResult) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *TaskResult) GetImag() float64 {
+	if x != nil {
+		return x.Imag
+	}
+	return 0
+}
+
+type DispatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FreeSlots     int32                  `protobuf:"varint,1,opt,name=freeSlots,proto3" json:"freeSlots,omitempty"`
+	AgentId       string                 `protobuf:"bytes,2,opt,name=agentId,proto3" json:"agentId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DispatchRequest) Reset() {
+	*x = DispatchRequest{}
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DispatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DispatchRequest) ProtoMessage() {}
+
+func (x *DispatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DispatchRequest.ProtoReflect.Descriptor instead.
+func (*DispatchRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DispatchRequest) GetFreeSlots() int32 {
+	if x != nil {
+		return x.FreeSlots
+	}
+	return 0
+}
+
+func (x *DispatchRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+type DispatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*DispatchResponse_Task
+	//	*DispatchResponse_CancelTaskId
+	Payload       isDispatchResponse_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DispatchResponse) Reset() {
+	*x = DispatchResponse{}
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DispatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DispatchResponse) ProtoMessage() {}
+
+func (x *DispatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DispatchResponse.ProtoReflect.Descriptor instead.
+func (*DispatchResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DispatchResponse) GetPayload() isDispatchResponse_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *DispatchResponse) GetTask() *Task {
+	if x != nil {
+		if x, ok := x.Payload.(*DispatchResponse_Task); ok {
+			return x.Task
+		}
+	}
+	return nil
+}
+
+func (x *DispatchResponse) GetCancelTaskId() int64 {
+	if x != nil {
+		if x, ok := x.Payload.(*DispatchResponse_CancelTaskId); ok {
+			return x.CancelTaskId
+		}
+	}
+	return 0
+}
+
+type isDispatchResponse_Payload interface {
+	isDispatchResponse_Payload()
+}
+
+type DispatchResponse_Task struct {
+	Task *Task `protobuf:"bytes,1,opt,name=task,proto3,oneof"`
+}
+
+type DispatchResponse_CancelTaskId struct {
+	CancelTaskId int64 `protobuf:"varint,2,opt,name=cancelTaskId,proto3,oneof"`
+}
+
+func (*DispatchResponse_Task) isDispatchResponse_Payload() {}
+
+func (*DispatchResponse_CancelTaskId) isDispatchResponse_Payload() {}
+
+type AgentInfo struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hostname       string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	ComputingPower int32                  `protobuf:"varint,3,opt,name=computingPower,proto3" json:"computingPower,omitempty"`
+	Version        string                 `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *AgentInfo) Reset() {
+	*x = AgentInfo{}
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentInfo) ProtoMessage() {}
+
+func (x *AgentInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentInfo.ProtoReflect.Descriptor instead.
+func (*AgentInfo) Descriptor() ([]byte, []int) {
+	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AgentInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetComputingPower() int32 {
+	if x != nil {
+		return x.ComputingPower
+	}
+	return 0
+}
+
+func (x *AgentInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+type HeartbeatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       string                 `protobuf:"bytes,1,opt,name=agentId,proto3" json:"agentId,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatRequest) ProtoMessage() {}
+
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *HeartbeatRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
 type SuccessResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
@@ -278,7 +606,7 @@ type SuccessResponse struct {
 
 func (x *SuccessResponse) Reset() {
 	*x = SuccessResponse{}
-	mi := &file_internal_proto_orchestrator_proto_msgTypes[4]
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -290,7 +618,7 @@ func (x *SuccessResponse) String() string {
 func (*SuccessResponse) ProtoMessage() {}
 
 func (x *SuccessResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_proto_orchestrator_proto_msgTypes[4]
+	mi := &file_internal_proto_orchestrator_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -303,7 +631,7 @@ func (x *SuccessResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SuccessResponse.ProtoReflect.Descriptor instead.
 func (*SuccessResponse) Descriptor() ([]byte, []int) {
-	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{4}
+	return file_internal_proto_orchestrator_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *SuccessResponse) GetMessage() string {
@@ -317,31 +645,58 @@ var File_internal_proto_orchestrator_proto protoreflect.FileDescriptor
 
 const file_internal_proto_orchestrator_proto_rawDesc = "" +
 	"\n" +
-	"!internal/proto/orchestrator.proto\x12\forchestrator\"\xec\x01\n" +
+	"!internal/proto/orchestrator.proto\x12\forchestrator\"\xc8\x02\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x12\"\n" +
-	"\fexpressionId\x18\x02 \x01(\x03R\fexpressionId\x12\x12\n" +
-	"\x04arg1\x18\x03 \x01(\tR\x04arg1\x12\x12\n" +
-	"\x04arg2\x18\x04 \x01(\tR\x04arg2\x12\x1c\n" +
+	"\fexpressionId\x18\x02 \x01(\x03R\fexpressionId\x12\x16\n" +
+	"\x04arg1\x18\x03 \x01(\tB\x02\x18\x01R\x04arg1\x12\x16\n" +
+	"\x04arg2\x18\x04 \x01(\tB\x02\x18\x01R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x05 \x01(\tR\toperation\x12$\n" +
 	"\roperationTime\x18\x06 \x01(\x03R\roperationTime\x12\x16\n" +
 	"\x06status\x18\a \x01(\tR\x06status\x12\x16\n" +
 	"\x06result\x18\b \x01(\x01R\x06result\x12\x14\n" +
-	"\x05error\x18\t \x01(\tR\x05error\"\r\n" +
-	"\vTaskRequest\"6\n" +
+	"\x05error\x18\t \x01(\tR\x05error\x12\x14\n" +
+	"\x05lease\x18\n" +
+	" \x01(\x03R\x05lease\x12\x12\n" +
+	"\x04args\x18\v \x03(\tR\x04args\x12\x12\n" +
+	"\x04mode\x18\f \x01(\tR\x04mode\x12\x14\n" +
+	"\x05scale\x18\r \x01(\x05R\x05scale\"'\n" +
+	"\vTaskRequest\x12\x18\n" +
+	"\aagentId\x18\x01 \x01(\tR\aagentId\"6\n" +
 	"\fTaskResponse\x12&\n" +
-	"\x04task\x18\x01 \x01(\v2\x12.orchestrator.TaskR\x04task\"J\n" +
+	"\x04task\x18\x01 \x01(\v2\x12.orchestrator.TaskR\x04task\"\xa4\x01\n" +
 	"\n" +
 	"TaskResult\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
 	"\x06result\x18\x02 \x01(\x01R\x06result\x12\x14\n" +
-	"\x05error\x18\x03 \x01(\tR\x05error\"+\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12\x14\n" +
+	"\x05lease\x18\x04 \x01(\x03R\x05lease\x12\x18\n" +
+	"\aagentId\x18\x05 \x01(\tR\aagentId\x12\x14\n" +
+	"\x05value\x18\x06 \x01(\tR\x05value\x12\x12\n" +
+	"\x04imag\x18\a \x01(\x01R\x04imag\"I\n" +
+	"\x0fDispatchRequest\x12\x1c\n" +
+	"\tfreeSlots\x18\x01 \x01(\x05R\tfreeSlots\x12\x18\n" +
+	"\aagentId\x18\x02 \x01(\tR\aagentId\"m\n" +
+	"\x10DispatchResponse\x12(\n" +
+	"\x04task\x18\x01 \x01(\v2\x12.orchestrator.TaskH\x00R\x04task\x12$\n" +
+	"\fcancelTaskId\x18\x02 \x01(\x03H\x00R\fcancelTaskIdB\t\n" +
+	"\apayload\"y\n" +
+	"\tAgentInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12&\n" +
+	"\x0ecomputingPower\x18\x03 \x01(\x05R\x0ecomputingPower\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\tR\aversion\",\n" +
+	"\x10HeartbeatRequest\x12\x18\n" +
+	"\aagentId\x18\x01 \x01(\tR\aagentId\"+\n" +
 	"\x0fSuccessResponse\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage2\xa0\x01\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage2\xff\x02\n" +
 	"\x13OrchestratorService\x12B\n" +
 	"\tFetchTask\x12\x19.orchestrator.TaskRequest\x1a\x1a.orchestrator.TaskResponse\x12E\n" +
 	"\n" +
-	"SendResult\x12\x18.orchestrator.TaskResult\x1a\x1d.orchestrator.SuccessResponseB0Z.github.com/MoodyShoo/go-http-calculator/proto/b\x06proto3"
+	"SendResult\x12\x18.orchestrator.TaskResult\x1a\x1d.orchestrator.SuccessResponse\x12M\n" +
+	"\bDispatch\x12\x1d.orchestrator.DispatchRequest\x1a\x1e.orchestrator.DispatchResponse(\x010\x01\x12B\n" +
+	"\bRegister\x12\x17.orchestrator.AgentInfo\x1a\x1d.orchestrator.SuccessResponse\x12J\n" +
+	"\tHeartbeat\x12\x1e.orchestrator.HeartbeatRequest\x1a\x1d.orchestrator.SuccessResponseB0Z.github.com/MoodyShoo/go-http-calculator/proto/b\x06proto3"
 
 var (
 	file_internal_proto_orchestrator_proto_rawDescOnce sync.Once
@@ -355,25 +710,36 @@ func file_internal_proto_orchestrator_proto_rawDescGZIP() []byte {
 	return file_internal_proto_orchestrator_proto_rawDescData
 }
 
-var file_internal_proto_orchestrator_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_internal_proto_orchestrator_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_internal_proto_orchestrator_proto_goTypes = []any{
-	(*Task)(nil),            // 0: orchestrator.Task
-	(*TaskRequest)(nil),     // 1: orchestrator.TaskRequest
-	(*TaskResponse)(nil),    // 2: orchestrator.TaskResponse
-	(*TaskResult)(nil),      // 3: orchestrator.TaskResult
-	(*SuccessResponse)(nil), // 4: orchestrator.SuccessResponse
+	(*Task)(nil),             // 0: orchestrator.Task
+	(*TaskRequest)(nil),      // 1: orchestrator.TaskRequest
+	(*TaskResponse)(nil),     // 2: orchestrator.TaskResponse
+	(*TaskResult)(nil),       // 3: orchestrator.TaskResult
+	(*DispatchRequest)(nil),  // 4: orchestrator.DispatchRequest
+	(*DispatchResponse)(nil), // 5: orchestrator.DispatchResponse
+	(*AgentInfo)(nil),        // 6: orchestrator.AgentInfo
+	(*HeartbeatRequest)(nil), // 7: orchestrator.HeartbeatRequest
+	(*SuccessResponse)(nil),  // 8: orchestrator.SuccessResponse
 }
 var file_internal_proto_orchestrator_proto_depIdxs = []int32{
 	0, // 0: orchestrator.TaskResponse.task:type_name -> orchestrator.Task
-	1, // 1: orchestrator.OrchestratorService.FetchTask:input_type -> orchestrator.TaskRequest
-	3, // 2: orchestrator.OrchestratorService.SendResult:input_type -> orchestrator.TaskResult
-	2, // 3: orchestrator.OrchestratorService.FetchTask:output_type -> orchestrator.TaskResponse
-	4, // 4: orchestrator.OrchestratorService.SendResult:output_type -> orchestrator.SuccessResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	0, // 1: orchestrator.DispatchResponse.task:type_name -> orchestrator.Task
+	1, // 2: orchestrator.OrchestratorService.FetchTask:input_type -> orchestrator.TaskRequest
+	3, // 3: orchestrator.OrchestratorService.SendResult:input_type -> orchestrator.TaskResult
+	4, // 4: orchestrator.OrchestratorService.Dispatch:input_type -> orchestrator.DispatchRequest
+	6, // 5: orchestrator.OrchestratorService.Register:input_type -> orchestrator.AgentInfo
+	7, // 6: orchestrator.OrchestratorService.Heartbeat:input_type -> orchestrator.HeartbeatRequest
+	2, // 7: orchestrator.OrchestratorService.FetchTask:output_type -> orchestrator.TaskResponse
+	8, // 8: orchestrator.OrchestratorService.SendResult:output_type -> orchestrator.SuccessResponse
+	5, // 9: orchestrator.OrchestratorService.Dispatch:output_type -> orchestrator.DispatchResponse
+	8, // 10: orchestrator.OrchestratorService.Register:output_type -> orchestrator.SuccessResponse
+	8, // 11: orchestrator.OrchestratorService.Heartbeat:output_type -> orchestrator.SuccessResponse
+	7, // [7:12] is the sub-list for method output_type
+	2, // [2:7] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_internal_proto_orchestrator_proto_init() }
@@ -381,13 +747,17 @@ func file_internal_proto_orchestrator_proto_init() {
 	if File_internal_proto_orchestrator_proto != nil {
 		return
 	}
+	file_internal_proto_orchestrator_proto_msgTypes[5].OneofWrappers = []any{
+		(*DispatchResponse_Task)(nil),
+		(*DispatchResponse_CancelTaskId)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_proto_orchestrator_proto_rawDesc), len(file_internal_proto_orchestrator_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
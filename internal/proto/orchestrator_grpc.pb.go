@@ -21,6 +21,9 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	OrchestratorService_FetchTask_FullMethodName  = "/orchestrator.OrchestratorService/FetchTask"
 	OrchestratorService_SendResult_FullMethodName = "/orchestrator.OrchestratorService/SendResult"
+	OrchestratorService_Dispatch_FullMethodName   = "/orchestrator.OrchestratorService/Dispatch"
+	OrchestratorService_Register_FullMethodName   = "/orchestrator.OrchestratorService/Register"
+	OrchestratorService_Heartbeat_FullMethodName  = "/orchestrator.OrchestratorService/Heartbeat"
 )
 
 // OrchestratorServiceClient is the client API for OrchestratorService service.
@@ -29,6 +32,9 @@ const (
 type OrchestratorServiceClient interface {
 	FetchTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskResponse, error)
 	SendResult(ctx context.Context, in *TaskResult, opts ...grpc.CallOption) (*SuccessResponse, error)
+	Dispatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DispatchRequest, DispatchResponse], error)
+	Register(ctx context.Context, in *AgentInfo, opts ...grpc.CallOption) (*SuccessResponse, error)
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*SuccessResponse, error)
 }
 
 type orchestratorServiceClient struct {
@@ -59,12 +65,48 @@ func (c *orchestratorServiceClient) SendResult(ctx context.Context, in *TaskResu
 	return out, nil
 }
 
+func (c *orchestratorServiceClient) Dispatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DispatchRequest, DispatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrchestratorService_ServiceDesc.Streams[0], OrchestratorService_Dispatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DispatchRequest, DispatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrchestratorService_DispatchClient = grpc.BidiStreamingClient[DispatchRequest, DispatchResponse]
+
+func (c *orchestratorServiceClient) Register(ctx context.Context, in *AgentInfo, opts ...grpc.CallOption) (*SuccessResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SuccessResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*SuccessResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SuccessResponse)
+	err := c.cc.Invoke(ctx, OrchestratorService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // OrchestratorServiceServer is the server API for OrchestratorService service.
 // All implementations must embed UnimplementedOrchestratorServiceServer
 // for forward compatibility.
 type OrchestratorServiceServer interface {
 	FetchTask(context.Context, *TaskRequest) (*TaskResponse, error)
 	SendResult(context.Context, *TaskResult) (*SuccessResponse, error)
+	Dispatch(grpc.BidiStreamingServer[DispatchRequest, DispatchResponse]) error
+	Register(context.Context, *AgentInfo) (*SuccessResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*SuccessResponse, error)
 	mustEmbedUnimplementedOrchestratorServiceServer()
 }
 
@@ -81,6 +123,15 @@ func (UnimplementedOrchestratorServiceServer) FetchTask(context.Context, *TaskRe
 func (UnimplementedOrchestratorServiceServer) SendResult(context.Context, *TaskResult) (*SuccessResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendResult not implemented")
 }
+func (UnimplementedOrchestratorServiceServer) Dispatch(grpc.BidiStreamingServer[DispatchRequest, DispatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Dispatch not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) Register(context.Context, *AgentInfo) (*SuccessResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedOrchestratorServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*SuccessResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
 func (UnimplementedOrchestratorServiceServer) mustEmbedUnimplementedOrchestratorServiceServer() {}
 func (UnimplementedOrchestratorServiceServer) testEmbeddedByValue()                             {}
 
@@ -138,6 +189,49 @@ func _OrchestratorService_SendResult_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OrchestratorService_Dispatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OrchestratorServiceServer).Dispatch(&grpc.GenericServerStream[DispatchRequest, DispatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrchestratorService_DispatchServer = grpc.BidiStreamingServer[DispatchRequest, DispatchResponse]
+
+func _OrchestratorService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).Register(ctx, req.(*AgentInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrchestratorService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrchestratorService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // OrchestratorService_ServiceDesc is the grpc.ServiceDesc for OrchestratorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -153,7 +247,22 @@ var OrchestratorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendResult",
 			Handler:    _OrchestratorService_SendResult_Handler,
 		},
+		{
+			MethodName: "Register",
+			Handler:    _OrchestratorService_Register_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _OrchestratorService_Heartbeat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Dispatch",
+			Handler:       _OrchestratorService_Dispatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/orchestrator.proto",
 }
@@ -0,0 +1,59 @@
+package calculation
+
+// Node - узел дерева разбора выражения.
+type Node interface {
+	// Pos возвращает позицию узла в исходной строке, начиная с 1.
+	Pos() int
+}
+
+// NumberNode - числовой литерал. Value хранит текст числа как он записан в выражении.
+type NumberNode struct {
+	Value    string
+	Position int
+}
+
+// UnaryNode - унарная операция: Negation, Not или постфиксный Factorial.
+type UnaryNode struct {
+	Op       string
+	Operand  Node
+	Position int
+}
+
+// BinaryNode - бинарная операция. Position указывает на оператор.
+type BinaryNode struct {
+	Op       string
+	Left     Node
+	Right    Node
+	Position int
+}
+
+// VariableNode - имя переменной или встроенной константы.
+// Перед вычислением переменные заменяются числами (см. Substitute).
+type VariableNode struct {
+	Name     string
+	Position int
+}
+
+// CallNode - вызов встроенной или пользовательской функции. Position указывает на имя функции.
+// Вызовы пользовательских функций заменяются их телами до вычисления (см. Inline).
+type CallNode struct {
+	Name     string
+	Args     []Node
+	Position int
+}
+
+// IfNode - условное выражение if(cond, then, else). Position указывает на "if".
+// Вычисляется только ветка, выбранная условием.
+type IfNode struct {
+	Cond     Node
+	Then     Node
+	Else     Node
+	Position int
+}
+
+func (n *NumberNode) Pos() int   { return n.Position }
+func (n *UnaryNode) Pos() int    { return n.Position }
+func (n *BinaryNode) Pos() int   { return n.Position }
+func (n *CallNode) Pos() int     { return n.Position }
+func (n *VariableNode) Pos() int { return n.Position }
+func (n *IfNode) Pos() int       { return n.Position }
@@ -2,160 +2,180 @@ package calculation
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"strconv"
-	"strings"
-	"unicode"
 )
 
+// Negation обозначает унарный минус в дереве выражения и в задачах агентов.
+const Negation = "neg"
+
+// Factorial - постфиксный оператор факториала: 5! = 120.
+const Factorial = "!"
+
+// maxFactorial ограничивает аргумент факториала: у 10000! уже больше 35 тысяч цифр.
+const maxFactorial = 10000
+
+// IsOperator проверяет, является ли символ односимвольным оператором.
+// Двухсимвольные операторы ("<=", "&&" и т.п.) распознаёт Lex.
 func IsOperator(r rune) bool {
-	return r == '+' || r == '-' || r == '/' || r == '*'
+	return r == '+' || r == '-' || r == '/' || r == '*' || r == '^' || r == '%' || r == '!' || r == '<' || r == '>'
 }
 
-func IsValidFormula(expression string) bool {
-	prevWasOperator := false
-	stack := 0
-
-	for _, r := range expression {
-		switch {
-		case unicode.IsDigit(r) || r == '.':
-			prevWasOperator = false
-		case r == '(':
-			stack++
-			prevWasOperator = true
-		case r == ')':
-			if stack == 0 {
-				return false
-			}
-			stack--
-			prevWasOperator = false
-		case IsOperator(r):
-			if prevWasOperator {
-				return false
-			}
-			prevWasOperator = true
-		case r == ' ':
-			continue
-		default:
-			return false
-		}
-	}
+// isUnary проверяет, может ли оператор быть префиксным.
+func isUnary(op string) bool {
+	return op == "+" || op == "-" || op == "!"
+}
 
-	return stack == 0 && !prevWasOperator
+// isUnaryOperation проверяет, что операция задачи принимает один аргумент.
+func isUnaryOperation(op string) bool {
+	return op == Negation || op == Factorial || op == Not
+}
+
+// IsValidFormula проверяет, что выражение разбирается без ошибок.
+func IsValidFormula(expression string) bool {
+	_, err := Parse(expression)
+	return err == nil
 }
 
-func applyOperation(numbers_stack *[]float64, operator rune) error {
-	if len(*numbers_stack) < 2 {
-		return errors.New("недостаточно чисел для операции")
+// Apply выполняет одну операцию над уже вычисленными аргументами.
+// Для встроенных функций op - имя функции.
+func Apply(op string, args ...float64) (float64, error) {
+	if fn, ok := LookupFunction(op); ok {
+		if !fn.AcceptsArgs(len(args)) {
+			return 0, fmt.Errorf("function %s expects %s argument(s), got %d", fn.Name, fn.arity(), len(args))
+		}
+		return fn.Call(args...)
 	}
 
-	b := (*numbers_stack)[len(*numbers_stack)-1]
-	a := (*numbers_stack)[len(*numbers_stack)-2]
-	*numbers_stack = (*numbers_stack)[:len(*numbers_stack)-2]
-
-	var result float64
-	switch operator {
-	case '+':
-		result = a + b
-	case '-':
-		result = a - b
-	case '*':
-		result = a * b
-	case '/':
-		if b == 0 {
-			return errors.New("деление на ноль")
+	if isUnaryOperation(op) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("operation %s expects 1 argument, got %d", op, len(args))
+		}
+		switch op {
+		case Factorial:
+			return factorial(args[0])
+		case Not:
+			return boolFloat(args[0] == 0), nil
 		}
-		result = a / b
+		return -args[0], nil
 	}
 
-	*numbers_stack = append(*numbers_stack, result)
-	return nil
-}
+	if len(args) != 2 {
+		return 0, fmt.Errorf("operation %s expects 2 arguments, got %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	switch {
+	case isComparison(op):
+		return boolFloat(compare(op, cmpFloat(a, b))), nil
+	case isLogical(op):
+		return boolFloat(logical(op, a != 0, b != 0)), nil
+	}
 
-func precedence(op rune) int {
 	switch op {
-	case '+', '-':
-		return 1
-	case '*', '/':
-		return 2
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return 0, errors.New("modulo by zero")
+		}
+		return math.Mod(a, b), nil
+	case "^":
+		result := math.Pow(a, b)
+		if math.IsNaN(result) {
+			return 0, fmt.Errorf("%g ^ %g is not a real number", a, b)
+		}
+		if math.IsInf(result, 0) {
+			return 0, fmt.Errorf("%g ^ %g is out of range", a, b)
+		}
+		return result, nil
 	}
-	return 0
-}
 
-func Calc(expression string) (float64, error) {
-	expression = strings.ReplaceAll(expression, "\r", "")
-	expression = strings.ReplaceAll(expression, "\n", "")
-	trimmed := strings.ReplaceAll(expression, " ", "")
+	return 0, fmt.Errorf("unknown operation: %s", op)
+}
 
-	if !IsValidFormula(trimmed) {
-		return 0.0, errors.New("некорректная формула")
+// factorial вычисляет n! для целых n от 0 до 170: 171! уже не помещается в float64.
+func factorial(n float64) (float64, error) {
+	if n < 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("factorial is defined only for non-negative integers, got %g", n)
 	}
 
-	var numbers []float64
-	var operators []rune
-	var buffer []rune
-
-	for _, r := range trimmed {
-		switch {
-		case unicode.IsDigit(r) || r == '.':
-			buffer = append(buffer, r)
-		case IsOperator(r):
-			if len(buffer) > 0 {
-				num, err := strconv.ParseFloat(string(buffer), 64)
-				if err != nil {
-					return 0.0, errors.New("ошибка преобразования числа")
-				}
-				numbers = append(numbers, num)
-				buffer = buffer[:0]
-			}
-			for len(operators) > 0 && precedence(operators[len(operators)-1]) >= precedence(r) {
-				if operators[len(operators)-1] == '(' {
-					break
-				}
-				if err := applyOperation(&numbers, operators[len(operators)-1]); err != nil {
-					return 0.0, err
-				}
-				operators = operators[:len(operators)-1]
-			}
-			operators = append(operators, r)
-		case r == '(':
-			operators = append(operators, r)
-		case r == ')':
-			if len(buffer) > 0 {
-				num, err := strconv.ParseFloat(string(buffer), 64)
-				if err != nil {
-					return 0.0, errors.New("ошибка преобразования числа")
-				}
-				numbers = append(numbers, num)
-				buffer = buffer[:0]
-			}
-			for len(operators) > 0 && operators[len(operators)-1] != '(' {
-				if err := applyOperation(&numbers, operators[len(operators)-1]); err != nil {
-					return 0.0, err
-				}
-				operators = operators[:len(operators)-1]
-			}
-			operators = operators[:len(operators)-1]
+	result := 1.0
+	for i := 2.0; i <= n; i++ {
+		result *= i
+		if math.IsInf(result, 0) {
+			return 0, fmt.Errorf("%g! is out of range", n)
 		}
 	}
+	return result, nil
+}
 
-	if len(buffer) > 0 {
-		num, err := strconv.ParseFloat(string(buffer), 64)
+// Eval вычисляет дерево выражения.
+func Eval(node Node) (float64, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return strconv.ParseFloat(n.Value, 64)
+	case *UnaryNode:
+		operand, err := Eval(n.Operand)
 		if err != nil {
-			return 0.0, errors.New("ошибка преобразования числа")
+			return 0, err
 		}
-		numbers = append(numbers, num)
-	}
-
-	for len(operators) > 0 {
-		if err := applyOperation(&numbers, operators[len(operators)-1]); err != nil {
-			return 0.0, err
+		return Apply(n.Op, operand)
+	case *BinaryNode:
+		left, err := Eval(n.Left)
+		if err != nil {
+			return 0, err
 		}
-		operators = operators[:len(operators)-1]
+		right, err := Eval(n.Right)
+		if err != nil {
+			return 0, err
+		}
+		return Apply(n.Op, left, right)
+	case *VariableNode:
+		if value, ok := LookupConstant(n.Name); ok {
+			return value, nil
+		}
+		return 0, &SyntaxError{Msg: "unknown variable", Token: n.Name, Pos: n.Position}
+	case *IfNode:
+		cond, err := Eval(n.Cond)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return Eval(n.Then)
+		}
+		return Eval(n.Else)
+	case *CallNode:
+		args := make([]float64, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := Eval(arg)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = value
+		}
+		return Apply(n.Name, args...)
 	}
 
-	if len(numbers) != 1 {
-		return 0.0, errors.New("ошибка вычислений")
+	return 0, fmt.Errorf("unsupported node %T", node)
+}
+
+// Calc разбирает и вычисляет выражение.
+func Calc(expression string) (float64, error) {
+	node, err := Parse(expression)
+	if err != nil {
+		return 0, err
 	}
 
-	return numbers[0], nil
+	return Eval(node)
 }
@@ -1,6 +1,8 @@
 package calculation_test
 
 import (
+	"maps"
+	"math"
 	"slices"
 	"testing"
 
@@ -38,6 +40,90 @@ func TestShuntingYard(t *testing.T) {
 			want:       []string{"2", "3", "+", "4", "*"},
 			wantErr:    false,
 		},
+		{
+			name:       "Valid Expression with Negative in Parentheses",
+			expression: "2*(-3)",
+			want:       []string{"2", "-3", "*"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Expression with Minus after Operator",
+			expression: "2*-3",
+			want:       []string{"2", "-3", "*"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Double Minus",
+			expression: "--3",
+			want:       []string{"-3", "neg"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Negated Parentheses",
+			expression: "-(2+3)",
+			want:       []string{"2", "3", "+", "neg"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Unary Plus",
+			expression: "+2-+3",
+			want:       []string{"2", "3", "-"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Right Associative Power",
+			expression: "2^3^2",
+			want:       []string{"2", "3", "2", "^", "^"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Power Before Negation",
+			expression: "-2^2",
+			want:       []string{"2", "2", "^", "neg"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Modulo with Multiplication Priority",
+			expression: "7%4*2+1",
+			want:       []string{"7", "4", "%", "2", "*", "1", "+"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Factorial Before Power And Negation",
+			expression: "-2^3!",
+			want:       []string{"2", "3", "!", "^", "neg"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Comparison And Logic Priority",
+			expression: "1+1 == 2 && !0",
+			want:       []string{"1", "1", "+", "2", "==", "0", "not", "&&"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Not Before Multiplication",
+			expression: "!2*0",
+			want:       []string{"2", "not", "0", "*"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Conditional",
+			expression: "if(1 > 2, 3, 4)",
+			want:       []string{"1", "2", ">", "3", "4", "if/3"},
+			wantErr:    false,
+		},
+		{
+			name:       "Valid Function Calls",
+			expression: "sqrt(16)+max(3,7,2)",
+			want:       []string{"16", "sqrt/1", "3", "7", "2", "max/3", "+"},
+			wantErr:    false,
+		},
+		{
+			name:       "Invalid Expression (Two Binary Operators)",
+			expression: "2*/3",
+			want:       nil,
+			wantErr:    true,
+		},
 		{
 			name:       "Invalid Expression (Mismatched Parentheses)",
 			expression: "2+(3*4",
@@ -67,3 +153,146 @@ func TestShuntingYard(t *testing.T) {
 		})
 	}
 }
+
+func TestCalc(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		want       float64
+		wantErr    bool
+	}{
+		{name: "Simple", expression: "2+2*2", want: 6},
+		{name: "Leading minus", expression: "-5+3", want: -2},
+		{name: "Minus in parentheses", expression: "2*(-3)", want: -6},
+		{name: "Minus after operator", expression: "2*-3", want: -6},
+		{name: "Double minus", expression: "--3", want: 3},
+		{name: "Negated parentheses", expression: "-(2+3)", want: -5},
+		{name: "Unary plus", expression: "+2-+3", want: -1},
+		{name: "Subtract negative", expression: "2 - -3", want: 5},
+		{name: "Power", expression: "2^10", want: 1024},
+		{name: "Power is right associative", expression: "2^3^2", want: 512},
+		{name: "Power binds tighter than minus", expression: "-2^2", want: -4},
+		{name: "Negative exponent", expression: "2^-1", want: 0.5},
+		{name: "Power before multiplication", expression: "3*2^2", want: 12},
+		{name: "Modulo", expression: "7%3", want: 1},
+		{name: "Modulo is left associative", expression: "17%5%2", want: 0},
+		{name: "Modulo with multiplication priority", expression: "2*7%4", want: 2},
+		{name: "Modulo by zero", expression: "5%0", wantErr: true},
+		{name: "Root of negative number", expression: "(-8)^0.5", wantErr: true},
+		{name: "Comparison", expression: "2+3 > 4", want: 1},
+		{name: "Equality", expression: "0.5 * 2 == 1", want: 1},
+		{name: "Not equal after factorial", expression: "3! != 6", want: 0},
+		{name: "And binds tighter than or", expression: "1 || 0 && 0", want: 1},
+		{name: "Logical not", expression: "!0 + !5", want: 1},
+		{name: "Not binds tighter than multiplication", expression: "!2*0", want: 0},
+		{name: "Conditional", expression: "if(12 > 10, 12 * 0.9, 12)", want: 10.8},
+		{name: "Conditional is lazy", expression: "if(0, 1/0, 7)", want: 7},
+		{name: "Nested conditional", expression: "if(1, if(0, 1, 2), 3)", want: 2},
+		{name: "Factorial", expression: "5!", want: 120},
+		{name: "Factorial of zero", expression: "0!", want: 1},
+		{name: "Factorial binds tighter than minus", expression: "-3!", want: -6},
+		{name: "Double factorial application", expression: "3!!", want: 720},
+		{name: "Factorial of fraction", expression: "2.5!", wantErr: true},
+		{name: "Factorial out of range", expression: "171!", wantErr: true},
+		{name: "Functions", expression: "sqrt(16) + max(3, 7, 2) * log(100, 10)", want: 18},
+		{name: "Nested calls", expression: "abs(min(-3, 2) * 2)", want: 6},
+		{name: "Natural log", expression: "log(1)", want: 0},
+		{name: "Trigonometry", expression: "sin(0) + cos(0)", want: 1},
+		{name: "Round", expression: "round(2.5) - round(-2.5)", want: 6},
+		{name: "Negated call", expression: "-sqrt(4)^2", want: -4},
+		{name: "Sqrt of negative number", expression: "sqrt(-1)", wantErr: true},
+		{name: "Log with invalid base", expression: "log(8, 1)", wantErr: true},
+		{name: "Unknown function", expression: "foo(1)", wantErr: true},
+		{name: "Constants", expression: "2*pi + e", want: 2*math.Pi + math.E},
+		{name: "Unknown variable", expression: "2*rate", wantErr: true},
+		{name: "Two binary operators", expression: "2*/3", wantErr: true},
+		{name: "Trailing operator", expression: "2-", wantErr: true},
+		{name: "Division by zero", expression: "1/0", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := calculation.Calc(tc.expression)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Calc() error = %v, wantErr %v", err, tc.wantErr)
+				return
+			}
+
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("Calc() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		vars       map[string]float64
+		want       float64
+		wantUsed   map[string]float64
+		wantErr    string
+	}{
+		{
+			name:       "User variables",
+			expression: "price * (1 + rate)",
+			vars:       map[string]float64{"price": 100, "rate": 0.5, "unused": 1},
+			want:       150,
+			wantUsed:   map[string]float64{"price": 100, "rate": 0.5},
+		},
+		{
+			name:       "Negative value in function call",
+			expression: "abs(-x) + max(x, 1)",
+			vars:       map[string]float64{"x": -3},
+			want:       4,
+			wantUsed:   map[string]float64{"x": -3},
+		},
+		{
+			name:       "Constant is not recorded",
+			expression: "r * pi",
+			vars:       map[string]float64{"r": 2},
+			want:       2 * math.Pi,
+			wantUsed:   map[string]float64{"r": 2},
+		},
+		{
+			name:       "Unknown variable",
+			expression: "1 + rate",
+			vars:       map[string]float64{},
+			wantErr:    "unknown variable 'rate' at position 5",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := calculation.Parse(tc.expression)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			node, used, err := calculation.Substitute(node, tc.vars)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("Substitute() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Substitute() error = %v", err)
+			}
+
+			got, err := calculation.Eval(node)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Eval() = %v, want %v", got, tc.want)
+			}
+
+			if !maps.Equal(used, tc.wantUsed) {
+				t.Errorf("Substitute() used = %v, want %v", used, tc.wantUsed)
+			}
+		})
+	}
+}
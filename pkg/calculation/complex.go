@@ -0,0 +1,204 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// Комплексная арифметика на complex128 для режима complex.
+// Числа записываются как в Go без скобок: "1+2i", "-2i", "3".
+
+// ImaginaryUnit - имя мнимой единицы в выражении.
+const ImaginaryUnit = "i"
+
+// isImaginary проверяет, записано ли число с мнимой частью ("2i", "1+2i").
+func isImaginary(value string) bool {
+	return strings.HasSuffix(value, ImaginaryUnit)
+}
+
+func parseComplex(value string) (complex128, error) {
+	c, err := strconv.ParseComplex(value, 128)
+	if err != nil {
+		return 0, fmt.Errorf("invalid complex number: %s", value)
+	}
+	return c, nil
+}
+
+// formatComplex записывает число без потери точности. Нулевая мнимая или действительная часть не пишется.
+func formatComplex(c complex128) string {
+	re, im := real(c), imag(c)
+	switch {
+	case im == 0 && re == 0:
+		return "0"
+	case im == 0:
+		return formatNumber(re)
+	case re == 0:
+		return formatNumber(im) + ImaginaryUnit
+	}
+
+	s := strconv.FormatComplex(c, 'g', -1, 128)
+	return strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+}
+
+// applyComplex выполняет операцию в режиме complex.
+func applyComplex(op string, args []string) (string, error) {
+	values := make([]complex128, len(args))
+	for i, arg := range args {
+		value, err := parseComplex(arg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+
+	result, err := complexOperation(op, values)
+	if err != nil {
+		return "", err
+	}
+
+	if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+		return "", fmt.Errorf("%s result is not a finite number", op)
+	}
+
+	return formatComplex(result), nil
+}
+
+func complexOperation(op string, args []complex128) (complex128, error) {
+	if fn, ok := LookupFunction(op); ok {
+		if !fn.AcceptsArgs(len(args)) {
+			return 0, fmt.Errorf("function %s expects %s argument(s), got %d", fn.Name, fn.arity(), len(args))
+		}
+		return complexFunction(fn, args)
+	}
+
+	if isUnaryOperation(op) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("operation %s expects 1 argument, got %d", op, len(args))
+		}
+		switch op {
+		case Factorial:
+			if imag(args[0]) != 0 {
+				return 0, errors.New("factorial is not defined for complex numbers")
+			}
+			result, err := factorial(real(args[0]))
+			return complex(result, 0), err
+		case Not:
+			return complex(boolFloat(args[0] == 0), 0), nil
+		}
+		return -args[0], nil
+	}
+
+	if len(args) != 2 {
+		return 0, fmt.Errorf("operation %s expects 2 arguments, got %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	switch op {
+	case "==":
+		return complex(boolFloat(a == b), 0), nil
+	case "!=":
+		return complex(boolFloat(a != b), 0), nil
+	case "<", "<=", ">", ">=":
+		// Комплексные числа не упорядочены, сравнивать можно только числа без мнимой части
+		if imag(a) != 0 || imag(b) != 0 {
+			return 0, fmt.Errorf("complex numbers can't be compared with %s", op)
+		}
+		return complex(boolFloat(compare(op, cmpFloat(real(a), real(b)))), 0), nil
+	case "&&", "||":
+		return complex(boolFloat(logical(op, a != 0, b != 0)), 0), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if imag(a) != 0 || imag(b) != 0 {
+			return 0, errors.New("modulo is not defined for complex numbers")
+		}
+		if b == 0 {
+			return 0, errors.New("modulo by zero")
+		}
+		return complex(math.Mod(real(a), real(b)), 0), nil
+	case "^":
+		return complexPow(a, b)
+	}
+
+	return 0, fmt.Errorf("unknown operation: %s", op)
+}
+
+// complexPow возводит в степень. Целые степени считаются умножением,
+// чтобы i^2 давало ровно -1, а не -1+1.2246467991473532e-16i, как cmplx.Pow.
+func complexPow(base, exponent complex128) (complex128, error) {
+	e := real(exponent)
+	if base == 0 && e < 0 {
+		return 0, errors.New("division by zero")
+	}
+
+	if imag(exponent) != 0 || e != math.Trunc(e) || math.Abs(e) > maxExactExponent {
+		return cmplx.Pow(base, exponent), nil
+	}
+
+	result := complex(1, 0)
+	power := base
+	for n := int64(math.Abs(e)); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= power
+		}
+		power *= power
+	}
+
+	if e < 0 {
+		return 1 / result, nil
+	}
+	return result, nil
+}
+
+// complexFunction вычисляет встроенную функцию. sqrt, sin, cos и log определены для любых чисел,
+// abs возвращает модуль, а min, max и round принимают только числа без мнимой части.
+func complexFunction(fn Function, args []complex128) (complex128, error) {
+	switch fn.Name {
+	case "sqrt":
+		return cmplx.Sqrt(args[0]), nil
+	case "sin":
+		return cmplx.Sin(args[0]), nil
+	case "cos":
+		return cmplx.Cos(args[0]), nil
+	case "log":
+		if args[0] == 0 {
+			return 0, errors.New("log of zero")
+		}
+		if len(args) == 1 {
+			return cmplx.Log(args[0]), nil
+		}
+		if args[1] == 0 || args[1] == 1 {
+			return 0, fmt.Errorf("invalid log base: %s", formatComplex(args[1]))
+		}
+		return cmplx.Log(args[0]) / cmplx.Log(args[1]), nil
+	case "abs":
+		return complex(cmplx.Abs(args[0]), 0), nil
+	}
+
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		if imag(arg) != 0 {
+			return 0, fmt.Errorf("function %s is not defined for complex numbers", fn.Name)
+		}
+		values[i] = real(arg)
+	}
+
+	result, err := fn.Call(values...)
+	if err != nil {
+		return 0, err
+	}
+	return complex(result, 0), nil
+}
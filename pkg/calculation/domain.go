@@ -0,0 +1,307 @@
+package calculation
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Mode - режим вычислений. Пустой режим - обычные float64.
+type Mode string
+
+const (
+	ModeFloat    Mode = ""
+	ModeDecimal  Mode = "decimal"
+	ModeRational Mode = "rational"
+	ModeComplex  Mode = "complex"
+	ModeInteger  Mode = "integer"
+)
+
+const (
+	// DefaultScale - число знаков после запятой в режиме decimal, если оно не указано.
+	DefaultScale = 28
+	// MaxScale ограничивает число знаков после запятой, чтобы одна задача не считалась бесконечно.
+	MaxScale = 1000
+)
+
+// Domain задаёт, в каком режиме вычисляется выражение.
+// Значения передаются между задачами в виде строк, чтобы не терять точность.
+type Domain struct {
+	Mode  Mode
+	Scale int
+}
+
+// NewDomain проверяет режим и точность из запроса. Scale = nil означает точность по умолчанию.
+func NewDomain(mode string, scale *int) (Domain, error) {
+	switch Mode(mode) {
+	case ModeFloat, "float":
+		if scale != nil {
+			return Domain{}, fmt.Errorf("scale is only supported in %s mode", ModeDecimal)
+		}
+		return Domain{Mode: ModeFloat}, nil
+	case ModeDecimal:
+		d := Domain{Mode: ModeDecimal, Scale: DefaultScale}
+		if scale != nil {
+			if *scale < 0 || *scale > MaxScale {
+				return Domain{}, fmt.Errorf("scale must be between 0 and %d", MaxScale)
+			}
+			d.Scale = *scale
+		}
+		return d, nil
+	case ModeRational:
+		if scale != nil {
+			return Domain{}, fmt.Errorf("scale is only supported in %s mode", ModeDecimal)
+		}
+		return Domain{Mode: ModeRational}, nil
+	case ModeComplex:
+		if scale != nil {
+			return Domain{}, fmt.Errorf("scale is only supported in %s mode", ModeDecimal)
+		}
+		return Domain{Mode: ModeComplex}, nil
+	case ModeInteger:
+		if scale != nil {
+			return Domain{}, fmt.Errorf("scale is only supported in %s mode", ModeDecimal)
+		}
+		return Domain{Mode: ModeInteger}, nil
+	}
+
+	return Domain{}, fmt.Errorf("unknown mode: %s", mode)
+}
+
+// Apply выполняет операцию над аргументами, записанными строками, и возвращает результат строкой.
+func (d Domain) Apply(op string, args ...string) (string, error) {
+	switch d.Mode {
+	case ModeFloat:
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			value, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return "", fmt.Errorf("invalid number: %s", arg)
+			}
+			values[i] = value
+		}
+
+		result, err := Apply(op, values...)
+		if err != nil {
+			return "", err
+		}
+		return formatNumber(result), nil
+	case ModeDecimal, ModeRational:
+		return d.applyExact(op, args)
+	case ModeComplex:
+		return applyComplex(op, args)
+	case ModeInteger:
+		return applyInteger(op, args)
+	}
+
+	return "", fmt.Errorf("unknown mode: %s", d.Mode)
+}
+
+// Normalize приводит число к записи, в которой его возвращает Apply (например, округляет до Scale знаков).
+func (d Domain) Normalize(value string) (string, error) {
+	if d.Mode != ModeComplex && isImaginary(value) {
+		return "", fmt.Errorf("imaginary numbers require %s mode", ModeComplex)
+	}
+
+	switch d.Mode {
+	case ModeFloat:
+		result, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number: %s", value)
+		}
+		return formatNumber(result), nil
+	case ModeDecimal, ModeRational:
+		r, err := d.parseExact(value)
+		if err != nil {
+			return "", err
+		}
+		return d.formatExact(r), nil
+	case ModeComplex:
+		c, err := parseComplex(value)
+		if err != nil {
+			return "", err
+		}
+		return formatComplex(c), nil
+	case ModeInteger:
+		n, err := parseInteger(value)
+		if err != nil {
+			return "", err
+		}
+		return n.String(), nil
+	}
+
+	return "", fmt.Errorf("unknown mode: %s", d.Mode)
+}
+
+// Float возвращает приближённое значение числа для клиентов, которые ждут float64.
+// Для комплексных чисел это действительная часть.
+func (d Domain) Float(value string) float64 {
+	switch d.Mode {
+	case ModeRational:
+		r, err := parseRational(value)
+		if err != nil {
+			return 0
+		}
+		result, _ := r.Float64()
+		return result
+	case ModeComplex:
+		c, _ := parseComplex(value)
+		return real(c)
+	case ModeInteger:
+		n, err := parseInteger(value)
+		if err != nil {
+			return 0
+		}
+		result, _ := new(big.Float).SetInt(n).Float64()
+		return result
+	}
+
+	result, _ := strconv.ParseFloat(value, 64)
+	return result
+}
+
+// Imag возвращает мнимую часть числа. Вне режима complex она всегда равна нулю.
+func (d Domain) Imag(value string) float64 {
+	if d.Mode != ModeComplex {
+		return 0
+	}
+
+	c, _ := parseComplex(value)
+	return imag(c)
+}
+
+// Truth проверяет, истинно ли значение: истиной считается любое ненулевое число.
+func (d Domain) Truth(value string) (bool, error) {
+	switch d.Mode {
+	case ModeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid number: %s", value)
+		}
+		return f != 0, nil
+	case ModeDecimal, ModeRational:
+		r, err := d.parseExact(value)
+		if err != nil {
+			return false, err
+		}
+		return r.Sign() != 0, nil
+	case ModeInteger:
+		n, err := parseInteger(value)
+		if err != nil {
+			return false, err
+		}
+		return n.Sign() != 0, nil
+	case ModeComplex:
+		c, err := parseComplex(value)
+		if err != nil {
+			return false, err
+		}
+		return c != 0, nil
+	}
+
+	return false, fmt.Errorf("unknown mode: %s", d.Mode)
+}
+
+// Eval вычисляет дерево выражения в режиме d. Переменные должны быть уже подставлены.
+func (d Domain) Eval(node Node) (string, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return d.number(n)
+	case *VariableNode:
+		if number, ok := constantNode(n); ok {
+			return d.number(number)
+		}
+		return "", &SyntaxError{Msg: "unknown variable", Token: n.Name, Pos: n.Position}
+	case *UnaryNode:
+		operand, err := d.Eval(n.Operand)
+		if err != nil {
+			return "", err
+		}
+		return d.Apply(n.Op, operand)
+	case *BinaryNode:
+		left, err := d.Eval(n.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := d.Eval(n.Right)
+		if err != nil {
+			return "", err
+		}
+		return d.Apply(n.Op, left, right)
+	case *CallNode:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := d.Eval(arg)
+			if err != nil {
+				return "", err
+			}
+			args[i] = value
+		}
+		return d.Apply(n.Name, args...)
+	case *IfNode:
+		cond, err := d.Eval(n.Cond)
+		if err != nil {
+			return "", err
+		}
+		truth, err := d.Truth(cond)
+		if err != nil {
+			return "", err
+		}
+		if truth {
+			return d.Eval(n.Then)
+		}
+		return d.Eval(n.Else)
+	}
+
+	return "", fmt.Errorf("unsupported node %T", node)
+}
+
+// Check проверяет, что все числа в дереве допустимы в режиме d (например, мнимые числа - только в режиме complex).
+// Ошибка возвращается как *SyntaxError с позицией числа.
+func (d Domain) Check(node Node) error {
+	switch n := node.(type) {
+	case *NumberNode:
+		_, err := d.number(n)
+		return err
+	case *UnaryNode:
+		return d.Check(n.Operand)
+	case *BinaryNode:
+		if err := d.Check(n.Left); err != nil {
+			return err
+		}
+		return d.Check(n.Right)
+	case *CallNode:
+		for _, arg := range n.Args {
+			if err := d.Check(arg); err != nil {
+				return err
+			}
+		}
+	case *IfNode:
+		for _, branch := range []Node{n.Cond, n.Then, n.Else} {
+			if err := d.Check(branch); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// number приводит числовой литерал к записи режима d.
+func (d Domain) number(n *NumberNode) (string, error) {
+	value, err := d.Normalize(n.Value)
+	if err != nil {
+		return "", &SyntaxError{Msg: err.Error(), Pos: n.Position}
+	}
+	return value, nil
+}
+
+// Calc разбирает и вычисляет выражение в режиме d.
+func (d Domain) Calc(expression string) (string, error) {
+	node, err := Parse(expression)
+	if err != nil {
+		return "", err
+	}
+
+	return d.Eval(node)
+}
@@ -0,0 +1,141 @@
+package calculation_test
+
+import (
+	"testing"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+func TestDomainCalc(t *testing.T) {
+	decimal := calculation.Domain{Mode: calculation.ModeDecimal, Scale: calculation.DefaultScale}
+	rational := calculation.Domain{Mode: calculation.ModeRational}
+	complexDomain := calculation.Domain{Mode: calculation.ModeComplex}
+	integer := calculation.Domain{Mode: calculation.ModeInteger}
+
+	cases := []struct {
+		name       string
+		domain     calculation.Domain
+		expression string
+		want       string
+		wantErr    bool
+	}{
+		{name: "Float keeps all digits", domain: calculation.Domain{}, expression: "1/3", want: "0.3333333333333333"},
+		{name: "Float sum", domain: calculation.Domain{}, expression: "0.1+0.2", want: "0.30000000000000004"},
+		{name: "Decimal sum is exact", domain: decimal, expression: "0.1+0.2", want: "0.3"},
+		{name: "Decimal division is rounded to scale", domain: decimal, expression: "1/3", want: "0.3333333333333333333333333333"},
+		{name: "Decimal rounds half away from zero", domain: calculation.Domain{Mode: calculation.ModeDecimal, Scale: 2}, expression: "-2/3", want: "-0.67"},
+		{name: "Decimal scale zero", domain: calculation.Domain{Mode: calculation.ModeDecimal, Scale: 0}, expression: "7/2", want: "4"},
+		{name: "Decimal trailing zeros", domain: decimal, expression: "10.50*2", want: "21"},
+		{name: "Decimal large power", domain: decimal, expression: "2^64", want: "18446744073709551616"},
+		{name: "Decimal negative power", domain: decimal, expression: "2^-2", want: "0.25"},
+		{name: "Decimal modulo", domain: decimal, expression: "-7.5%2", want: "-1.5"},
+		{name: "Decimal sqrt", domain: decimal, expression: "sqrt(2)", want: "1.4142135623730950488016887242"},
+		{name: "Decimal min and max", domain: decimal, expression: "max(0.1, 0.3) - min(0.2, 0.1)", want: "0.2"},
+		{name: "Decimal round", domain: decimal, expression: "round(2.5) + round(-2.5)", want: "0"},
+		{name: "Decimal fractional exponent", domain: decimal, expression: "2^0.5", wantErr: true},
+		{name: "Decimal division by zero", domain: decimal, expression: "1/0", wantErr: true},
+		{name: "Decimal modulo by zero", domain: decimal, expression: "1%0", wantErr: true},
+		{name: "Decimal huge exponent", domain: decimal, expression: "10^100000", wantErr: true},
+		{name: "Decimal huge power", domain: decimal, expression: "(2^10000)^10000", wantErr: true},
+		{name: "Rational sum", domain: rational, expression: "1/3 + 1/6", want: "1/2"},
+		{name: "Rational integer result", domain: rational, expression: "1/3 * 3", want: "1"},
+		{name: "Rational decimal literal", domain: rational, expression: "0.25 + 1", want: "5/4"},
+		{name: "Rational negative", domain: rational, expression: "-1/3 - 1/3", want: "-2/3"},
+		{name: "Rational power", domain: rational, expression: "(2/3)^-2", want: "9/4"},
+		{name: "Rational modulo", domain: rational, expression: "(7/2) % 1", want: "1/2"},
+		{name: "Rational sqrt of square", domain: rational, expression: "sqrt(4/9)", want: "2/3"},
+		{name: "Rational sqrt of non-square", domain: rational, expression: "sqrt(2)", wantErr: true},
+		{name: "Rational trigonometry", domain: rational, expression: "sin(1)", wantErr: true},
+		{name: "Rational fractional exponent", domain: rational, expression: "4^(1/2)", wantErr: true},
+		{name: "Rational division by zero", domain: rational, expression: "1/(1/2 - 1/2)", wantErr: true},
+		{name: "Rational huge denominator", domain: rational, expression: "(1/2^10000)^200", wantErr: true},
+		{name: "Complex sqrt of negative", domain: complexDomain, expression: "sqrt(-4)", want: "2i"},
+		{name: "Complex multiplication", domain: complexDomain, expression: "(1+2i)*(3-i)", want: "5+5i"},
+		{name: "Complex division", domain: complexDomain, expression: "1/(2i)", want: "-0.5i"},
+		{name: "Complex integer power is exact", domain: complexDomain, expression: "i^2", want: "-1"},
+		{name: "Complex negative imaginary part", domain: complexDomain, expression: "-i", want: "-1i"},
+		{name: "Complex abs", domain: complexDomain, expression: "abs(3-4i)", want: "5"},
+		{name: "Complex log of negative", domain: complexDomain, expression: "log(-1)", want: "3.141592653589793i"},
+		{name: "Complex real result", domain: complexDomain, expression: "max(1, 2) % 2", want: "0"},
+		{name: "Complex min", domain: complexDomain, expression: "min(1, i)", wantErr: true},
+		{name: "Complex modulo", domain: complexDomain, expression: "i % 2", wantErr: true},
+		{name: "Complex division by zero", domain: complexDomain, expression: "i/0", wantErr: true},
+		{name: "Integer division truncates", domain: integer, expression: "7/2", want: "3"},
+		{name: "Integer division of negative", domain: integer, expression: "-7/2", want: "-3"},
+		{name: "Integer remainder", domain: integer, expression: "-7%2", want: "-1"},
+		{name: "Integer large power", domain: integer, expression: "2^64", want: "18446744073709551616"},
+		{name: "Integer factorial", domain: integer, expression: "25!", want: "15511210043330985984000000"},
+		{name: "Integer sqrt", domain: integer, expression: "sqrt(10)", want: "3"},
+		{name: "Integer min and max", domain: integer, expression: "max(1, 5, 3) - min(4, -2)", want: "7"},
+		{name: "Integer fraction literal", domain: integer, expression: "1.5 * 2", wantErr: true},
+		{name: "Integer negative exponent", domain: integer, expression: "2^-1", wantErr: true},
+		{name: "Integer huge power", domain: integer, expression: "((2^10000)^10000)^10000", wantErr: true},
+		{name: "Integer negative factorial", domain: integer, expression: "(0-1)!", wantErr: true},
+		{name: "Integer division by zero", domain: integer, expression: "1/0", wantErr: true},
+		{name: "Integer trigonometry", domain: integer, expression: "sin(1)", wantErr: true},
+		{name: "Decimal factorial", domain: decimal, expression: "30!", want: "265252859812191058636308480000000"},
+		{name: "Integer comparison of large numbers", domain: integer, expression: "2^64 + 1 > 2^64", want: "1"},
+		{name: "Rational conditional", domain: rational, expression: "if(1/3 + 1/6 == 1/2, 1/3, 0)", want: "1/3"},
+		{name: "Decimal comparison is exact", domain: decimal, expression: "0.1 + 0.2 == 0.3", want: "1"},
+		{name: "Complex equality", domain: complexDomain, expression: "i^2 == -1", want: "1"},
+		{name: "Complex ordering", domain: complexDomain, expression: "i < 1", wantErr: true},
+		{name: "Imaginary number in float mode", domain: calculation.Domain{}, expression: "1+2i", wantErr: true},
+		{name: "Imaginary unit in decimal mode", domain: decimal, expression: "i", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.domain.Calc(tc.expression)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Calc() error = %v, wantErr %v", err, tc.wantErr)
+				return
+			}
+
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("Calc() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewDomain(t *testing.T) {
+	scale := func(n int) *int { return &n }
+
+	cases := []struct {
+		name    string
+		mode    string
+		scale   *int
+		want    calculation.Domain
+		wantErr bool
+	}{
+		{name: "Default", mode: "", want: calculation.Domain{}},
+		{name: "Explicit float", mode: "float", want: calculation.Domain{}},
+		{name: "Decimal with default scale", mode: "decimal", want: calculation.Domain{Mode: calculation.ModeDecimal, Scale: calculation.DefaultScale}},
+		{name: "Decimal with scale", mode: "decimal", scale: scale(2), want: calculation.Domain{Mode: calculation.ModeDecimal, Scale: 2}},
+		{name: "Rational", mode: "rational", want: calculation.Domain{Mode: calculation.ModeRational}},
+		{name: "Complex", mode: "complex", want: calculation.Domain{Mode: calculation.ModeComplex}},
+		{name: "Scale in complex mode", mode: "complex", scale: scale(2), wantErr: true},
+		{name: "Integer", mode: "integer", want: calculation.Domain{Mode: calculation.ModeInteger}},
+		{name: "Scale in integer mode", mode: "integer", scale: scale(0), wantErr: true},
+		{name: "Scale in rational mode", mode: "rational", scale: scale(2), wantErr: true},
+		{name: "Scale too large", mode: "decimal", scale: scale(calculation.MaxScale + 1), wantErr: true},
+		{name: "Scale in float mode", mode: "", scale: scale(2), wantErr: true},
+		{name: "Unknown mode", mode: "exact", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := calculation.NewDomain(tc.mode, tc.scale)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("NewDomain() error = %v, wantErr %v", err, tc.wantErr)
+				return
+			}
+
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("NewDomain() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
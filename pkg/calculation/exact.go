@@ -0,0 +1,266 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Точная арифметика на big.Rat для режимов decimal и rational.
+// Режимы отличаются записью чисел: decimal округляет результат до Scale знаков после запятой,
+// rational хранит обыкновенную дробь без округления.
+
+// maxExactExponent ограничивает показатель степени, чтобы 10^1000000000 не занял всю память.
+const maxExactExponent = 10000
+
+// maxExactBits ограничивает размер результата степени: показатель ограничен и так,
+// но ((2^10000)^10000)^10000 всё равно занял бы терабайты и не поместился бы в ответ агента.
+const maxExactBits = 1 << 20
+
+// checkPowSize проверяет, что base^exponent займёт не больше maxExactBits бит.
+// |exponent| не больше maxExactExponent, поэтому произведение не переполняется.
+func checkPowSize(base, exponent *big.Int) error {
+	if int64(base.BitLen())*new(big.Int).Abs(exponent).Int64() > maxExactBits {
+		return errors.New("result of power is too large")
+	}
+	return nil
+}
+
+// parseDecimal читает десятичное число без потери точности.
+func parseDecimal(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok || strings.Contains(value, "/") {
+		return nil, fmt.Errorf("invalid decimal: %s", value)
+	}
+	return r, nil
+}
+
+// formatDecimal округляет число до scale знаков после запятой (половины - от нуля)
+// и убирает незначащие нули.
+func formatDecimal(r *big.Rat, scale int) string {
+	s := r.FloatString(scale)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// parseRational читает обыкновенную дробь ("1/3") или десятичное число ("0.5").
+func parseRational(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid rational: %s", value)
+	}
+	return r, nil
+}
+
+// parseExact читает число в записи режима d.
+func (d Domain) parseExact(value string) (*big.Rat, error) {
+	if d.Mode == ModeRational {
+		return parseRational(value)
+	}
+	return parseDecimal(value)
+}
+
+// formatExact записывает число в режиме d: decimal округляет до Scale знаков, rational пишет дробь.
+func (d Domain) formatExact(r *big.Rat) string {
+	if d.Mode == ModeRational {
+		return r.RatString()
+	}
+	return formatDecimal(r, d.Scale)
+}
+
+// applyExact выполняет операцию в режимах decimal и rational. Сложение, вычитание, умножение и деление точные,
+// в режиме decimal результат каждой операции округляется до Scale знаков после запятой.
+func (d Domain) applyExact(op string, args []string) (string, error) {
+	values := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		value, err := d.parseExact(arg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+
+	result, err := d.exactOperation(op, values)
+	if err != nil {
+		return "", err
+	}
+
+	return d.formatExact(result), nil
+}
+
+func (d Domain) exactOperation(op string, args []*big.Rat) (*big.Rat, error) {
+	if fn, ok := LookupFunction(op); ok {
+		if !fn.AcceptsArgs(len(args)) {
+			return nil, fmt.Errorf("function %s expects %s argument(s), got %d", fn.Name, fn.arity(), len(args))
+		}
+		return d.exactFunction(fn, args)
+	}
+
+	if isUnaryOperation(op) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("operation %s expects 1 argument, got %d", op, len(args))
+		}
+		switch op {
+		case Not:
+			return boolRat(args[0].Sign() == 0), nil
+		case Factorial:
+			if !args[0].IsInt() {
+				return nil, fmt.Errorf("factorial is defined only for non-negative integers, got %s", d.formatExact(args[0]))
+			}
+			result, err := bigFactorial(args[0].Num())
+			if err != nil {
+				return nil, err
+			}
+			return new(big.Rat).SetInt(result), nil
+		}
+		return new(big.Rat).Neg(args[0]), nil
+	}
+
+	if len(args) != 2 {
+		return nil, fmt.Errorf("operation %s expects 2 arguments, got %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	switch {
+	case isComparison(op):
+		return boolRat(compare(op, a.Cmp(b))), nil
+	case isLogical(op):
+		return boolRat(logical(op, a.Sign() != 0, b.Sign() != 0)), nil
+	}
+
+	switch op {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		return new(big.Rat).Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return new(big.Rat).Quo(a, b), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		// Остаток со знаком делимого, как у math.Mod: a - b*trunc(a/b)
+		quo := new(big.Rat).Quo(a, b)
+		trunc := new(big.Int).Quo(quo.Num(), quo.Denom())
+		return new(big.Rat).Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(trunc))), nil
+	case "^":
+		return d.exactPow(a, b)
+	}
+
+	return nil, fmt.Errorf("unknown operation: %s", op)
+}
+
+func boolRat(b bool) *big.Rat {
+	return new(big.Rat).SetFloat64(boolFloat(b))
+}
+
+// exactPow возводит в целую степень без потери точности.
+func (d Domain) exactPow(base, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() {
+		return nil, fmt.Errorf("%s mode supports only integer exponents", d.Mode)
+	}
+
+	e := exponent.Num()
+	if e.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		return nil, fmt.Errorf("exponent %s is too large", e)
+	}
+
+	if base.Sign() == 0 && e.Sign() < 0 {
+		return nil, errors.New("division by zero")
+	}
+
+	if err := checkPowSize(base.Num(), e); err != nil {
+		return nil, err
+	}
+	if err := checkPowSize(base.Denom(), e); err != nil {
+		return nil, err
+	}
+
+	abs := new(big.Int).Abs(e)
+	num := new(big.Int).Exp(base.Num(), abs, nil)
+	den := new(big.Int).Exp(base.Denom(), abs, nil)
+	if e.Sign() < 0 {
+		num, den = den, num
+	}
+
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// exactFunction вычисляет встроенную функцию. abs, min, max и round считаются точно.
+// В режиме decimal sqrt считается с точностью Scale знаков, а остальные функции - через float64.
+// В режиме rational допустимы только точные результаты: sqrt - для квадратов дробей, остальные функции недоступны.
+func (d Domain) exactFunction(fn Function, args []*big.Rat) (*big.Rat, error) {
+	switch fn.Name {
+	case "abs":
+		return new(big.Rat).Abs(args[0]), nil
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if (fn.Name == "min" && arg.Cmp(result) < 0) || (fn.Name == "max" && arg.Cmp(result) > 0) {
+				result = arg
+			}
+		}
+		return new(big.Rat).Set(result), nil
+	case "round":
+		rounded, _ := new(big.Rat).SetString(args[0].FloatString(0))
+		return rounded, nil
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return nil, errors.New("sqrt of negative number")
+		}
+		if d.Mode == ModeRational {
+			return rationalSqrt(args[0])
+		}
+		// Запас точности, чтобы после округления до scale знаков все они были верными
+		prec := uint(d.Scale)*4 + 64
+		root := new(big.Float).SetPrec(prec).SetRat(args[0])
+		root.Sqrt(root)
+		result, _ := root.Rat(nil)
+		return result, nil
+	}
+
+	if d.Mode == ModeRational {
+		return nil, fmt.Errorf("function %s is not supported in %s mode", fn.Name, d.Mode)
+	}
+
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		values[i], _ = arg.Float64()
+	}
+
+	result, err := fn.Call(values...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(big.Rat).SetFloat64(result)
+	if r == nil {
+		return nil, fmt.Errorf("%s result is not a finite number", fn.Name)
+	}
+	return r, nil
+}
+
+// rationalSqrt извлекает корень из дроби, если числитель и знаменатель - точные квадраты.
+func rationalSqrt(r *big.Rat) (*big.Rat, error) {
+	num := new(big.Int).Sqrt(r.Num())
+	den := new(big.Int).Sqrt(r.Denom())
+
+	if new(big.Int).Mul(num, num).Cmp(r.Num()) != 0 || new(big.Int).Mul(den, den).Cmp(r.Denom()) != 0 {
+		return nil, fmt.Errorf("sqrt of %s is not rational", r.RatString())
+	}
+
+	return new(big.Rat).SetFrac(num, den), nil
+}
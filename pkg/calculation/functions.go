@@ -0,0 +1,107 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Function - встроенная функция, которую можно вызвать в выражении.
+// MaxArgs = -1 означает произвольное число аргументов (не меньше MinArgs).
+type Function struct {
+	Name    string
+	MinArgs int
+	MaxArgs int
+	Call    func(args ...float64) (float64, error)
+}
+
+var functions = map[string]Function{
+	"sqrt":  {Name: "sqrt", MinArgs: 1, MaxArgs: 1, Call: sqrt},
+	"sin":   {Name: "sin", MinArgs: 1, MaxArgs: 1, Call: unary(math.Sin)},
+	"cos":   {Name: "cos", MinArgs: 1, MaxArgs: 1, Call: unary(math.Cos)},
+	"log":   {Name: "log", MinArgs: 1, MaxArgs: 2, Call: logarithm},
+	"abs":   {Name: "abs", MinArgs: 1, MaxArgs: 1, Call: unary(math.Abs)},
+	"min":   {Name: "min", MinArgs: 1, MaxArgs: -1, Call: minimum},
+	"max":   {Name: "max", MinArgs: 1, MaxArgs: -1, Call: maximum},
+	"round": {Name: "round", MinArgs: 1, MaxArgs: 1, Call: unary(math.Round)},
+}
+
+// LookupFunction возвращает встроенную функцию по имени.
+func LookupFunction(name string) (Function, bool) {
+	fn, ok := functions[name]
+	return fn, ok
+}
+
+// FunctionNames возвращает имена всех встроенных функций в алфавитном порядке.
+func FunctionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AcceptsArgs проверяет, можно ли вызвать функцию с n аргументами.
+func (f Function) AcceptsArgs(n int) bool {
+	return n >= f.MinArgs && (f.MaxArgs < 0 || n <= f.MaxArgs)
+}
+
+// arity возвращает описание допустимого числа аргументов для сообщений об ошибках.
+func (f Function) arity() string {
+	switch {
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("at least %d", f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("%d", f.MinArgs)
+	default:
+		return fmt.Sprintf("%d to %d", f.MinArgs, f.MaxArgs)
+	}
+}
+
+func unary(fn func(float64) float64) func(args ...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		return fn(args[0]), nil
+	}
+}
+
+func sqrt(args ...float64) (float64, error) {
+	if args[0] < 0 {
+		return 0, errors.New("sqrt of negative number")
+	}
+	return math.Sqrt(args[0]), nil
+}
+
+// logarithm вычисляет натуральный логарифм, а с двумя аргументами - логарифм по основанию args[1].
+func logarithm(args ...float64) (float64, error) {
+	if args[0] <= 0 {
+		return 0, errors.New("log of non-positive number")
+	}
+
+	if len(args) == 1 {
+		return math.Log(args[0]), nil
+	}
+
+	if args[1] <= 0 || args[1] == 1 {
+		return 0, fmt.Errorf("invalid log base: %g", args[1])
+	}
+	return math.Log(args[0]) / math.Log(args[1]), nil
+}
+
+func minimum(args ...float64) (float64, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Min(result, arg)
+	}
+	return result, nil
+}
+
+func maximum(args ...float64) (float64, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Max(result, arg)
+	}
+	return result, nil
+}
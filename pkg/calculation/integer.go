@@ -0,0 +1,146 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Целочисленная арифметика на big.Int для режима integer.
+// Числа не ограничены по размеру и записываются десятичной строкой.
+// "/" - деление с отбрасыванием дробной части, "%" - остаток со знаком делимого: -7/2 = -3, -7%2 = -1.
+
+func parseInteger(value string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer: %s", value)
+	}
+	return n, nil
+}
+
+// applyInteger выполняет операцию в режиме integer.
+func applyInteger(op string, args []string) (string, error) {
+	values := make([]*big.Int, len(args))
+	for i, arg := range args {
+		value, err := parseInteger(arg)
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+
+	result, err := integerOperation(op, values)
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+func integerOperation(op string, args []*big.Int) (*big.Int, error) {
+	if fn, ok := LookupFunction(op); ok {
+		if !fn.AcceptsArgs(len(args)) {
+			return nil, fmt.Errorf("function %s expects %s argument(s), got %d", fn.Name, fn.arity(), len(args))
+		}
+		return integerFunction(fn, args)
+	}
+
+	if isUnaryOperation(op) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("operation %s expects 1 argument, got %d", op, len(args))
+		}
+		switch op {
+		case Factorial:
+			return bigFactorial(args[0])
+		case Not:
+			return boolInt(args[0].Sign() == 0), nil
+		}
+		return new(big.Int).Neg(args[0]), nil
+	}
+
+	if len(args) != 2 {
+		return nil, fmt.Errorf("operation %s expects 2 arguments, got %d", op, len(args))
+	}
+
+	a, b := args[0], args[1]
+	switch {
+	case isComparison(op):
+		return boolInt(compare(op, a.Cmp(b))), nil
+	case isLogical(op):
+		return boolInt(logical(op, a.Sign() != 0, b.Sign() != 0)), nil
+	}
+
+	switch op {
+	case "+":
+		return new(big.Int).Add(a, b), nil
+	case "-":
+		return new(big.Int).Sub(a, b), nil
+	case "*":
+		return new(big.Int).Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return new(big.Int).Quo(a, b), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		return new(big.Int).Rem(a, b), nil
+	case "^":
+		if b.Sign() < 0 {
+			return nil, fmt.Errorf("%s mode doesn't support negative exponents", ModeInteger)
+		}
+		if b.CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+			return nil, fmt.Errorf("exponent %s is too large", b)
+		}
+		if err := checkPowSize(a, b); err != nil {
+			return nil, err
+		}
+		return new(big.Int).Exp(a, b, nil), nil
+	}
+
+	return nil, fmt.Errorf("unknown operation: %s", op)
+}
+
+func boolInt(b bool) *big.Int {
+	return big.NewInt(int64(boolFloat(b)))
+}
+
+// integerFunction вычисляет встроенную функцию. sqrt возвращает целую часть корня,
+// round ничего не меняет, а sin, cos и log в режиме integer недоступны.
+func integerFunction(fn Function, args []*big.Int) (*big.Int, error) {
+	switch fn.Name {
+	case "abs":
+		return new(big.Int).Abs(args[0]), nil
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if (fn.Name == "min" && arg.Cmp(result) < 0) || (fn.Name == "max" && arg.Cmp(result) > 0) {
+				result = arg
+			}
+		}
+		return new(big.Int).Set(result), nil
+	case "round":
+		return new(big.Int).Set(args[0]), nil
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return nil, errors.New("sqrt of negative number")
+		}
+		return new(big.Int).Sqrt(args[0]), nil
+	}
+
+	return nil, fmt.Errorf("function %s is not supported in %s mode", fn.Name, ModeInteger)
+}
+
+// bigFactorial вычисляет n! без ограничения разрядности. n не может быть больше maxFactorial.
+func bigFactorial(n *big.Int) (*big.Int, error) {
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("factorial is defined only for non-negative integers, got %s", n)
+	}
+	if n.Cmp(big.NewInt(maxFactorial)) > 0 {
+		return nil, fmt.Errorf("factorial argument %s is too large", n)
+	}
+
+	return new(big.Int).MulRange(1, n.Int64()), nil
+}
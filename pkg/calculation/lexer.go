@@ -0,0 +1,105 @@
+package calculation
+
+import (
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenIdent
+	TokenComma
+)
+
+// Token - лексема выражения. Pos - номер символа в исходной строке, начиная с 1.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+}
+
+// Lex разбивает выражение на лексемы. Пробельные символы пропускаются.
+// Последней лексемой всегда идёт TokenEOF.
+func Lex(expression string) ([]Token, error) {
+	var tokens []Token
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isDigitOrDot(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			if i < len(runes) && runes[i] == '.' {
+				i++
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+
+			text := string(runes[start:i])
+			if text == "." {
+				return nil, &SyntaxError{Msg: "invalid number", Token: text, Pos: start + 1}
+			}
+
+			// Мнимое число: "2i", но не "2in"
+			if i < len(runes) && runes[i] == 'i' && (i+1 == len(runes) || !isIdentRune(runes[i+1])) {
+				i++
+				text += ImaginaryUnit
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: text, Pos: start + 1})
+		case i+1 < len(runes) && isTwoCharOperator(string(runes[i:i+2])):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(runes[i : i+2]), Pos: i + 1})
+			i += 2
+		case IsOperator(r):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(r), Pos: i + 1})
+			i++
+		case r == '(':
+			tokens = append(tokens, Token{Kind: TokenLParen, Text: "(", Pos: i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, Token{Kind: TokenRParen, Text: ")", Pos: i + 1})
+			i++
+		case r == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Pos: i + 1})
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: string(runes[start:i]), Pos: start + 1})
+		default:
+			return nil, &SyntaxError{Msg: "invalid character", Token: string(r), Pos: i + 1}
+		}
+	}
+
+	tokens = append(tokens, Token{Kind: TokenEOF, Pos: len(runes) + 1})
+	return tokens, nil
+}
+
+func isTwoCharOperator(op string) bool {
+	switch op {
+	case "<=", ">=", "==", "!=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+func isDigitOrDot(symbol rune) bool {
+	return unicode.IsDigit(symbol) || symbol == '.'
+}
+
+func isIdentRune(symbol rune) bool {
+	return unicode.IsLetter(symbol) || unicode.IsDigit(symbol) || symbol == '_'
+}
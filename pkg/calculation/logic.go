@@ -0,0 +1,66 @@
+package calculation
+
+// Сравнения и логические операции. Логические значения - обычные числа:
+// операции возвращают 1 (истина) или 0 (ложь), а истиной считается любое ненулевое число.
+
+// Not обозначает логическое отрицание ("!x") в дереве выражения и в задачах агентов.
+const Not = "not"
+
+// Conditional - имя условного выражения if(cond, then, else).
+// Ветки вычисляются лениво: задачи невыбранной ветки не выполняются.
+const Conditional = "if"
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func isLogical(op string) bool {
+	return op == "&&" || op == "||"
+}
+
+// compare возвращает результат сравнения по знаку cmp (как у big.Int.Cmp).
+func compare(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func logical(op string, a, b bool) bool {
+	if op == "&&" {
+		return a && b
+	}
+	return a || b
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
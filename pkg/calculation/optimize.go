@@ -0,0 +1,120 @@
+package calculation
+
+// Упрощение дерева перед созданием задач. Каждая операция в дереве становится задачей для агента,
+// поэтому всё, что можно посчитать сразу, считается здесь.
+
+// IsCommutative проверяет, можно ли переставлять аргументы операции, не меняя результата.
+func IsCommutative(op string) bool {
+	switch op {
+	case "+", "*", "==", "!=", "&&", "||", "min", "max":
+		return true
+	}
+	return false
+}
+
+// Simplify упрощает дерево без изменения результата:
+//   - операции над числами, для которых cheap возвращает true, вычисляются сразу;
+//   - применяются тождества x + 0 = x, x - 0 = x, x * 1 = x, x / 1 = x, x ^ 1 = x, -(-x) = x;
+//   - число, умноженное на 0, заменяется нулём;
+//   - if с числом в условии заменяется выбранной веткой.
+//
+// Тождества не убирают подвыражения, которые могут завершиться ошибкой: x * 0 упрощается,
+// только если x - число, иначе 1/0 * 0 вернул бы 0 вместо ошибки деления на ноль.
+// Если операцию над числами вычислить не удалось, она остаётся в дереве, и ошибку вернёт агент.
+// Переменные должны быть уже подставлены.
+func (d Domain) Simplify(node Node, cheap func(op string) bool) Node {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand := d.Simplify(n.Operand, cheap)
+
+		if inner, ok := operand.(*UnaryNode); ok && n.Op == Negation && inner.Op == Negation {
+			return inner.Operand
+		}
+
+		return d.fold(&UnaryNode{Op: n.Op, Operand: operand, Position: n.Position}, n.Op, cheap, operand)
+	case *BinaryNode:
+		left := d.Simplify(n.Left, cheap)
+		right := d.Simplify(n.Right, cheap)
+
+		switch {
+		case (n.Op == "+" || n.Op == "-") && d.isNumber(right, "0"):
+			return left
+		case n.Op == "+" && d.isNumber(left, "0"):
+			return right
+		case (n.Op == "*" || n.Op == "/" || n.Op == "^") && d.isNumber(right, "1"):
+			return left
+		case n.Op == "*" && d.isNumber(left, "1"):
+			return right
+		case n.Op == "*" && isNumberNode(left) && isNumberNode(right) && (d.isNumber(left, "0") || d.isNumber(right, "0")):
+			return &NumberNode{Value: "0", Position: n.Position}
+		}
+
+		return d.fold(&BinaryNode{Op: n.Op, Left: left, Right: right, Position: n.Position}, n.Op, cheap, left, right)
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = d.Simplify(arg, cheap)
+		}
+
+		return d.fold(&CallNode{Name: n.Name, Args: args, Position: n.Position}, n.Name, cheap, args...)
+	case *IfNode:
+		cond := d.Simplify(n.Cond, cheap)
+
+		if number, ok := cond.(*NumberNode); ok {
+			if truth, err := d.Truth(number.Value); err == nil {
+				if truth {
+					return d.Simplify(n.Then, cheap)
+				}
+				return d.Simplify(n.Else, cheap)
+			}
+		}
+
+		return &IfNode{Cond: cond, Then: d.Simplify(n.Then, cheap), Else: d.Simplify(n.Else, cheap), Position: n.Position}
+	}
+
+	return node
+}
+
+// fold вычисляет операцию op сразу, если она дешёвая и все её аргументы - числа. Иначе возвращает node.
+func (d Domain) fold(node Node, op string, cheap func(op string) bool, args ...Node) Node {
+	if cheap == nil || !cheap(op) {
+		return node
+	}
+
+	values := make([]string, len(args))
+	for i, arg := range args {
+		number, ok := arg.(*NumberNode)
+		if !ok {
+			return node
+		}
+		values[i] = number.Value
+	}
+
+	result, err := d.Apply(op, values...)
+	if err != nil {
+		return node
+	}
+
+	return &NumberNode{Value: result, Position: node.Pos()}
+}
+
+// isNumber проверяет, что узел - число, равное value в режиме d.
+func (d Domain) isNumber(node Node, value string) bool {
+	number, ok := node.(*NumberNode)
+	if !ok {
+		return false
+	}
+
+	equal, err := d.Apply("==", number.Value, value)
+	if err != nil {
+		return false
+	}
+
+	truth, err := d.Truth(equal)
+	return err == nil && truth
+}
+
+func isNumberNode(node Node) bool {
+	_, ok := node.(*NumberNode)
+	return ok
+}
@@ -0,0 +1,122 @@
+package calculation_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+func TestSimplify(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		mode       calculation.Mode
+		cheap      []string
+		want       string
+	}{
+		{
+			name:       "Nothing is cheap",
+			expression: "(2+3)*4",
+			want:       "((2 + 3) * 4)",
+		},
+		{
+			name:       "Cheap operations are folded",
+			expression: "(2+3)*4 + sqrt(16)",
+			cheap:      []string{"+", "*"},
+			want:       "(20 + sqrt(16))",
+		},
+		{
+			name:       "Neutral operands",
+			expression: "(sqrt(2) + 0) * 1 - 0 + 0 + sqrt(3) / 1 + sqrt(5)^1",
+			want:       "((sqrt(2) + sqrt(3)) + sqrt(5))",
+		},
+		{
+			name:       "Multiplication of number by zero",
+			expression: "1000 * 0 + 0 * 7",
+			want:       "0",
+		},
+		{
+			name:       "Multiplication of operation by zero is kept",
+			expression: "1/0 * 0",
+			want:       "((1 / 0) * 0)",
+		},
+		{
+			name:       "Failing operation is not folded",
+			expression: "1/0",
+			cheap:      []string{"/"},
+			want:       "(1 / 0)",
+		},
+		{
+			name:       "Double negation",
+			expression: "-(-sqrt(2))",
+			want:       "sqrt(2)",
+		},
+		{
+			name:       "Constant condition",
+			expression: "if(2 > 1, sqrt(4), sqrt(9))",
+			cheap:      []string{">"},
+			want:       "sqrt(4)",
+		},
+		{
+			name:       "Exact zero in decimal mode",
+			expression: "sqrt(2) + 0.000",
+			mode:       calculation.ModeDecimal,
+			want:       "sqrt(2)",
+		},
+		{
+			name:       "Almost one is not an identity",
+			expression: "sqrt(2) * 1.0000000000000000000001",
+			mode:       calculation.ModeDecimal,
+			want:       "(sqrt(2) * 1.0000000000000000000001)",
+		},
+		{
+			name:       "Integer mode",
+			expression: "7/2 + 0",
+			mode:       calculation.ModeInteger,
+			cheap:      []string{"/"},
+			want:       "3",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			node, err := calculation.Parse(tc.expression)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			cheap := func(op string) bool { return slices.Contains(tc.cheap, op) }
+
+			d := calculation.Domain{Mode: tc.mode}
+			if got := render(d.Simplify(node, cheap)); got != tc.want {
+				t.Errorf("Simplify() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// render записывает дерево со скобками вокруг каждой операции.
+func render(node calculation.Node) string {
+	switch n := node.(type) {
+	case *calculation.NumberNode:
+		return n.Value
+	case *calculation.VariableNode:
+		return n.Name
+	case *calculation.UnaryNode:
+		return fmt.Sprintf("%s(%s)", n.Op, render(n.Operand))
+	case *calculation.BinaryNode:
+		return fmt.Sprintf("(%s %s %s)", render(n.Left), n.Op, render(n.Right))
+	case *calculation.CallNode:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = render(arg)
+		}
+		return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+	case *calculation.IfNode:
+		return fmt.Sprintf("if(%s, %s, %s)", render(n.Cond), render(n.Then), render(n.Else))
+	}
+	return fmt.Sprintf("%T", node)
+}
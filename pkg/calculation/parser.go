@@ -0,0 +1,255 @@
+package calculation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError - ошибка разбора выражения с позицией и лексемой, на которой она произошла.
+type SyntaxError struct {
+	Msg   string
+	Token string
+	Pos   int
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("%s '%s' at position %d", e.Msg, e.Token, e.Pos)
+}
+
+// prefixBindingPower - сила связывания унарных плюса, минуса и логического "!".
+// Она больше, чем у "*", "/" и "%", поэтому !2*0 = (!2)*0, но меньше левой силы "^", поэтому -2^2 = -(2^2).
+const prefixBindingPower = 13
+
+// postfixBindingPower - сила связывания факториала. Она больше, чем у всех остальных операторов,
+// поэтому -3! = -(3!), а 2^3! = 2^(3!).
+const postfixBindingPower = 15
+
+// infixBindingPower возвращает силу связывания бинарного оператора слева и справа.
+// Для левоассоциативных операторов правая сила больше левой, для правоассоциативного "^" - наоборот.
+// Приоритеты как в C: "||" < "&&" < "==", "!=" < "<", "<=", ">", ">=" < арифметика.
+func infixBindingPower(op string) (int, int, bool) {
+	switch op {
+	case "||":
+		return 1, 2, true
+	case "&&":
+		return 3, 4, true
+	case "==", "!=":
+		return 5, 6, true
+	case "<", "<=", ">", ">=":
+		return 7, 8, true
+	case "+", "-":
+		return 9, 10, true
+	case "*", "/", "%":
+		return 11, 12, true
+	case "^":
+		return 14, 13, true
+	}
+	return 0, 0, false
+}
+
+type parser struct {
+	tokens    []Token
+	pos       int
+	functions Functions
+}
+
+// Parse разбирает выражение и возвращает его дерево.
+// Ошибки разбора имеют тип *SyntaxError.
+func Parse(expression string) (Node, error) {
+	return ParseWith(expression, nil)
+}
+
+// ParseWith разбирает выражение, в котором кроме встроенных функций можно вызывать функции из functions.
+// Их вызовы остаются в дереве как CallNode и заменяются телами функций в Inline.
+func ParseWith(expression string, functions Functions) (Node, error) {
+	tokens, err := Lex(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, functions: functions}
+	node, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, unexpected(tok)
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// unexpected возвращает ошибку о неожиданной лексеме.
+func unexpected(tok Token) error {
+	if tok.Kind == TokenEOF {
+		return &SyntaxError{Msg: "unexpected end of expression", Pos: tok.Pos}
+	}
+	return &SyntaxError{Msg: "unexpected", Token: tok.Text, Pos: tok.Pos}
+}
+
+// parseExpression разбирает выражение, пока встречаются операторы с силой связывания не меньше minBP.
+func (p *parser) parseExpression(minBP int) (Node, error) {
+	left, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.Kind != TokenOperator {
+			break
+		}
+
+		if tok.Text == Factorial {
+			if postfixBindingPower < minBP {
+				break
+			}
+			p.next()
+
+			left = &UnaryNode{Op: Factorial, Operand: left, Position: tok.Pos}
+			continue
+		}
+
+		leftBP, rightBP, ok := infixBindingPower(tok.Text)
+		if !ok || leftBP < minBP {
+			break
+		}
+		p.next()
+
+		right, err := p.parseExpression(rightBP)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &BinaryNode{Op: tok.Text, Left: left, Right: right, Position: tok.Pos}
+	}
+
+	return left, nil
+}
+
+// parsePrefix разбирает операнд: число, переменную, вызов функции, выражение в скобках или унарную операцию.
+func (p *parser) parsePrefix() (Node, error) {
+	tok := p.next()
+
+	switch {
+	case tok.Kind == TokenNumber:
+		return &NumberNode{Value: tok.Text, Position: tok.Pos}, nil
+	case tok.Kind == TokenIdent:
+		if p.peek().Kind == TokenLParen {
+			return p.parseCall(tok)
+		}
+		return &VariableNode{Name: tok.Text, Position: tok.Pos}, nil
+	case tok.Kind == TokenLParen:
+		node, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.Kind != TokenRParen {
+			return nil, unexpected(closing)
+		}
+		return node, nil
+	case tok.Kind == TokenOperator && isUnary(tok.Text):
+		operand, err := p.parseExpression(prefixBindingPower)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.Text {
+		case "+":
+			return operand, nil
+		case "!":
+			return &UnaryNode{Op: Not, Operand: operand, Position: tok.Pos}, nil
+		}
+
+		// Минус перед числом становится частью числа
+		if number, ok := operand.(*NumberNode); ok && !strings.HasPrefix(number.Value, "-") {
+			return &NumberNode{Value: "-" + number.Value, Position: tok.Pos}, nil
+		}
+		return &UnaryNode{Op: Negation, Operand: operand, Position: tok.Pos}, nil
+	default:
+		return nil, unexpected(tok)
+	}
+}
+
+// parseCall разбирает вызов функции name(arg1, arg2, ...) или if(cond, then, else) и проверяет число аргументов.
+// Открывающая скобка ещё не прочитана.
+func (p *parser) parseCall(name Token) (Node, error) {
+	fn, ok := LookupFunction(name.Text)
+	user, isUser := p.functions[name.Text]
+	if !ok && !isUser && name.Text != Conditional {
+		return nil, &SyntaxError{Msg: "unknown function", Token: name.Text, Pos: name.Pos}
+	}
+
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if name.Text == Conditional {
+		if len(args) != 3 {
+			msg := fmt.Sprintf("%s expects 3 arguments (condition, then, else), got %d", Conditional, len(args))
+			return nil, &SyntaxError{Msg: msg, Pos: name.Pos}
+		}
+		return &IfNode{Cond: args[0], Then: args[1], Else: args[2], Position: name.Pos}, nil
+	}
+
+	if !ok {
+		if len(args) != len(user.Params) {
+			msg := fmt.Sprintf("function %s expects %d argument(s), got %d", user.Name, len(user.Params), len(args))
+			return nil, &SyntaxError{Msg: msg, Pos: name.Pos}
+		}
+		return &CallNode{Name: name.Text, Args: args, Position: name.Pos}, nil
+	}
+
+	if !fn.AcceptsArgs(len(args)) {
+		msg := fmt.Sprintf("function %s expects %s argument(s), got %d", fn.Name, fn.arity(), len(args))
+		return nil, &SyntaxError{Msg: msg, Pos: name.Pos}
+	}
+
+	return &CallNode{Name: name.Text, Args: args, Position: name.Pos}, nil
+}
+
+// parseArgs разбирает аргументы вызова вместе со скобками.
+func (p *parser) parseArgs() ([]Node, error) {
+	p.next()
+
+	var args []Node
+	if p.peek().Kind == TokenRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			tok := p.next()
+			if tok.Kind == TokenRParen {
+				break
+			}
+			if tok.Kind != TokenComma {
+				return nil, unexpected(tok)
+			}
+		}
+	}
+
+	return args, nil
+}
@@ -0,0 +1,244 @@
+package calculation_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		wantPos    int
+		wantToken  string
+		want       string
+	}{
+		{
+			name:       "Unexpected closing parenthesis",
+			expression: "(2+3)*)",
+			wantPos:    7,
+			wantToken:  ")",
+			want:       "unexpected ')' at position 7",
+		},
+		{
+			name:       "Unclosed parenthesis",
+			expression: "2+(3*4",
+			wantPos:    7,
+			want:       "unexpected end of expression at position 7",
+		},
+		{
+			name:       "Two binary operators",
+			expression: "2 * / 3",
+			wantPos:    5,
+			wantToken:  "/",
+			want:       "unexpected '/' at position 5",
+		},
+		{
+			name:       "Missing operator",
+			expression: "2 3",
+			wantPos:    3,
+			wantToken:  "3",
+			want:       "unexpected '3' at position 3",
+		},
+		{
+			name:       "Invalid character",
+			expression: "2+3$4",
+			wantPos:    4,
+			wantToken:  "$",
+			want:       "invalid character '$' at position 4",
+		},
+		{
+			name:       "Empty parentheses",
+			expression: "()",
+			wantPos:    2,
+			wantToken:  ")",
+			want:       "unexpected ')' at position 2",
+		},
+		{
+			name:       "Unknown function",
+			expression: "2*foo(1)",
+			wantPos:    3,
+			wantToken:  "foo",
+			want:       "unknown function 'foo' at position 3",
+		},
+		{
+			name:       "Function without parentheses",
+			expression: "sqrt 4",
+			wantPos:    6,
+			wantToken:  "4",
+			want:       "unexpected '4' at position 6",
+		},
+		{
+			name:       "Missing argument after comma",
+			expression: "max(1,)",
+			wantPos:    7,
+			wantToken:  ")",
+			want:       "unexpected ')' at position 7",
+		},
+		{
+			name:       "Too many arguments",
+			expression: "1 + sqrt(1, 2)",
+			wantPos:    5,
+			want:       "function sqrt expects 1 argument(s), got 2 at position 5",
+		},
+		{
+			name:       "Too few arguments",
+			expression: "max()",
+			wantPos:    1,
+			want:       "function max expects at least 1 argument(s), got 0 at position 1",
+		},
+		{
+			name:       "Factorial without operand",
+			expression: "2*!",
+			wantPos:    4,
+			want:       "unexpected end of expression at position 4",
+		},
+		{
+			name:       "Single equals sign",
+			expression: "x = 1",
+			wantPos:    3,
+			wantToken:  "=",
+			want:       "invalid character '=' at position 3",
+		},
+		{
+			name:       "If with two arguments",
+			expression: "2 + if(x > 1, x)",
+			wantPos:    5,
+			want:       "if expects 3 arguments (condition, then, else), got 2 at position 5",
+		},
+		{
+			name:       "Comma outside of call",
+			expression: "1,2",
+			wantPos:    2,
+			wantToken:  ",",
+			want:       "unexpected ',' at position 2",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := calculation.Parse(tc.expression)
+
+			var syntaxErr *calculation.SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse() error = %v, want *SyntaxError", err)
+			}
+
+			if syntaxErr.Pos != tc.wantPos || syntaxErr.Token != tc.wantToken {
+				t.Errorf("Parse() error at %d (%q), want %d (%q)", syntaxErr.Pos, syntaxErr.Token, tc.wantPos, tc.wantToken)
+			}
+
+			if err.Error() != tc.want {
+				t.Errorf("Parse() error = %q, want %q", err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := calculation.Lex("12 * (3.5-1)")
+	if err != nil {
+		t.Fatalf("Lex() error = %v", err)
+	}
+
+	want := []calculation.Token{
+		{Kind: calculation.TokenNumber, Text: "12", Pos: 1},
+		{Kind: calculation.TokenOperator, Text: "*", Pos: 4},
+		{Kind: calculation.TokenLParen, Text: "(", Pos: 6},
+		{Kind: calculation.TokenNumber, Text: "3.5", Pos: 7},
+		{Kind: calculation.TokenOperator, Text: "-", Pos: 10},
+		{Kind: calculation.TokenNumber, Text: "1", Pos: 11},
+		{Kind: calculation.TokenRParen, Text: ")", Pos: 12},
+		{Kind: calculation.TokenEOF, Pos: 13},
+	}
+
+	if len(tokens) != len(want) {
+		t.Fatalf("Lex() returned %d tokens, want %d", len(tokens), len(want))
+	}
+
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Lex() token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestLexImaginary(t *testing.T) {
+	tokens, err := calculation.Lex("2i*in")
+	if err != nil {
+		t.Fatalf("Lex() error = %v", err)
+	}
+
+	want := []calculation.Token{
+		{Kind: calculation.TokenNumber, Text: "2i", Pos: 1},
+		{Kind: calculation.TokenOperator, Text: "*", Pos: 3},
+		{Kind: calculation.TokenIdent, Text: "in", Pos: 4},
+		{Kind: calculation.TokenEOF, Pos: 6},
+	}
+
+	if len(tokens) != len(want) {
+		t.Fatalf("Lex() returned %d tokens, want %d", len(tokens), len(want))
+	}
+
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Lex() token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestLexOperators(t *testing.T) {
+	tokens, err := calculation.Lex("a>=1&&!b")
+	if err != nil {
+		t.Fatalf("Lex() error = %v", err)
+	}
+
+	want := []calculation.Token{
+		{Kind: calculation.TokenIdent, Text: "a", Pos: 1},
+		{Kind: calculation.TokenOperator, Text: ">=", Pos: 2},
+		{Kind: calculation.TokenNumber, Text: "1", Pos: 4},
+		{Kind: calculation.TokenOperator, Text: "&&", Pos: 5},
+		{Kind: calculation.TokenOperator, Text: "!", Pos: 7},
+		{Kind: calculation.TokenIdent, Text: "b", Pos: 8},
+		{Kind: calculation.TokenEOF, Pos: 9},
+	}
+
+	if len(tokens) != len(want) {
+		t.Fatalf("Lex() returned %d tokens, want %d", len(tokens), len(want))
+	}
+
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Lex() token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestLexCall(t *testing.T) {
+	tokens, err := calculation.Lex("log(x_1, 2)")
+	if err != nil {
+		t.Fatalf("Lex() error = %v", err)
+	}
+
+	want := []calculation.Token{
+		{Kind: calculation.TokenIdent, Text: "log", Pos: 1},
+		{Kind: calculation.TokenLParen, Text: "(", Pos: 4},
+		{Kind: calculation.TokenIdent, Text: "x_1", Pos: 5},
+		{Kind: calculation.TokenComma, Text: ",", Pos: 8},
+		{Kind: calculation.TokenNumber, Text: "2", Pos: 10},
+		{Kind: calculation.TokenRParen, Text: ")", Pos: 11},
+		{Kind: calculation.TokenEOF, Pos: 12},
+	}
+
+	if len(tokens) != len(want) {
+		t.Fatalf("Lex() returned %d tokens, want %d", len(tokens), len(want))
+	}
+
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("Lex() token %d = %+v, want %+v", i, tokens[i], want[i])
+		}
+	}
+}
@@ -1,79 +1,46 @@
 package calculation
 
-import (
-	"fmt"
-	"strings"
-	"unicode"
-)
+import "fmt"
 
-func isDigitOrDot(symbol rune) bool {
-	return unicode.IsDigit(symbol) || symbol == '.'
-}
-
-func buildNumber(expression string, i *int) string {
-	var num strings.Builder
-	if expression[*i] == '-' {
-		num.WriteByte('-')
-		*i++
-	}
-
-	for *i < len(expression) && (unicode.IsDigit(rune(expression[*i])) || expression[*i] == '.') {
-		num.WriteByte(expression[*i])
-		*i++
+// ShuntingYard возвращает выражение в обратной польской записи.
+// Вызов функции записывается как имя и число аргументов через "/", например "max/3",
+// условие if(cond, then, else) - как "if/3" после всех трёх веток.
+// Разбор выполняет Parse, поэтому грамматика и ошибки у них общие.
+func ShuntingYard(expression string) ([]string, error) {
+	node, err := Parse(expression)
+	if err != nil {
+		return nil, err
 	}
 
-	*i--
-
-	return num.String()
+	return postfix(node, nil), nil
 }
 
-func ShuntingYard(expression string) ([]string, error) {
-	var out []string
-	var operators []string
-
-	for i := 0; i < len(expression); i++ {
-		current := rune(expression[i])
-
-		if unicode.IsSpace(current) {
-			continue
+// postfix обходит дерево и дописывает узлы в обратной польской записи.
+func postfix(node Node, out []string) []string {
+	switch n := node.(type) {
+	case *NumberNode:
+		out = append(out, n.Value)
+	case *VariableNode:
+		out = append(out, n.Name)
+	case *UnaryNode:
+		out = postfix(n.Operand, out)
+		out = append(out, n.Op)
+	case *BinaryNode:
+		out = postfix(n.Left, out)
+		out = postfix(n.Right, out)
+		out = append(out, n.Op)
+	case *CallNode:
+		for _, arg := range n.Args {
+			out = postfix(arg, out)
 		}
-
-		if isDigitOrDot(current) || (current == '-' && (i == 0 || expression[i-1] == '(')) {
-			out = append(out, buildNumber(expression, &i))
-		} else if IsOperator(current) {
-			for len(operators) > 0 && IsOperator(rune(operators[len(operators)-1][0])) &&
-				precedence(rune(operators[len(operators)-1][0])) >= precedence(current) {
-				out = append(out, operators[len(operators)-1])
-				operators = operators[:len(operators)-1]
-			}
-
-			operators = append(operators, string(current))
-		} else if current == '(' {
-			operators = append(operators, string(current))
-		} else if current == ')' {
-			for len(operators) > 0 && operators[len(operators)-1] != "(" {
-				out = append(out, operators[len(operators)-1])
-				operators = operators[:len(operators)-1]
-			}
-
-			if len(operators) == 0 {
-				return nil, fmt.Errorf("mismatched parentheses")
-			}
-
-			operators = operators[:len(operators)-1]
-		} else {
-			return nil, fmt.Errorf("invalid character: %c", current)
-		}
-	}
-
-	for len(operators) > 0 {
-		if operators[len(operators)-1] == "(" || operators[len(operators)-1] == ")" {
-			return nil, fmt.Errorf("mismatched parentheses")
-		}
-
-		out = append(out, operators[len(operators)-1])
-		operators = operators[:len(operators)-1]
+		// Число аргументов записывается рядом с именем, иначе вызов с переменным числом аргументов не восстановить
+		out = append(out, fmt.Sprintf("%s/%d", n.Name, len(n.Args)))
+	case *IfNode:
+		out = postfix(n.Cond, out)
+		out = postfix(n.Then, out)
+		out = postfix(n.Else, out)
+		out = append(out, Conditional+"/3")
 	}
 
-	return out, nil
+	return out
 }
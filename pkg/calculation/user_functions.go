@@ -0,0 +1,258 @@
+package calculation
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxInlinedNodes ограничивает размер дерева после подстановки пользовательских функций.
+// Без ограничения вложенные вызовы вроде f(f(f(x))) при f(x) = x * x растут экспоненциально.
+const maxInlinedNodes = 10000
+
+// FunctionDefinition - пользовательская функция в том виде, в котором её прислал пользователь.
+type FunctionDefinition struct {
+	Name   string
+	Params []string
+	Body   string
+}
+
+// UserFunction - разобранная пользовательская функция.
+type UserFunction struct {
+	Name   string
+	Params []string
+	Body   Node
+}
+
+// Functions - пользовательские функции по имени.
+type Functions map[string]*UserFunction
+
+// CompileFunctions разбирает тела функций. Функции могут вызывать друг друга независимо от порядка в defs,
+// поэтому сначала регистрируются все имена и параметры, а затем разбираются тела.
+// Рекурсия здесь не проверяется: она обнаруживается при подстановке (см. Inline).
+func CompileFunctions(defs []FunctionDefinition) (Functions, error) {
+	functions := make(Functions, len(defs))
+	for _, def := range defs {
+		functions[def.Name] = &UserFunction{Name: def.Name, Params: def.Params}
+	}
+
+	for _, def := range defs {
+		body, err := ParseWith(def.Body, functions)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", def.Name, err)
+		}
+		functions[def.Name].Body = body
+	}
+
+	return functions, nil
+}
+
+// ValidateFunction проверяет функцию def перед сохранением вместе с уже сохранёнными функциями existing:
+// имена функции и параметров, тело и отсутствие рекурсии. В теле можно использовать только параметры
+// и встроенные константы. Функция с тем же именем из existing заменяется на def.
+func ValidateFunction(def FunctionDefinition, existing []FunctionDefinition) error {
+	if err := ValidateName(def.Name); err != nil {
+		return err
+	}
+
+	params := make(map[string]float64, len(def.Params))
+	for _, param := range def.Params {
+		if err := ValidateName(param); err != nil {
+			return err
+		}
+		if _, ok := params[param]; ok {
+			return fmt.Errorf("duplicate parameter %q", param)
+		}
+		params[param] = 0
+	}
+
+	defs := []FunctionDefinition{def}
+	for _, other := range existing {
+		if other.Name != def.Name {
+			defs = append(defs, other)
+		}
+	}
+
+	functions, err := CompileFunctions(defs)
+	if err != nil {
+		return err
+	}
+
+	// Изменённая функция могла замкнуть цикл через другие функции, поэтому проверяются все
+	for _, fn := range functions {
+		call := &CallNode{Name: fn.Name, Position: 1}
+		for _, param := range fn.Params {
+			call.Args = append(call.Args, &VariableNode{Name: param, Position: 1})
+		}
+		if _, _, err := Inline(call, functions); err != nil {
+			return fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+	}
+
+	if _, _, err := Substitute(functions[def.Name].Body, params); err != nil {
+		return fmt.Errorf("function %s: %w", def.Name, err)
+	}
+
+	return nil
+}
+
+// Inline подставляет тела пользовательских функций вместо их вызовов.
+// Узлы тела получают позицию вызова, а аргументы сохраняют свои позиции.
+// Вторым значением возвращаются имена использованных функций.
+// Рекурсивный вызов и слишком большое дерево возвращаются как *SyntaxError.
+func Inline(node Node, functions Functions) (Node, map[string]bool, error) {
+	in := &inliner{functions: functions, used: make(map[string]bool)}
+
+	result, err := in.inline(node, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, in.used, nil
+}
+
+type inliner struct {
+	functions Functions
+	used      map[string]bool
+	size      int
+}
+
+// inline заменяет вызовы функций в дереве. stack - функции, внутри тел которых находится node.
+func (in *inliner) inline(node Node, stack []string) (Node, error) {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand, err := in.inline(n.Operand, stack)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand, Position: n.Position}, nil
+	case *BinaryNode:
+		left, err := in.inline(n.Left, stack)
+		if err != nil {
+			return nil, err
+		}
+		right, err := in.inline(n.Right, stack)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right, Position: n.Position}, nil
+	case *CallNode:
+		// Аргументы вычисляются в месте вызова, поэтому раскрываются до входа в тело:
+		// f(f(1)) - не рекурсия
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			inlined, err := in.inline(arg, stack)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = inlined
+		}
+
+		fn, ok := in.functions[n.Name]
+		if !ok {
+			return &CallNode{Name: n.Name, Args: args, Position: n.Position}, nil
+		}
+
+		if slices.Contains(stack, n.Name) {
+			return nil, &SyntaxError{Msg: "recursive call of function", Token: n.Name, Pos: n.Position}
+		}
+		in.used[n.Name] = true
+
+		params := make(map[string]Node, len(fn.Params))
+		for i, param := range fn.Params {
+			params[param] = args[i]
+		}
+
+		body, err := in.bind(fn.Body, params, n.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		return in.inline(body, append(slices.Clone(stack), n.Name))
+	case *IfNode:
+		cond, err := in.inline(n.Cond, stack)
+		if err != nil {
+			return nil, err
+		}
+		then, err := in.inline(n.Then, stack)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := in.inline(n.Else, stack)
+		if err != nil {
+			return nil, err
+		}
+		return &IfNode{Cond: cond, Then: then, Else: otherwise, Position: n.Position}, nil
+	}
+
+	return node, nil
+}
+
+// bind копирует тело функции, заменяя параметры аргументами вызова, и ставит узлам позицию pos.
+// Аргументы тоже копируются (с pos = 0 позиции не меняются), чтобы каждый узел дерева был посчитан.
+func (in *inliner) bind(node Node, params map[string]Node, pos int) (Node, error) {
+	in.size++
+	if in.size > maxInlinedNodes {
+		return nil, &SyntaxError{Msg: "expression is too large after inlining functions", Pos: at(node, pos)}
+	}
+
+	switch n := node.(type) {
+	case *NumberNode:
+		return &NumberNode{Value: n.Value, Position: at(n, pos)}, nil
+	case *VariableNode:
+		if arg, ok := params[n.Name]; ok {
+			in.size--
+			return in.bind(arg, nil, 0)
+		}
+		return &VariableNode{Name: n.Name, Position: at(n, pos)}, nil
+	case *UnaryNode:
+		operand, err := in.bind(n.Operand, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand, Position: at(n, pos)}, nil
+	case *BinaryNode:
+		left, err := in.bind(n.Left, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		right, err := in.bind(n.Right, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right, Position: at(n, pos)}, nil
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			bound, err := in.bind(arg, params, pos)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = bound
+		}
+		return &CallNode{Name: n.Name, Args: args, Position: at(n, pos)}, nil
+	case *IfNode:
+		cond, err := in.bind(n.Cond, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		then, err := in.bind(n.Then, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := in.bind(n.Else, params, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &IfNode{Cond: cond, Then: then, Else: otherwise, Position: at(n, pos)}, nil
+	}
+
+	return node, nil
+}
+
+// at возвращает pos или собственную позицию узла, если pos = 0.
+func at(node Node, pos int) int {
+	if pos == 0 {
+		return node.Pos()
+	}
+	return pos
+}
@@ -0,0 +1,213 @@
+package calculation_test
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/MoodyShoo/go-http-calculator/pkg/calculation"
+)
+
+var testFunctions = []calculation.FunctionDefinition{
+	{Name: "vat", Params: []string{"x"}, Body: "x * 1.2"},
+	{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(sq(a) + sq(b))"},
+	{Name: "sq", Params: []string{"x"}, Body: "x * x"},
+	{Name: "sign", Params: []string{"x"}, Body: "if(x < 0, -1, if(x > 0, 1, 0))"},
+	{Name: "tau", Params: nil, Body: "2 * pi"},
+}
+
+func TestInline(t *testing.T) {
+	cases := []struct {
+		name       string
+		expression string
+		want       float64
+		wantUsed   []string
+		wantErr    string
+	}{
+		{
+			name:       "Simple call",
+			expression: "vat(100)",
+			want:       120,
+			wantUsed:   []string{"vat"},
+		},
+		{
+			name:       "Function calls another function",
+			expression: "hyp(3, 4) + 1",
+			want:       6,
+			wantUsed:   []string{"hyp", "sq"},
+		},
+		{
+			name:       "Nested calls are not recursion",
+			expression: "sq(sq(2))",
+			want:       16,
+			wantUsed:   []string{"sq"},
+		},
+		{
+			name:       "Conditional body",
+			expression: "sign(-5) + sign(0)",
+			want:       -1,
+			wantUsed:   []string{"sign"},
+		},
+		{
+			name:       "No parameters",
+			expression: "tau() / 2",
+			want:       3.141592653589793,
+			wantUsed:   []string{"tau"},
+		},
+		{
+			name:       "Built-in functions only",
+			expression: "max(1, 2)",
+			want:       2,
+			wantUsed:   []string{},
+		},
+		{
+			name:       "Wrong argument count",
+			expression: "1 + vat(1, 2)",
+			wantErr:    "function vat expects 1 argument(s), got 2 at position 5",
+		},
+		{
+			name:       "Unknown function",
+			expression: "vta(1)",
+			wantErr:    "unknown function 'vta' at position 1",
+		},
+		{
+			name:       "Error in body points to the call",
+			expression: "1 + vat(x)",
+			wantErr:    "unknown variable 'x' at position 9",
+		},
+		{
+			name:       "Too large after inlining",
+			expression: "sq(sq(sq(sq(sq(sq(sq(sq(sq(sq(sq(sq(1))))))))))))",
+			wantErr:    "expression is too large after inlining functions at position 37",
+		},
+	}
+
+	functions, err := calculation.CompileFunctions(testFunctions)
+	if err != nil {
+		t.Fatalf("CompileFunctions() error = %v", err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, used, err := inlineAndEval(tc.expression, functions)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("Eval() = %v, want %v", got, tc.want)
+			}
+
+			wantUsed := make(map[string]bool)
+			for _, name := range tc.wantUsed {
+				wantUsed[name] = true
+			}
+			if !maps.Equal(used, wantUsed) {
+				t.Errorf("Inline() used = %v, want %v", used, wantUsed)
+			}
+		})
+	}
+}
+
+func inlineAndEval(expression string, functions calculation.Functions) (float64, map[string]bool, error) {
+	node, err := calculation.ParseWith(expression, functions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	node, used, err := calculation.Inline(node, functions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	node, _, err = calculation.Substitute(node, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result, err := calculation.Eval(node)
+	return result, used, err
+}
+
+func TestValidateFunction(t *testing.T) {
+	cases := []struct {
+		name    string
+		def     calculation.FunctionDefinition
+		wantErr string
+	}{
+		{
+			name: "Valid function",
+			def:  calculation.FunctionDefinition{Name: "net", Params: []string{"x"}, Body: "x / 1.2"},
+		},
+		{
+			name: "Uses other functions",
+			def:  calculation.FunctionDefinition{Name: "total", Params: []string{"x", "n"}, Body: "vat(x) * n"},
+		},
+		{
+			name: "Replaces existing function",
+			def:  calculation.FunctionDefinition{Name: "vat", Params: []string{"x"}, Body: "x * 1.18"},
+		},
+		{
+			name:    "Reserved name",
+			def:     calculation.FunctionDefinition{Name: "sqrt", Params: []string{"x"}, Body: "x"},
+			wantErr: `name "sqrt" is reserved for a built-in function`,
+		},
+		{
+			name:    "Invalid parameter",
+			def:     calculation.FunctionDefinition{Name: "f", Params: []string{"1x"}, Body: "1"},
+			wantErr: `invalid name "1x": only letters, digits and '_' are allowed, and it can't start with a digit`,
+		},
+		{
+			name:    "Duplicate parameter",
+			def:     calculation.FunctionDefinition{Name: "f", Params: []string{"x", "x"}, Body: "x"},
+			wantErr: `duplicate parameter "x"`,
+		},
+		{
+			name:    "Syntax error in body",
+			def:     calculation.FunctionDefinition{Name: "f", Params: []string{"x"}, Body: "x +"},
+			wantErr: "function f: unexpected end of expression at position 4",
+		},
+		{
+			name:    "Unknown variable in body",
+			def:     calculation.FunctionDefinition{Name: "f", Params: []string{"x"}, Body: "x * rate"},
+			wantErr: "function f: unknown variable 'rate' at position 5",
+		},
+		{
+			name:    "Direct recursion",
+			def:     calculation.FunctionDefinition{Name: "f", Params: []string{"x"}, Body: "f(x - 1)"},
+			wantErr: "function f: recursive call of function 'f' at position 1",
+		},
+		{
+			name:    "Recursion through other function",
+			def:     calculation.FunctionDefinition{Name: "sq", Params: []string{"x"}, Body: "hyp(x, x)"},
+			wantErr: "recursive call of function",
+		},
+		{
+			name:    "Changed arity breaks callers",
+			def:     calculation.FunctionDefinition{Name: "sq", Params: []string{"x", "y"}, Body: "x * y"},
+			wantErr: "function hyp: function sq expects 2 argument(s), got 1 at position 6",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := calculation.ValidateFunction(tc.def, testFunctions)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateFunction() error = %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ValidateFunction() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// LookupConstant возвращает значение встроенной константы по имени.
+func LookupConstant(name string) (float64, bool) {
+	value, ok := constants[name]
+	return value, ok
+}
+
+// constantNode заменяет встроенную константу или мнимую единицу числом.
+func constantNode(n *VariableNode) (*NumberNode, bool) {
+	if n.Name == ImaginaryUnit {
+		return &NumberNode{Value: "1" + ImaginaryUnit, Position: n.Position}, true
+	}
+	if value, ok := LookupConstant(n.Name); ok {
+		return &NumberNode{Value: formatNumber(value), Position: n.Position}, true
+	}
+	return nil, false
+}
+
+// ValidateName проверяет, что имя можно использовать как переменную в выражении:
+// оно должно быть идентификатором и не совпадать с именем встроенной функции или константы.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name can't be empty")
+	}
+
+	for i, r := range name {
+		if !isIdentRune(r) || (i == 0 && unicode.IsDigit(r)) {
+			return fmt.Errorf("invalid name %q: only letters, digits and '_' are allowed, and it can't start with a digit", name)
+		}
+	}
+
+	if _, ok := LookupFunction(name); ok || name == Conditional {
+		return fmt.Errorf("name %q is reserved for a built-in function", name)
+	}
+
+	if _, ok := LookupConstant(name); ok || name == ImaginaryUnit {
+		return fmt.Errorf("name %q is reserved for a built-in constant", name)
+	}
+
+	return nil
+}
+
+// Substitute заменяет переменные в дереве их значениями: сначала из vars, затем из встроенных констант.
+// Вторым значением возвращаются значения использованных переменных из vars.
+// Неизвестная переменная возвращается как *SyntaxError с её позицией.
+func Substitute(node Node, vars map[string]float64) (Node, map[string]float64, error) {
+	used := make(map[string]float64)
+
+	var substitute func(node Node) (Node, error)
+	substitute = func(node Node) (Node, error) {
+		switch n := node.(type) {
+		case *VariableNode:
+			if value, ok := vars[n.Name]; ok {
+				used[n.Name] = value
+				return &NumberNode{Value: formatNumber(value), Position: n.Position}, nil
+			}
+			if number, ok := constantNode(n); ok {
+				return number, nil
+			}
+			return nil, &SyntaxError{Msg: "unknown variable", Token: n.Name, Pos: n.Position}
+		case *UnaryNode:
+			operand, err := substitute(n.Operand)
+			if err != nil {
+				return nil, err
+			}
+			return &UnaryNode{Op: n.Op, Operand: operand, Position: n.Position}, nil
+		case *BinaryNode:
+			left, err := substitute(n.Left)
+			if err != nil {
+				return nil, err
+			}
+			right, err := substitute(n.Right)
+			if err != nil {
+				return nil, err
+			}
+			return &BinaryNode{Op: n.Op, Left: left, Right: right, Position: n.Position}, nil
+		case *CallNode:
+			args := make([]Node, len(n.Args))
+			for i, arg := range n.Args {
+				substituted, err := substitute(arg)
+				if err != nil {
+					return nil, err
+				}
+				args[i] = substituted
+			}
+			return &CallNode{Name: n.Name, Args: args, Position: n.Position}, nil
+		case *IfNode:
+			cond, err := substitute(n.Cond)
+			if err != nil {
+				return nil, err
+			}
+			then, err := substitute(n.Then)
+			if err != nil {
+				return nil, err
+			}
+			otherwise, err := substitute(n.Else)
+			if err != nil {
+				return nil, err
+			}
+			return &IfNode{Cond: cond, Then: then, Else: otherwise, Position: n.Position}, nil
+		}
+
+		return node, nil
+	}
+
+	result, err := substitute(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, used, nil
+}
+
+// formatNumber записывает число без потери точности.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}